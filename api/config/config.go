@@ -1,13 +1,23 @@
 package config
 
 import (
+	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Config holds all configuration for the application
 type Config struct {
-	Database DatabaseConfig
-	Server   ServerConfig
+	Database    DatabaseConfig
+	Server      ServerConfig
+	Messages    MessagesConfig
+	Invites     InvitesConfig
+	WebSocket   WebSocketConfig
+	Filters     FiltersConfig
+	Storage     StorageConfig
+	Attachments AttachmentsConfig
 }
 
 // DatabaseConfig holds database configuration
@@ -24,6 +34,103 @@ type ServerConfig struct {
 	Port string
 }
 
+// MessagesConfig holds message handling configuration
+type MessagesConfig struct {
+	// DeletedRetention is how long soft deleted messages are kept before
+	// being purged. Zero disables purging.
+	DeletedRetention time.Duration
+	PurgeInterval    time.Duration
+
+	// RetentionBatchSize is how many expired threads the retention purge
+	// removes per transaction
+	RetentionBatchSize int
+
+	// SchedulerInterval is how often scheduled messages and reminders that
+	// are due are sent. Zero disables sending them.
+	SchedulerInterval time.Duration
+
+	// ExpiryInterval is how often expired ephemeral messages are deleted.
+	// They are hidden as soon as they expire either way.
+	ExpiryInterval time.Duration
+}
+
+// InvitesConfig holds lobby invite link configuration
+type InvitesConfig struct {
+	// Secret signs invite tokens. If unset, a random secret is generated and
+	// invite links stop working when the server restarts.
+	Secret  string
+	BaseURL string
+}
+
+// WebSocketConfig holds WebSocket connection configuration
+type WebSocketConfig struct {
+	// RateLimitBurst is how many requests a user may send to a lobby at once.
+	// Requests are refilled at one per RateLimitInterval.
+	RateLimitBurst    int
+	RateLimitInterval time.Duration
+
+	// MaxFrameSize is the largest frame in bytes a client may send. Larger
+	// frames close the connection.
+	MaxFrameSize int64
+	// MaxMessageLength is the most characters a chat message may have
+	MaxMessageLength int
+}
+
+// FiltersConfig holds the configuration of the built-in message filters.
+// Each filter's action on a match is rewrite, reject or queue.
+type FiltersConfig struct {
+	// Words are blocked words. Rewriting masks them.
+	Words       []string
+	WordsAction string
+
+	// Links to DeniedDomains, or to domains not in AllowedDomains if it is
+	// set, are matched. Rewriting removes them.
+	AllowedDomains []string
+	DeniedDomains  []string
+	LinksAction    string
+
+	// A message sent RepeatLimit times in a row within RepeatWindow is spam
+	RepeatLimit  int
+	RepeatWindow time.Duration
+	RepeatAction string
+
+	// Messages with at least CapsMinLength letters, mostly capitals, are
+	// shouting. Rewriting lowercases them.
+	CapsMinLength int
+	CapsAction    string
+}
+
+// StorageConfig holds the configuration of the storage for uploaded files
+type StorageConfig struct {
+	// Backend is local or s3
+	Backend string
+	// Dir is the directory the local backend stores files in
+	Dir string
+
+	// The s3 backend stores files in Bucket at Endpoint, any S3-compatible
+	// service such as a local MinIO
+	S3Endpoint        string
+	S3Region          string
+	S3Bucket          string
+	S3AccessKeyID     string
+	S3SecretAccessKey string
+}
+
+// AttachmentsConfig holds message attachment limits
+type AttachmentsConfig struct {
+	// MaxSize is the largest file in bytes that may be uploaded
+	MaxSize int64
+	// AllowedTypes are the content types that may be uploaded, as detected
+	// from the file's contents
+	AllowedTypes []string
+	// UnsentTTL is how long uploads that are not sent with a message are
+	// kept before they are deleted
+	UnsentTTL time.Duration
+	// CleanupInterval is how often unsent uploads and the files of deleted
+	// attachments are removed
+	CleanupInterval time.Duration
+}
+
 // LoadConfig loads configuration from environment variables or defaults
 func LoadConfig() *Config {
 	return &Config{
@@ -37,6 +144,52 @@ func LoadConfig() *Config {
 		Server: ServerConfig{
 			Port: getEnv("SERVER_PORT", "8080"),
 		},
+		Messages: MessagesConfig{
+			DeletedRetention: getEnvDuration("DELETED_MESSAGE_RETENTION", 0),
+			PurgeInterval:    getEnvDuration("MESSAGE_PURGE_INTERVAL", time.Hour),
+
+			RetentionBatchSize: getEnvInt("RETENTION_BATCH_SIZE", 100),
+			SchedulerInterval:  getEnvDuration("SCHEDULER_INTERVAL", 5*time.Second),
+			ExpiryInterval:     getEnvDuration("MESSAGE_EXPIRY_INTERVAL", 5*time.Second),
+		},
+		Invites: InvitesConfig{
+			Secret:  getEnv("INVITE_SECRET", ""),
+			BaseURL: getEnv("INVITE_BASE_URL", "http://localhost:3000/invite/"),
+		},
+		WebSocket: WebSocketConfig{
+			RateLimitBurst:    getEnvInt("WS_RATE_LIMIT_BURST", 5),
+			RateLimitInterval: getEnvDuration("WS_RATE_LIMIT_INTERVAL", time.Second),
+			MaxFrameSize:      int64(getEnvInt("WS_MAX_FRAME_SIZE", 64*1024)),
+			MaxMessageLength:  getEnvInt("MAX_MESSAGE_LENGTH", 4000),
+		},
+		Filters: FiltersConfig{
+			Words:          getEnvList("FILTER_WORDS"),
+			WordsAction:    getEnv("FILTER_WORDS_ACTION", "rewrite"),
+			AllowedDomains: getEnvList("FILTER_ALLOWED_DOMAINS"),
+			DeniedDomains:  getEnvList("FILTER_DENIED_DOMAINS"),
+			LinksAction:    getEnv("FILTER_LINKS_ACTION", "reject"),
+			RepeatLimit:    getEnvInt("FILTER_REPEAT_LIMIT", 3),
+			RepeatWindow:   getEnvDuration("FILTER_REPEAT_WINDOW", time.Minute),
+			RepeatAction:   getEnv("FILTER_REPEAT_ACTION", "reject"),
+			CapsMinLength:  getEnvInt("FILTER_CAPS_MIN_LENGTH", 0),
+			CapsAction:     getEnv("FILTER_CAPS_ACTION", "rewrite"),
+		},
+		Storage: StorageConfig{
+			Backend:           getEnv("STORAGE_BACKEND", "local"),
+			Dir:               getEnv("STORAGE_DIR", "./uploads"),
+			S3Endpoint:        getEnv("S3_ENDPOINT", ""),
+			S3Region:          getEnv("S3_REGION", "us-east-1"),
+			S3Bucket:          getEnv("S3_BUCKET", ""),
+			S3AccessKeyID:     getEnv("S3_ACCESS_KEY_ID", ""),
+			S3SecretAccessKey: getEnv("S3_SECRET_ACCESS_KEY", ""),
+		},
+		Attachments: AttachmentsConfig{
+			MaxSize: int64(getEnvInt("ATTACHMENT_MAX_SIZE", 10*1024*1024)),
+			AllowedTypes: getEnvListDefault("ATTACHMENT_TYPES",
+				[]string{"image/png", "image/jpeg", "image/gif", "image/webp", "application/pdf", "text/plain"}),
+			UnsentTTL:       getEnvDuration("ATTACHMENT_UNSENT_TTL", 24*time.Hour),
+			CleanupInterval: getEnvDuration("ATTACHMENT_CLEANUP_INTERVAL", 10*time.Minute),
+		},
 	}
 }
 
@@ -47,3 +200,53 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getEnvList gets a comma-separated environment variable, skipping empty items
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getEnvListDefault gets a comma-separated environment variable or returns
+// a default list if it is unset
+func getEnvListDefault(key string, defaultValue []string) []string {
+	if _, exists := os.LookupEnv(key); !exists {
+		return defaultValue
+	}
+	return getEnvList(key)
+}
+
+// getEnvInt gets an integer environment variable or returns a default value
+func getEnvInt(key string, defaultValue int) int {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid integer for %s, using default: %v", key, err)
+		return defaultValue
+	}
+	return n
+}
+
+// getEnvDuration gets a duration environment variable or returns a default value
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid duration for %s, using default: %v", key, err)
+		return defaultValue
+	}
+	return duration
+}
@@ -0,0 +1,40 @@
+package content
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Content errors
+var (
+	ErrEmpty   = errors.New("message must not be empty")
+	ErrTooLong = errors.New("message is too long")
+)
+
+// Clean prepares message content for storage: it normalizes it to NFC,
+// strips control characters other than newlines and tabs, and trims
+// surrounding whitespace. It returns ErrEmpty if nothing is left and
+// ErrTooLong if the result has more than maxRunes characters.
+func Clean(s string, maxRunes int) (string, error) {
+	s = strings.ToValidUTF8(s, "")
+	s = norm.NFC.String(s)
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && r != '\n' && r != '\t' {
+			return -1
+		}
+		return r
+	}, s)
+	s = strings.TrimSpace(s)
+
+	if s == "" {
+		return "", ErrEmpty
+	}
+	if maxRunes > 0 && utf8.RuneCountInString(s) > maxRunes {
+		return "", ErrTooLong
+	}
+	return s, nil
+}
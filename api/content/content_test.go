@@ -0,0 +1,44 @@
+package content
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestClean(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		maxRunes int
+		want     string
+		wantErr  error
+	}{
+		{"plain text", "hello", 10, "hello", nil},
+		{"trims whitespace", "  hello \n", 10, "hello", nil},
+		{"keeps newlines and tabs", "a\n\tb", 10, "a\n\tb", nil},
+		{"strips control characters", "a\x00b\x1bc\x7f", 10, "abc", nil},
+		{"strips invalid UTF-8", "a\xffb", 10, "ab", nil},
+		{"normalizes to NFC", "e\u0301", 10, "\u00e9", nil},
+		{"empty", "", 10, "", ErrEmpty},
+		{"only whitespace", " \n\t ", 10, "", ErrEmpty},
+		{"only control characters", "\x00\x01", 10, "", ErrEmpty},
+		{"at the limit", "héllo", 5, "héllo", nil},
+		{"counts characters, not bytes", strings.Repeat("é", 5), 5, strings.Repeat("é", 5), nil},
+		{"over the limit", "hello!", 5, "", ErrTooLong},
+		{"limit applies after cleaning", " hello\x00 ", 5, "hello", nil},
+		{"no limit", strings.Repeat("a", 10000), 0, strings.Repeat("a", 10000), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Clean(tt.input, tt.maxRunes)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Clean(%q) error = %v, want %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Clean(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
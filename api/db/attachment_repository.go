@@ -0,0 +1,194 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/galexander77/chat-app/api/models"
+	"github.com/lib/pq"
+)
+
+// Attachment errors
+var (
+	ErrAttachmentNotFound    = errors.New("attachment not found")
+	ErrAttachmentUnavailable = errors.New("attachment not found or already sent")
+)
+
+// AttachmentRepository handles database operations for message attachments
+type AttachmentRepository struct {
+	DB *sql.DB
+}
+
+// NewAttachmentRepository creates a new AttachmentRepository
+func NewAttachmentRepository(db *sql.DB) *AttachmentRepository {
+	return &AttachmentRepository{DB: db}
+}
+
+// attachmentColumns is the column list of attachments a
+const attachmentColumns = `a.id, a.lobby_id, a.message_id, a.uploader_id, a.storage_key, a.filename,
+	a.content_type, a.size, a.created_at`
+
+// scanAttachment scans a row selected with attachmentColumns into an attachment
+func scanAttachment(row rowScanner) (models.Attachment, error) {
+	var attachment models.Attachment
+	var messageID sql.NullInt64
+	err := row.Scan(&attachment.ID, &attachment.LobbyID, &messageID, &attachment.UploaderID, &attachment.StorageKey,
+		&attachment.Filename, &attachment.ContentType, &attachment.Size, &attachment.CreatedAt)
+	if err != nil {
+		return attachment, err
+	}
+
+	if messageID.Valid {
+		id := int(messageID.Int64)
+		attachment.MessageID = &id
+	}
+	attachment.URL = fmt.Sprintf("/api/attachments/%d", attachment.ID)
+
+	return attachment, nil
+}
+
+// CreateAttachment records a file uploaded to a lobby
+func (r *AttachmentRepository) CreateAttachment(lobbyID, uploaderID int, storageKey, filename, contentType string, size int64) (*models.Attachment, error) {
+	row := r.DB.QueryRow(`
+		INSERT INTO attachments AS a (lobby_id, uploader_id, storage_key, filename, content_type, size)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+attachmentColumns,
+		lobbyID, uploaderID, storageKey, filename, contentType, size)
+
+	attachment, err := scanAttachment(row)
+	if err != nil {
+		return nil, fmt.Errorf("error creating attachment: %w", err)
+	}
+
+	return &attachment, nil
+}
+
+// GetAttachment gets an attachment. Attachments of deleted and expired
+// messages are not found.
+func (r *AttachmentRepository) GetAttachment(attachmentID int) (*models.Attachment, error) {
+	row := r.DB.QueryRow(`
+		SELECT `+attachmentColumns+`
+		FROM attachments a
+		LEFT JOIN messages m ON m.id = a.message_id
+		WHERE a.id = $1 AND m.deleted_at IS NULL AND (m.expires_at IS NULL OR m.expires_at > CURRENT_TIMESTAMP)
+	`, attachmentID)
+
+	attachment, err := scanAttachment(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching attachment: %w", err)
+	}
+
+	return &attachment, nil
+}
+
+// GetMessageAttachments gets the attachments sent with a message
+func (r *AttachmentRepository) GetMessageAttachments(messageID int) ([]models.Attachment, error) {
+	rows, err := r.DB.Query(`
+		SELECT `+attachmentColumns+`
+		FROM attachments a
+		WHERE a.message_id = $1
+		ORDER BY a.id
+	`, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching attachments: %w", err)
+	}
+	defer rows.Close()
+
+	var attachments []models.Attachment
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning attachment data: %w", err)
+		}
+		attachments = append(attachments, attachment)
+	}
+
+	return attachments, nil
+}
+
+// linkAttachments links a user's unsent uploads in a lobby to the message
+// they are sent with. It returns ErrAttachmentUnavailable unless every
+// attachment could be linked.
+func linkAttachments(tx *sql.Tx, messageID, lobbyID, uploaderID int, attachmentIDs []int) error {
+	ids := uniqueSorted(attachmentIDs)
+	result, err := tx.Exec(`
+		UPDATE attachments SET message_id = $1
+		WHERE id = ANY($2) AND lobby_id = $3 AND uploader_id = $4 AND message_id IS NULL
+	`, messageID, pq.Array(ids), lobbyID, uploaderID)
+	if err != nil {
+		return fmt.Errorf("error linking attachments: %w", err)
+	}
+
+	linked, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error checking linked attachments: %w", err)
+	}
+	if linked != int64(len(ids)) {
+		return ErrAttachmentUnavailable
+	}
+	return nil
+}
+
+// DeleteUnsentAttachments permanently removes up to limit uploads that were
+// never sent with a message and are older than ttl, oldest first. Their
+// files are queued for removal.
+func (r *AttachmentRepository) DeleteUnsentAttachments(ttl time.Duration, limit int) (int64, error) {
+	result, err := r.DB.Exec(`
+		DELETE FROM attachments
+		WHERE id IN (
+			SELECT id FROM attachments
+			WHERE message_id IS NULL AND created_at < CURRENT_TIMESTAMP - make_interval(secs => $1)
+			ORDER BY created_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+	`, ttl.Seconds(), limit)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting unsent attachments: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// GetDeletedFiles gets the storage keys of up to limit deleted attachments
+// whose files are still to be removed, oldest first
+func (r *AttachmentRepository) GetDeletedFiles(limit int) ([]string, error) {
+	rows, err := r.DB.Query(`
+		SELECT storage_key FROM deleted_attachment_files
+		ORDER BY deleted_at
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching deleted attachment files: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, fmt.Errorf("error scanning deleted attachment file: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading deleted attachment files: %w", err)
+	}
+
+	return keys, nil
+}
+
+// ForgetDeletedFile removes a storage key from the removal queue once its
+// file has been removed
+func (r *AttachmentRepository) ForgetDeletedFile(storageKey string) error {
+	_, err := r.DB.Exec("DELETE FROM deleted_attachment_files WHERE storage_key = $1", storageKey)
+	if err != nil {
+		return fmt.Errorf("error forgetting deleted attachment file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/galexander77/chat-app/api/models"
+	"github.com/lib/pq"
+)
+
+// ErrBlockNotFound is returned when unblocking a user who is not blocked
+var ErrBlockNotFound = errors.New("user is not blocked")
+
+// BlockRepository handles database operations for users blocking each other
+type BlockRepository struct {
+	DB *sql.DB
+}
+
+// NewBlockRepository creates a new BlockRepository
+func NewBlockRepository(db *sql.DB) *BlockRepository {
+	return &BlockRepository{DB: db}
+}
+
+// notBlockedBy is a condition excluding messages m whose author is blocked
+// by the user in the given query parameter
+func notBlockedBy(param string) string {
+	return "NOT EXISTS (SELECT 1 FROM user_blocks b WHERE b.blocker_id = " + param + " AND b.blocked_id = m.user_id)"
+}
+
+// BlockUser blocks a user. Blocking a user again has no effect.
+func (r *BlockRepository) BlockUser(blockerID, blockedID int) error {
+	_, err := r.DB.Exec(`
+		INSERT INTO user_blocks (blocker_id, blocked_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`, blockerID, blockedID)
+	if err != nil {
+		return fmt.Errorf("error blocking user: %w", err)
+	}
+	return nil
+}
+
+// UnblockUser unblocks a user
+func (r *BlockRepository) UnblockUser(blockerID, blockedID int) error {
+	result, err := r.DB.Exec("DELETE FROM user_blocks WHERE blocker_id = $1 AND blocked_id = $2", blockerID, blockedID)
+	if err != nil {
+		return fmt.Errorf("error unblocking user: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error checking unblock result: %w", err)
+	}
+	if rows == 0 {
+		return ErrBlockNotFound
+	}
+	return nil
+}
+
+// GetBlocks gets the users a user has blocked, most recent first
+func (r *BlockRepository) GetBlocks(blockerID int) ([]models.Block, error) {
+	rows, err := r.DB.Query(`
+		SELECT u.id, u.username, b.created_at
+		FROM user_blocks b
+		JOIN users u ON u.id = b.blocked_id
+		WHERE b.blocker_id = $1
+		ORDER BY b.created_at DESC
+	`, blockerID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching blocks: %w", err)
+	}
+	defer rows.Close()
+
+	blocks := []models.Block{}
+	for rows.Next() {
+		var block models.Block
+		if err := rows.Scan(&block.UserID, &block.Username, &block.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning block data: %w", err)
+		}
+		blocks = append(blocks, block)
+	}
+
+	return blocks, nil
+}
+
+// GetBlockedIDs gets the IDs of the users a user has blocked
+func (r *BlockRepository) GetBlockedIDs(blockerID int) ([]int, error) {
+	rows, err := r.DB.Query("SELECT blocked_id FROM user_blocks WHERE blocker_id = $1", blockerID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching blocked users: %w", err)
+	}
+	defer rows.Close()
+
+	var userIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error scanning blocked user: %w", err)
+		}
+		userIDs = append(userIDs, id)
+	}
+
+	return userIDs, nil
+}
+
+// ExcludeBlockers filters a list of user IDs down to those who have not
+// blocked the given user
+func (r *BlockRepository) ExcludeBlockers(blockedID int, userIDs []int) ([]int, error) {
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+
+	rows, err := r.DB.Query(`
+		SELECT u.id FROM unnest($1::int[]) AS u(id)
+		WHERE NOT EXISTS (SELECT 1 FROM user_blocks b WHERE b.blocker_id = u.id AND b.blocked_id = $2)
+	`, pq.Array(userIDs), blockedID)
+	if err != nil {
+		return nil, fmt.Errorf("error checking blocks: %w", err)
+	}
+	defer rows.Close()
+
+	var allowed []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error scanning user ID: %w", err)
+		}
+		allowed = append(allowed, id)
+	}
+
+	return allowed, nil
+}
+
+// IsBlockedByAny reports whether any of the given users has blocked a user
+func (r *BlockRepository) IsBlockedByAny(userIDs []int, blockedID int) (bool, error) {
+	var blocked bool
+	err := r.DB.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM user_blocks WHERE blocker_id = ANY($1) AND blocked_id = $2)
+	`, pq.Array(userIDs), blockedID).Scan(&blocked)
+	if err != nil {
+		return false, fmt.Errorf("error checking blocks: %w", err)
+	}
+	return blocked, nil
+}
+
+// IsBlockedByMember reports whether any member of a lobby has blocked a user
+func (r *BlockRepository) IsBlockedByMember(lobbyID, blockedID int) (bool, error) {
+	var blocked bool
+	err := r.DB.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM user_blocks b
+			JOIN lobby_members lm ON lm.user_id = b.blocker_id
+			WHERE lm.lobby_id = $1 AND b.blocked_id = $2
+		)
+	`, lobbyID, blockedID).Scan(&blocked)
+	if err != nil {
+		return false, fmt.Errorf("error checking blocks: %w", err)
+	}
+	return blocked, nil
+}
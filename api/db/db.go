@@ -44,7 +44,7 @@ func CreateTables(db *sql.DB) error {
 	_, err = db.Exec(`
 		CREATE TABLE IF NOT EXISTS lobbies (
 			id SERIAL PRIMARY KEY,
-			name VARCHAR(50) UNIQUE NOT NULL
+			name VARCHAR(50) NOT NULL
 		)
 	`)
 	if err != nil {
@@ -65,5 +65,442 @@ func CreateTables(db *sql.DB) error {
 		return fmt.Errorf("error creating messages table: %w", err)
 	}
 
+	// Add user roles
+	_, err = db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'user'`)
+	if err != nil {
+		return fmt.Errorf("error adding role to users table: %w", err)
+	}
+
+	// Add soft delete columns to messages
+	_, err = db.Exec(`
+		ALTER TABLE messages
+			ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP,
+			ADD COLUMN IF NOT EXISTS deleted_by INTEGER REFERENCES users(id)
+	`)
+	if err != nil {
+		return fmt.Errorf("error adding soft delete columns to messages table: %w", err)
+	}
+
+	// Add thread parent to messages
+	_, err = db.Exec(`ALTER TABLE messages ADD COLUMN IF NOT EXISTS parent_id INTEGER REFERENCES messages(id) ON DELETE CASCADE`)
+	if err != nil {
+		return fmt.Errorf("error adding parent_id to messages table: %w", err)
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS messages_parent_id_idx ON messages (parent_id)`)
+	if err != nil {
+		return fmt.Errorf("error creating messages parent_id index: %w", err)
+	}
+
+	// Create message reactions table
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS message_reactions (
+			message_id INTEGER REFERENCES messages(id) ON DELETE CASCADE,
+			user_id INTEGER REFERENCES users(id),
+			emoji VARCHAR(64) NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (message_id, user_id, emoji)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating message_reactions table: %w", err)
+	}
+
+	// Add conversation kind to lobbies. Direct message conversations are
+	// identified by a key built from their sorted member IDs.
+	_, err = db.Exec(`
+		ALTER TABLE lobbies
+			ADD COLUMN IF NOT EXISTS kind VARCHAR(10) NOT NULL DEFAULT 'lobby',
+			ADD COLUMN IF NOT EXISTS dm_key TEXT UNIQUE
+	`)
+	if err != nil {
+		return fmt.Errorf("error adding kind to lobbies table: %w", err)
+	}
+
+	// Lobby names are unique, but direct messages are named after their ID
+	// and must not collide with lobbies named the same way
+	_, err = db.Exec(`ALTER TABLE lobbies DROP CONSTRAINT IF EXISTS lobbies_name_key`)
+	if err != nil {
+		return fmt.Errorf("error dropping lobbies name constraint: %w", err)
+	}
+	_, err = db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS lobbies_name_idx ON lobbies (name) WHERE kind <> 'dm'`)
+	if err != nil {
+		return fmt.Errorf("error creating lobbies name index: %w", err)
+	}
+
+	// Create lobby members table
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS lobby_members (
+			lobby_id INTEGER REFERENCES lobbies(id) ON DELETE CASCADE,
+			user_id INTEGER REFERENCES users(id),
+			joined_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (lobby_id, user_id)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating lobby_members table: %w", err)
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS lobby_members_user_id_idx ON lobby_members (user_id)`)
+	if err != nil {
+		return fmt.Errorf("error creating lobby_members user_id index: %w", err)
+	}
+
+	// Add visibility to lobbies
+	_, err = db.Exec(`ALTER TABLE lobbies ADD COLUMN IF NOT EXISTS visibility VARCHAR(10) NOT NULL DEFAULT 'public'`)
+	if err != nil {
+		return fmt.Errorf("error adding visibility to lobbies table: %w", err)
+	}
+
+	// Add details and archiving to lobbies
+	_, err = db.Exec(`
+		ALTER TABLE lobbies
+			ADD COLUMN IF NOT EXISTS topic TEXT NOT NULL DEFAULT '',
+			ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '',
+			ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP
+	`)
+	if err != nil {
+		return fmt.Errorf("error adding details to lobbies table: %w", err)
+	}
+
+	// Create lobby invites table
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS lobby_invites (
+			id SERIAL PRIMARY KEY,
+			lobby_id INTEGER REFERENCES lobbies(id) ON DELETE CASCADE,
+			created_by INTEGER REFERENCES users(id),
+			expires_at TIMESTAMP NOT NULL,
+			max_uses INTEGER,
+			uses INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating lobby_invites table: %w", err)
+	}
+
+	// Create lobby restrictions table for bans and mutes
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS lobby_restrictions (
+			lobby_id INTEGER REFERENCES lobbies(id) ON DELETE CASCADE,
+			user_id INTEGER REFERENCES users(id),
+			kind VARCHAR(10) NOT NULL,
+			reason TEXT NOT NULL DEFAULT '',
+			created_by INTEGER REFERENCES users(id),
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			expires_at TIMESTAMP,
+			PRIMARY KEY (lobby_id, user_id, kind)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating lobby_restrictions table: %w", err)
+	}
+
+	// Create moderation audit log table
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS moderation_log (
+			id SERIAL PRIMARY KEY,
+			lobby_id INTEGER REFERENCES lobbies(id) ON DELETE CASCADE,
+			moderator_id INTEGER REFERENCES users(id),
+			user_id INTEGER REFERENCES users(id),
+			action VARCHAR(20) NOT NULL,
+			reason TEXT NOT NULL DEFAULT '',
+			expires_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating moderation_log table: %w", err)
+	}
+
+	// Create mentions table
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS mentions (
+			id SERIAL PRIMARY KEY,
+			message_id INTEGER REFERENCES messages(id) ON DELETE CASCADE,
+			user_id INTEGER REFERENCES users(id),
+			kind VARCHAR(10) NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			read_at TIMESTAMP,
+			UNIQUE (message_id, user_id)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating mentions table: %w", err)
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS mentions_user_id_idx ON mentions (user_id, id)`)
+	if err != nil {
+		return fmt.Errorf("error creating mentions user_id index: %w", err)
+	}
+
+	// Create lobby emojis table
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS lobby_emojis (
+			id SERIAL PRIMARY KEY,
+			lobby_id INTEGER REFERENCES lobbies(id) ON DELETE CASCADE,
+			name VARCHAR(32) NOT NULL,
+			url TEXT NOT NULL,
+			created_by INTEGER REFERENCES users(id),
+			UNIQUE (lobby_id, name)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating lobby_emojis table: %w", err)
+	}
+
+	// Index messages by lobby for history and the latest message in the lobby listing
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS messages_lobby_id_idx ON messages (lobby_id, id)`)
+	if err != nil {
+		return fmt.Errorf("error creating messages lobby_id index: %w", err)
+	}
+
+	// Add creator, ownership and settings to lobbies
+	_, err = db.Exec(`
+		ALTER TABLE lobbies
+			ADD COLUMN IF NOT EXISTS created_by INTEGER REFERENCES users(id),
+			ADD COLUMN IF NOT EXISTS created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			ADD COLUMN IF NOT EXISTS owner_id INTEGER REFERENCES users(id),
+			ADD COLUMN IF NOT EXISTS settings JSONB NOT NULL DEFAULT '{}'
+	`)
+	if err != nil {
+		return fmt.Errorf("error adding ownership to lobbies table: %w", err)
+	}
+
+	// Create reports table for the moderation review queue. Reports from
+	// filters have no reporter.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS reports (
+			id SERIAL PRIMARY KEY,
+			message_id INTEGER REFERENCES messages(id) ON DELETE CASCADE,
+			lobby_id INTEGER REFERENCES lobbies(id) ON DELETE CASCADE,
+			source VARCHAR(10) NOT NULL,
+			reporter_id INTEGER REFERENCES users(id),
+			filter VARCHAR(32) NOT NULL DEFAULT '',
+			reason VARCHAR(20) NOT NULL,
+			details TEXT NOT NULL DEFAULT '',
+			status VARCHAR(10) NOT NULL DEFAULT 'open',
+			claimed_by INTEGER REFERENCES users(id),
+			claimed_at TIMESTAMP,
+			resolved_by INTEGER REFERENCES users(id),
+			resolved_at TIMESTAMP,
+			resolution VARCHAR(20) NOT NULL DEFAULT '',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (message_id, reporter_id)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating reports table: %w", err)
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS reports_lobby_id_idx ON reports (lobby_id, status, id)`)
+	if err != nil {
+		return fmt.Errorf("error creating reports lobby_id index: %w", err)
+	}
+
+	// Create user blocks table
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS user_blocks (
+			blocker_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			blocked_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (blocker_id, blocked_id)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating user_blocks table: %w", err)
+	}
+
+	// Index message content for full-text search
+	_, err = db.Exec(`
+		ALTER TABLE messages
+		ADD COLUMN IF NOT EXISTS search tsvector GENERATED ALWAYS AS (to_tsvector('english', content)) STORED
+	`)
+	if err != nil {
+		return fmt.Errorf("error adding messages search column: %w", err)
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS messages_search_idx ON messages USING GIN (search)`)
+	if err != nil {
+		return fmt.Errorf("error creating messages search index: %w", err)
+	}
+
+	// Create attachments table. Attachments are uploaded before the message
+	// they are sent with, so message_id starts out NULL.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS attachments (
+			id SERIAL PRIMARY KEY,
+			lobby_id INTEGER NOT NULL REFERENCES lobbies(id) ON DELETE CASCADE,
+			message_id INTEGER REFERENCES messages(id) ON DELETE CASCADE,
+			uploader_id INTEGER NOT NULL REFERENCES users(id),
+			storage_key VARCHAR(255) NOT NULL UNIQUE,
+			filename VARCHAR(255) NOT NULL,
+			content_type VARCHAR(100) NOT NULL,
+			size BIGINT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating attachments table: %w", err)
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS attachments_message_id_idx ON attachments (message_id)`)
+	if err != nil {
+		return fmt.Errorf("error creating attachments message_id index: %w", err)
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS attachments_unsent_idx ON attachments (created_at) WHERE message_id IS NULL`)
+	if err != nil {
+		return fmt.Errorf("error creating attachments unsent index: %w", err)
+	}
+
+	// Queue the stored files of deleted attachments for removal. A trigger
+	// catches every deletion, including those cascading from purged,
+	// expired and retention-deleted messages and from deleted lobbies.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS deleted_attachment_files (
+			storage_key VARCHAR(255) PRIMARY KEY,
+			deleted_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating deleted_attachment_files table: %w", err)
+	}
+	_, err = db.Exec(`
+		CREATE OR REPLACE FUNCTION queue_deleted_attachment_file() RETURNS trigger AS $$
+		BEGIN
+			INSERT INTO deleted_attachment_files (storage_key) VALUES (OLD.storage_key) ON CONFLICT DO NOTHING;
+			RETURN OLD;
+		END
+		$$ LANGUAGE plpgsql;
+		DROP TRIGGER IF EXISTS attachments_deleted ON attachments;
+		CREATE TRIGGER attachments_deleted AFTER DELETE ON attachments
+			FOR EACH ROW EXECUTE FUNCTION queue_deleted_attachment_file();
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating attachments delete trigger: %w", err)
+	}
+
+	// Add message pins
+	_, err = db.Exec(`
+		ALTER TABLE messages
+		ADD COLUMN IF NOT EXISTS pinned_at TIMESTAMP,
+		ADD COLUMN IF NOT EXISTS pinned_by INTEGER REFERENCES users(id)
+	`)
+	if err != nil {
+		return fmt.Errorf("error adding message pin columns: %w", err)
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS messages_pinned_idx ON messages (lobby_id, pinned_at) WHERE pinned_at IS NOT NULL`)
+	if err != nil {
+		return fmt.Errorf("error creating messages pinned index: %w", err)
+	}
+
+	// Create retention policies table. The default policy has no lobby and
+	// the partial index keeps it unique.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS retention_policies (
+			lobby_id INTEGER UNIQUE REFERENCES lobbies(id) ON DELETE CASCADE,
+			keep VARCHAR(10) NOT NULL,
+			days INTEGER NOT NULL DEFAULT 0,
+			messages INTEGER NOT NULL DEFAULT 0,
+			archive BOOLEAN NOT NULL DEFAULT false,
+			updated_by INTEGER REFERENCES users(id),
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating retention_policies table: %w", err)
+	}
+	_, err = db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS retention_policies_default_idx ON retention_policies ((lobby_id IS NULL)) WHERE lobby_id IS NULL`)
+	if err != nil {
+		return fmt.Errorf("error creating retention_policies default index: %w", err)
+	}
+
+	// Create archived messages table for messages expired by an archiving
+	// retention policy
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS archived_messages (
+			id INTEGER PRIMARY KEY,
+			content TEXT NOT NULL,
+			user_id INTEGER REFERENCES users(id),
+			lobby_id INTEGER REFERENCES lobbies(id) ON DELETE CASCADE,
+			timestamp TIMESTAMP,
+			parent_id INTEGER,
+			deleted_at TIMESTAMP,
+			deleted_by INTEGER REFERENCES users(id),
+			archived_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating archived_messages table: %w", err)
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS messages_lobby_timestamp_idx ON messages (lobby_id, timestamp, id) WHERE parent_id IS NULL`)
+	if err != nil {
+		return fmt.Errorf("error creating messages timestamp index: %w", err)
+	}
+
+	// Create scheduled messages table, which also holds reminders about
+	// messages
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS scheduled_messages (
+			id SERIAL PRIMARY KEY,
+			kind VARCHAR(10) NOT NULL,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			lobby_id INTEGER NOT NULL REFERENCES lobbies(id) ON DELETE CASCADE,
+			content TEXT NOT NULL DEFAULT '',
+			parent_id INTEGER REFERENCES messages(id) ON DELETE CASCADE,
+			message_id INTEGER REFERENCES messages(id) ON DELETE CASCADE,
+			send_at TIMESTAMP NOT NULL,
+			status VARCHAR(10) NOT NULL DEFAULT 'pending',
+			sent_message_id INTEGER REFERENCES messages(id) ON DELETE SET NULL,
+			error TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			sent_at TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating scheduled_messages table: %w", err)
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS scheduled_messages_due_idx ON scheduled_messages (send_at) WHERE status = 'pending'`)
+	if err != nil {
+		return fmt.Errorf("error creating scheduled_messages due index: %w", err)
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS scheduled_messages_user_id_idx ON scheduled_messages (user_id, status, send_at)`)
+	if err != nil {
+		return fmt.Errorf("error creating scheduled_messages user_id index: %w", err)
+	}
+
+	// Add expiry time to ephemeral messages
+	_, err = db.Exec(`ALTER TABLE messages ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP`)
+	if err != nil {
+		return fmt.Errorf("error adding expires_at to messages table: %w", err)
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS messages_expires_at_idx ON messages (expires_at) WHERE expires_at IS NOT NULL`)
+	if err != nil {
+		return fmt.Errorf("error creating messages expires_at index: %w", err)
+	}
+
+	// Create saved messages table. Saved messages outlive the messages, so
+	// users see that a message they saved is gone; the lobby is kept for
+	// the access check.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS saved_messages (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			message_id INTEGER NOT NULL,
+			lobby_id INTEGER NOT NULL REFERENCES lobbies(id) ON DELETE CASCADE,
+			note TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (user_id, message_id)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating saved_messages table: %w", err)
+	}
+
+	// Add import keys to messages so re-running an import skips the
+	// messages it already imported into a lobby
+	_, err = db.Exec(`ALTER TABLE messages ADD COLUMN IF NOT EXISTS import_key VARCHAR(255)`)
+	if err != nil {
+		return fmt.Errorf("error adding import_key to messages table: %w", err)
+	}
+	_, err = db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS messages_import_key_idx ON messages (lobby_id, import_key) WHERE import_key IS NOT NULL`)
+	if err != nil {
+		return fmt.Errorf("error creating messages import_key index: %w", err)
+	}
+
 	return nil
 }
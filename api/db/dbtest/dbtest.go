@@ -0,0 +1,87 @@
+// Package dbtest connects tests to the PostgreSQL database named by the
+// TEST_DATABASE_URL environment variable, skipping them when it is not set.
+// Tests share the database, so they create their rows with unique names
+// instead of relying on empty tables.
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/galexander77/chat-app/api/db"
+)
+
+// schemaLock is the advisory lock key held while creating the tables, as
+// the packages under test run at the same time
+const schemaLock = 7_700_001
+
+var (
+	setup    sync.Once
+	setupErr error
+	names    atomic.Int64
+)
+
+// Open connects to the test database, creating the tables on first use.
+// The connection is closed when the test ends.
+func Open(t testing.TB) *sql.DB {
+	t.Helper()
+
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	database, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatalf("error opening test database: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	setup.Do(func() { setupErr = createTables(database) })
+	if setupErr != nil {
+		t.Fatalf("error creating tables: %v", setupErr)
+	}
+	return database
+}
+
+// createTables creates the tables while holding the schema lock
+func createTables(database *sql.DB) error {
+	ctx := context.Background()
+	conn, err := database.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", schemaLock); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", schemaLock)
+
+	return db.CreateTables(database)
+}
+
+// Name returns a name no other test run uses, short enough for usernames
+// and lobby names. It is made of letters and digits only, so it can be
+// searched for.
+func Name(prefix string) string {
+	return fmt.Sprintf("%s%s%s", prefix, strconv.FormatInt(time.Now().UnixNano(), 36), strconv.FormatInt(names.Add(1), 36))
+}
+
+// CreateUser creates a user with a unique name, returning its ID
+func CreateUser(t testing.TB, database *sql.DB) int {
+	t.Helper()
+
+	userID, err := db.NewUserRepository(database).CreateUser(Name("user"), "password")
+	if err != nil {
+		t.Fatalf("error creating user: %v", err)
+	}
+	return userID
+}
@@ -2,9 +2,26 @@ package db
 
 import (
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/galexander77/chat-app/api/models"
+	"github.com/lib/pq"
+)
+
+// Lobby errors
+var (
+	ErrLobbyNotFound     = errors.New("lobby not found")
+	ErrInviteUnavailable = errors.New("invite is expired, used up or does not exist")
+	ErrInvalidCursor     = errors.New("invalid cursor")
+	ErrLobbyFull         = errors.New("lobby has reached its member limit")
+	ErrLobbyNameTaken    = errors.New("lobby name is already taken")
 )
 
 // LobbyRepository handles database operations for lobbies
@@ -17,33 +34,744 @@ func NewLobbyRepository(db *sql.DB) *LobbyRepository {
 	return &LobbyRepository{DB: db}
 }
 
-// GetAllLobbies gets all lobbies from the database
-func (r *LobbyRepository) GetAllLobbies() ([]models.Lobby, error) {
-	rows, err := r.DB.Query("SELECT id, name FROM lobbies")
+// lobbyColumns is the column list shared by lobby queries on lobbies l
+const lobbyColumns = `l.id, l.name, l.kind, l.visibility, l.topic, l.description, l.archived_at,
+	l.created_by, l.created_at, l.owner_id, l.settings`
+
+// scanLobby scans a row selected with lobbyColumns into a lobby.
+// Destinations for any columns selected after lobbyColumns are passed as extra.
+func scanLobby(row rowScanner, extra ...interface{}) (models.Lobby, error) {
+	var lobby models.Lobby
+	var archivedAt sql.NullTime
+	var createdBy, ownerID sql.NullInt64
+	var settings []byte
+	dest := []interface{}{&lobby.ID, &lobby.Name, &lobby.Kind, &lobby.Visibility, &lobby.Topic, &lobby.Description,
+		&archivedAt, &createdBy, &lobby.CreatedAt, &ownerID, &settings}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return lobby, err
+	}
+
+	if archivedAt.Valid {
+		lobby.ArchivedAt = &archivedAt.Time
+	}
+	if createdBy.Valid {
+		id := int(createdBy.Int64)
+		lobby.CreatedBy = &id
+	}
+	if ownerID.Valid {
+		id := int(ownerID.Int64)
+		lobby.OwnerID = &id
+	}
+	if err := json.Unmarshal(settings, &lobby.Settings); err != nil {
+		return lobby, fmt.Errorf("error decoding lobby settings: %w", err)
+	}
+	if lobby.Settings.PostPolicy == "" {
+		lobby.Settings.PostPolicy = models.PostPolicyEveryone
+	}
+	return lobby, nil
+}
+
+// nullableID converts an ID to a nullable column value, treating
+// non-positive IDs as NULL
+func nullableID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id > 0}
+}
+
+// insertMember is the statement adding user $2 to lobby $1 unless the lobby
+// has reached its member limit. It affects no rows if the user is already a
+// member or the lobby is full. Run it through addMember, which locks the
+// lobby first so concurrent joins cannot exceed the limit.
+const insertMember = `
+	INSERT INTO lobby_members (lobby_id, user_id)
+	SELECT l.id, $2 FROM lobbies l
+	WHERE l.id = $1 AND (
+		COALESCE((l.settings->>'max_members')::int, 0) <= 0
+		OR (SELECT COUNT(*) FROM lobby_members lm WHERE lm.lobby_id = l.id) < (l.settings->>'max_members')::int
+	)
+	ON CONFLICT DO NOTHING`
+
+// previewLength is the number of characters of a lobby's latest message
+// included in the lobby listing
+const previewLength = 140
+
+// lobbySort describes a lobby listing order: the key lobbies are sorted by,
+// its SQL type and whether it sorts in descending order. Ties are broken by ID.
+type lobbySort struct {
+	key     string
+	keyType string
+	desc    bool
+}
+
+// lobbySorts maps lobby listing sort orders to their keys. Activity is the
+// ID of the latest message, since message IDs increase over time.
+var lobbySorts = map[string]lobbySort{
+	models.LobbySortActivity: {key: "COALESCE(l.last_message_id, 0)", keyType: "bigint", desc: true},
+	models.LobbySortName:     {key: "lower(l.name)", keyType: "text"},
+	models.LobbySortMembers:  {key: "l.member_count", keyType: "bigint", desc: true},
+}
+
+// lobbyCursor is the position after the last lobby of a listing page
+type lobbyCursor struct {
+	Sort string `json:"s"`
+	Key  string `json:"k"`
+	ID   int    `json:"id"`
+}
+
+// encode encodes a cursor as an opaque URL-safe string
+func (c lobbyCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeLobbyCursor decodes a cursor returned by a previous listing page with
+// the same sort order
+func decodeLobbyCursor(s, sort string) (lobbyCursor, error) {
+	var c lobbyCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(data, &c) != nil || c.Sort != sort {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// likeEscaper escapes LIKE wildcards in search terms
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// GetAllLobbies gets a page of lobbies, excluding direct message conversations,
+// private lobbies the user is not a member of and, unless IncludeArchived is
+// set, archived lobbies. It returns the cursor of the next page, or an empty
+// string on the last page.
+func (r *LobbyRepository) GetAllLobbies(opts models.LobbyListOptions) ([]models.LobbySummary, string, error) {
+	if opts.Sort == "" {
+		opts.Sort = models.LobbySortActivity
+	}
+	order, ok := lobbySorts[opts.Sort]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown lobby sort: %s", opts.Sort)
+	}
+
+	search := ""
+	if opts.Query != "" {
+		search = "%" + likeEscaper.Replace(opts.Query) + "%"
+	}
+
+	args := []interface{}{models.LobbyKindDM, models.VisibilityPublic, opts.UserID, opts.IncludeArchived,
+		previewLength, search, opts.Limit + 1}
+	direction, cmp := "ASC", ">"
+	if order.desc {
+		direction, cmp = "DESC", "<"
+	}
+
+	after := ""
+	if opts.Cursor != "" {
+		cursor, err := decodeLobbyCursor(opts.Cursor, opts.Sort)
+		if err != nil {
+			return nil, "", err
+		}
+		after = fmt.Sprintf("WHERE (%s, l.id) %s ($8::%s, $9)", order.key, cmp, order.keyType)
+		args = append(args, cursor.Key, cursor.ID)
+	}
+
+	rows, err := r.DB.Query(`
+		SELECT `+lobbyColumns+`, l.member_count, l.last_message_id, l.last_user_id, l.last_username,
+			l.last_content, l.last_timestamp, (`+order.key+`)::text
+		FROM (
+			SELECT `+lobbyColumns+`,
+				(SELECT COUNT(*) FROM lobby_members lm WHERE lm.lobby_id = l.id) AS member_count,
+				latest.id AS last_message_id, latest.user_id AS last_user_id, latest.username AS last_username,
+				latest.content AS last_content, latest.timestamp AS last_timestamp
+			FROM lobbies l
+			LEFT JOIN LATERAL (
+				SELECT m.id, m.user_id, u.username, left(m.content, $5) AS content, m.timestamp
+				FROM messages m
+				JOIN users u ON m.user_id = u.id
+				WHERE m.lobby_id = l.id AND m.parent_id IS NULL AND m.deleted_at IS NULL
+					AND (m.expires_at IS NULL OR m.expires_at > CURRENT_TIMESTAMP)
+				ORDER BY m.id DESC
+				LIMIT 1
+			) latest ON true
+			WHERE l.kind <> $1 AND (l.visibility = $2 OR EXISTS (
+				SELECT 1 FROM lobby_members lm WHERE lm.lobby_id = l.id AND lm.user_id = $3
+			)) AND ($4 OR l.archived_at IS NULL)
+			AND ($6 = '' OR l.name ILIKE $6 OR l.topic ILIKE $6)
+		) l
+		`+after+`
+		ORDER BY `+order.key+` `+direction+`, l.id `+direction+`
+		LIMIT $7
+	`, args...)
 	if err != nil {
-		return nil, fmt.Errorf("error fetching lobbies: %w", err)
+		return nil, "", fmt.Errorf("error fetching lobbies: %w", err)
 	}
 	defer rows.Close()
 
-	var lobbies []models.Lobby
+	var lobbies []models.LobbySummary
+	var next lobbyCursor
 	for rows.Next() {
-		var lobby models.Lobby
-		if err := rows.Scan(&lobby.ID, &lobby.Name); err != nil {
-			return nil, fmt.Errorf("error scanning lobby data: %w", err)
+		// The extra row only tells us there is another page
+		if len(lobbies) == opts.Limit {
+			return lobbies, next.encode(), nil
+		}
+
+		var lobby models.LobbySummary
+		var lastID, lastUserID sql.NullInt64
+		var lastUsername, lastContent sql.NullString
+		var lastTimestamp sql.NullTime
+		var key string
+		lobby.Lobby, err = scanLobby(rows, &lobby.MemberCount, &lastID, &lastUserID, &lastUsername,
+			&lastContent, &lastTimestamp, &key)
+		if err != nil {
+			return nil, "", fmt.Errorf("error scanning lobby data: %w", err)
+		}
+		if lastID.Valid {
+			lobby.LastMessage = &models.MessagePreview{
+				ID:        int(lastID.Int64),
+				UserID:    int(lastUserID.Int64),
+				Username:  lastUsername.String,
+				Content:   lastContent.String,
+				Timestamp: lastTimestamp.Time,
+			}
 		}
 		lobbies = append(lobbies, lobby)
+		next = lobbyCursor{Sort: opts.Sort, Key: key, ID: lobby.ID}
 	}
 
-	return lobbies, nil
+	return lobbies, "", nil
 }
 
-// CreateLobby creates a new lobby in the database
-func (r *LobbyRepository) CreateLobby(name string) (int, error) {
+// GetLobbyByID gets a lobby by ID
+func (r *LobbyRepository) GetLobbyByID(lobbyID int) (*models.Lobby, error) {
+	lobby, err := scanLobby(r.DB.QueryRow("SELECT "+lobbyColumns+" FROM lobbies l WHERE l.id = $1", lobbyID))
+	if err == sql.ErrNoRows {
+		return nil, ErrLobbyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting lobby: %w", err)
+	}
+
+	return &lobby, nil
+}
+
+// GetLobbyByName gets a lobby by its unique name
+func (r *LobbyRepository) GetLobbyByName(name string) (*models.Lobby, error) {
+	lobby, err := scanLobby(r.DB.QueryRow("SELECT "+lobbyColumns+" FROM lobbies l WHERE l.name = $1 AND l.kind <> $2",
+		name, models.LobbyKindDM))
+	if err == sql.ErrNoRows {
+		return nil, ErrLobbyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting lobby: %w", err)
+	}
+
+	return &lobby, nil
+}
+
+// CreateLobby creates a new lobby in the database. A positive creatorID
+// makes the creator the lobby's owner and first member.
+func (r *LobbyRepository) CreateLobby(name, visibility string, creatorID int, settings models.LobbySettings) (int, error) {
+	settingsJSON, err := json.Marshal(settings)
+	if err != nil {
+		return 0, fmt.Errorf("error encoding lobby settings: %w", err)
+	}
+
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
 	var lobbyID int
-	err := r.DB.QueryRow("INSERT INTO lobbies (name) VALUES ($1) RETURNING id", name).Scan(&lobbyID)
+	err = tx.QueryRow(`
+		INSERT INTO lobbies (name, visibility, created_by, owner_id, settings)
+		VALUES ($1, $2, $3, $3, $4)
+		RETURNING id
+	`, name, visibility, nullableID(creatorID), string(settingsJSON)).Scan(&lobbyID)
+	if isUniqueViolation(err) {
+		return 0, ErrLobbyNameTaken
+	}
 	if err != nil {
 		return 0, fmt.Errorf("error creating lobby: %w", err)
 	}
 
+	if creatorID > 0 {
+		_, err = tx.Exec("INSERT INTO lobby_members (lobby_id, user_id) VALUES ($1, $2)", lobbyID, creatorID)
+		if err != nil {
+			return 0, fmt.Errorf("error adding lobby creator: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing transaction: %w", err)
+	}
+
 	return lobbyID, nil
 }
+
+// UpdateLobby applies a partial update to a lobby's name, topic and
+// description. Settings, when given, are replaced as a whole.
+func (r *LobbyRepository) UpdateLobby(lobbyID int, update models.LobbyUpdateRequest) (*models.Lobby, error) {
+	var settings sql.NullString
+	if update.Settings != nil {
+		settingsJSON, err := json.Marshal(update.Settings)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding lobby settings: %w", err)
+		}
+		settings = sql.NullString{String: string(settingsJSON), Valid: true}
+	}
+
+	lobby, err := scanLobby(r.DB.QueryRow(`
+		UPDATE lobbies l SET
+			name = COALESCE($2, l.name),
+			topic = COALESCE($3, l.topic),
+			description = COALESCE($4, l.description),
+			settings = COALESCE($5::jsonb, l.settings)
+		WHERE l.id = $1
+		RETURNING `+lobbyColumns,
+		lobbyID, update.Name, update.Topic, update.Description, settings))
+	if err == sql.ErrNoRows {
+		return nil, ErrLobbyNotFound
+	}
+	if isUniqueViolation(err) {
+		return nil, ErrLobbyNameTaken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error updating lobby: %w", err)
+	}
+
+	return &lobby, nil
+}
+
+// SetArchived archives or unarchives a lobby
+func (r *LobbyRepository) SetArchived(lobbyID int, archived bool) error {
+	result, err := r.DB.Exec(`
+		UPDATE lobbies SET archived_at = CASE WHEN $2 THEN COALESCE(archived_at, CURRENT_TIMESTAMP) END
+		WHERE id = $1
+	`, lobbyID, archived)
+	if err != nil {
+		return fmt.Errorf("error archiving lobby: %w", err)
+	}
+
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return ErrLobbyNotFound
+	}
+	return nil
+}
+
+// DeleteLobby deletes a lobby. A positive reassignTo moves the lobby's
+// messages, with their reports and the lobby's moderation log, to that
+// lobby, otherwise they are deleted with it.
+func (r *LobbyRepository) DeleteLobby(lobbyID, reassignTo int) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if reassignTo > 0 {
+		// Import keys are unique per lobby, so moved messages drop theirs
+		_, err = tx.Exec("UPDATE messages SET lobby_id = $2, import_key = NULL WHERE lobby_id = $1", lobbyID, reassignTo)
+		if err == nil {
+			// Attachments sent with the messages move with them
+			_, err = tx.Exec("UPDATE attachments SET lobby_id = $2 WHERE lobby_id = $1 AND message_id IS NOT NULL",
+				lobbyID, reassignTo)
+		}
+		if err == nil {
+			// Keep the moderation trail of the moved messages
+			_, err = tx.Exec("UPDATE reports SET lobby_id = $2 WHERE lobby_id = $1", lobbyID, reassignTo)
+		}
+		if err == nil {
+			_, err = tx.Exec("UPDATE moderation_log SET lobby_id = $2 WHERE lobby_id = $1", lobbyID, reassignTo)
+		}
+		if err == nil {
+			_, err = tx.Exec("UPDATE saved_messages SET lobby_id = $2 WHERE lobby_id = $1", lobbyID, reassignTo)
+		}
+	} else {
+		_, err = tx.Exec("DELETE FROM messages WHERE lobby_id = $1", lobbyID)
+	}
+	if err != nil {
+		return fmt.Errorf("error removing lobby messages: %w", err)
+	}
+
+	result, err := tx.Exec("DELETE FROM lobbies WHERE id = $1", lobbyID)
+	if err != nil {
+		return fmt.Errorf("error deleting lobby: %w", err)
+	}
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		return ErrLobbyNotFound
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return nil
+}
+
+// AddMember adds a user to a lobby's members. It returns ErrLobbyFull if the
+// user is not a member yet and the lobby has reached its member limit.
+func (r *LobbyRepository) AddMember(lobbyID, userID int) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := addMember(tx, lobbyID, userID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+// AddMembers adds users to a lobby's members, skipping those who already
+// are. It ignores the member limit, so imports can bring over a private
+// channel's members whole.
+func (r *LobbyRepository) AddMembers(lobbyID int, userIDs []int) error {
+	_, err := r.DB.Exec(`
+		INSERT INTO lobby_members (lobby_id, user_id)
+		SELECT $1, unnest($2::int[])
+		ON CONFLICT DO NOTHING
+	`, lobbyID, pq.Array(userIDs))
+	if err != nil {
+		return fmt.Errorf("error adding lobby members: %w", err)
+	}
+	return nil
+}
+
+// addMember adds a user to a lobby's members within a transaction, reporting
+// whether they were added. The lobby row stays locked until the transaction
+// ends, so the member count checked by insertMember cannot change meanwhile.
+// It returns ErrLobbyFull if the user is not a member yet and the lobby has
+// reached its member limit.
+func addMember(tx *sql.Tx, lobbyID, userID int) (bool, error) {
+	_, err := tx.Exec("SELECT 1 FROM lobbies WHERE id = $1 FOR UPDATE", lobbyID)
+	if err != nil {
+		return false, fmt.Errorf("error locking lobby: %w", err)
+	}
+
+	result, err := tx.Exec(insertMember, lobbyID, userID)
+	if err != nil {
+		return false, fmt.Errorf("error adding lobby member: %w", err)
+	}
+	if added, _ := result.RowsAffected(); added > 0 {
+		return true, nil
+	}
+
+	var isMember bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM lobby_members WHERE lobby_id = $1 AND user_id = $2)",
+		lobbyID, userID).Scan(&isMember)
+	if err != nil {
+		return false, fmt.Errorf("error checking lobby membership: %w", err)
+	}
+	if !isMember {
+		return false, ErrLobbyFull
+	}
+	return false, nil
+}
+
+// IsOwner reports whether a user owns a lobby
+func (r *LobbyRepository) IsOwner(lobbyID, userID int) (bool, error) {
+	var exists bool
+	err := r.DB.QueryRow("SELECT EXISTS (SELECT 1 FROM lobbies WHERE id = $1 AND owner_id = $2)",
+		lobbyID, userID).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("error checking lobby owner: %w", err)
+	}
+
+	return exists, nil
+}
+
+// TransferOwnership makes a user the owner of a lobby, adding them to its
+// members if they are not one already
+func (r *LobbyRepository) TransferOwnership(lobbyID, userID int) (*models.Lobby, error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	lobby, err := scanLobby(tx.QueryRow("UPDATE lobbies l SET owner_id = $2 WHERE l.id = $1 RETURNING "+lobbyColumns,
+		lobbyID, userID))
+	if err == sql.ErrNoRows {
+		return nil, ErrLobbyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error transferring lobby: %w", err)
+	}
+
+	_, err = tx.Exec("INSERT INTO lobby_members (lobby_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+		lobbyID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error adding lobby owner: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return &lobby, nil
+}
+
+// RemoveMember removes a user from a lobby's members
+func (r *LobbyRepository) RemoveMember(lobbyID, userID int) error {
+	_, err := r.DB.Exec("DELETE FROM lobby_members WHERE lobby_id = $1 AND user_id = $2", lobbyID, userID)
+	if err != nil {
+		return fmt.Errorf("error removing lobby member: %w", err)
+	}
+
+	return nil
+}
+
+// IsMember reports whether a user is a member of a lobby
+func (r *LobbyRepository) IsMember(lobbyID, userID int) (bool, error) {
+	var exists bool
+	err := r.DB.QueryRow("SELECT EXISTS (SELECT 1 FROM lobby_members WHERE lobby_id = $1 AND user_id = $2)",
+		lobbyID, userID).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("error checking lobby membership: %w", err)
+	}
+
+	return exists, nil
+}
+
+// isRestricted reports whether only members may access a lobby
+func isRestricted(lobby *models.Lobby) bool {
+	return lobby.Kind == models.LobbyKindDM || lobby.Visibility == models.VisibilityPrivate
+}
+
+// CanAccess reports whether a user may read and post in a lobby. Private
+// lobbies and direct message conversations are restricted to their members.
+func (r *LobbyRepository) CanAccess(lobbyID, userID int) (bool, error) {
+	lobby, err := r.GetLobbyByID(lobbyID)
+	if err != nil {
+		return false, err
+	}
+	if !isRestricted(lobby) {
+		return true, nil
+	}
+
+	return r.IsMember(lobbyID, userID)
+}
+
+// IsBanned reports whether a user is currently banned from a lobby
+func (r *LobbyRepository) IsBanned(lobbyID, userID int) (bool, error) {
+	var banned bool
+	err := r.DB.QueryRow("SELECT NOT "+notBanned("$1", "$2"), lobbyID, userID).Scan(&banned)
+	if err != nil {
+		return false, fmt.Errorf("error checking ban: %w", err)
+	}
+
+	return banned, nil
+}
+
+// notBanned is the SQL condition for the user in userParam not being banned
+// from the lobby with the ID in lobbyExpr
+func notBanned(lobbyExpr, userParam string) string {
+	return "NOT EXISTS (SELECT 1 FROM lobby_restrictions WHERE lobby_id = " + lobbyExpr +
+		" AND user_id = " + userParam + " AND kind = '" + models.RestrictionBan + "' AND " + activeRestriction + ")"
+}
+
+// canAccess is the SQL condition for the user in userParam being allowed to
+// read the lobby with the ID in lobbyExpr, matching CanAccess and IsBanned:
+// public lobbies are open to everyone, private lobbies and direct message
+// conversations to their members, and neither to banned users
+func canAccess(lobbyExpr, userParam string) string {
+	return "(EXISTS (SELECT 1 FROM lobbies al WHERE al.id = " + lobbyExpr +
+		" AND al.kind <> '" + models.LobbyKindDM + "' AND al.visibility <> '" + models.VisibilityPrivate + "')" +
+		" OR EXISTS (SELECT 1 FROM lobby_members am WHERE am.lobby_id = " + lobbyExpr + " AND am.user_id = " + userParam + "))" +
+		" AND " + notBanned(lobbyExpr, userParam)
+}
+
+// FilterAccessible returns the users who may access a lobby
+func (r *LobbyRepository) FilterAccessible(lobbyID int, userIDs []int) ([]int, error) {
+	lobby, err := r.GetLobbyByID(lobbyID)
+	if err != nil {
+		return nil, err
+	}
+	if !isRestricted(lobby) || len(userIDs) == 0 {
+		return userIDs, nil
+	}
+
+	rows, err := r.DB.Query("SELECT user_id FROM lobby_members WHERE lobby_id = $1 AND user_id = ANY($2)",
+		lobbyID, pq.Array(userIDs))
+	if err != nil {
+		return nil, fmt.Errorf("error checking lobby membership: %w", err)
+	}
+	defer rows.Close()
+
+	var members []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("error scanning member data: %w", err)
+		}
+		members = append(members, userID)
+	}
+
+	return members, nil
+}
+
+// GetOrCreateDM gets the direct message conversation between exactly the
+// given users, creating it if it does not exist yet. The returned bool
+// reports whether the conversation was created.
+func (r *LobbyRepository) GetOrCreateDM(userIDs []int) (*models.Lobby, bool, error) {
+	members := uniqueSorted(userIDs)
+	keyParts := make([]string, len(members))
+	for i, id := range members {
+		keyParts[i] = strconv.Itoa(id)
+	}
+	key := strings.Join(keyParts, ",")
+
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return nil, false, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Insert the conversation, or find the one created concurrently for the same members
+	var lobbyID int
+	err = tx.QueryRow(`
+		WITH next AS (SELECT nextval(pg_get_serial_sequence('lobbies', 'id')) AS id)
+		INSERT INTO lobbies (id, name, kind, visibility, dm_key)
+		SELECT id, 'dm-' || id, $1, $2, $3 FROM next
+		ON CONFLICT (dm_key) DO NOTHING
+		RETURNING id
+	`, models.LobbyKindDM, models.VisibilityPrivate, key).Scan(&lobbyID)
+	created := err == nil
+	if err == sql.ErrNoRows {
+		err = tx.QueryRow("SELECT id FROM lobbies WHERE dm_key = $1", key).Scan(&lobbyID)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("error creating direct message conversation: %w", err)
+	}
+
+	if created {
+		_, err = tx.Exec("INSERT INTO lobby_members (lobby_id, user_id) SELECT $1, unnest($2::int[])",
+			lobbyID, pq.Array(members))
+		if err != nil {
+			return nil, false, fmt.Errorf("error adding direct message members: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, false, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return &models.Lobby{
+		ID:         lobbyID,
+		Name:       fmt.Sprintf("dm-%d", lobbyID),
+		Kind:       models.LobbyKindDM,
+		Visibility: models.VisibilityPrivate,
+		MemberIDs:  members,
+	}, created, nil
+}
+
+// GetUserDMs gets the direct message conversations a user is a member of
+func (r *LobbyRepository) GetUserDMs(userID int) ([]models.Lobby, error) {
+	rows, err := r.DB.Query(`
+		SELECT `+lobbyColumns+`, array_agg(all_members.user_id ORDER BY all_members.user_id)
+		FROM lobbies l
+		JOIN lobby_members me ON me.lobby_id = l.id AND me.user_id = $1
+		JOIN lobby_members all_members ON all_members.lobby_id = l.id
+		WHERE l.kind = $2
+		GROUP BY l.id
+		ORDER BY l.id
+	`, userID, models.LobbyKindDM)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching direct messages: %w", err)
+	}
+	defer rows.Close()
+
+	var lobbies []models.Lobby
+	for rows.Next() {
+		var memberIDs pq.Int64Array
+		lobby, err := scanLobby(rows, &memberIDs)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning lobby data: %w", err)
+		}
+		for _, id := range memberIDs {
+			lobby.MemberIDs = append(lobby.MemberIDs, int(id))
+		}
+		lobbies = append(lobbies, lobby)
+	}
+
+	return lobbies, nil
+}
+
+// CreateInvite creates an invite to a lobby that expires after expiresIn.
+// A maxUses of zero allows unlimited uses.
+func (r *LobbyRepository) CreateInvite(lobbyID, createdBy int, expiresIn time.Duration, maxUses int) (*models.Invite, error) {
+	invite := models.Invite{LobbyID: lobbyID, CreatedBy: createdBy}
+	if maxUses > 0 {
+		invite.MaxUses = &maxUses
+	}
+
+	err := r.DB.QueryRow(`
+		INSERT INTO lobby_invites (lobby_id, created_by, expires_at, max_uses)
+		VALUES ($1, $2, CURRENT_TIMESTAMP + make_interval(secs => $3), $4)
+		RETURNING id, expires_at
+	`, lobbyID, createdBy, expiresIn.Seconds(), invite.MaxUses).Scan(&invite.ID, &invite.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("error creating invite: %w", err)
+	}
+
+	return &invite, nil
+}
+
+// RedeemInvite adds a user to the invite's lobby, consuming one use. Users
+// who are already members do not consume a use, and ErrLobbyFull is
+// returned if the lobby has reached its member limit.
+func (r *LobbyRepository) RedeemInvite(inviteID, lobbyID, userID int) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	added, err := addMember(tx, lobbyID, userID)
+	if err != nil || !added {
+		return err
+	}
+
+	result, err := tx.Exec(`
+		UPDATE lobby_invites SET uses = uses + 1
+		WHERE id = $1 AND lobby_id = $2 AND expires_at > CURRENT_TIMESTAMP
+			AND (max_uses IS NULL OR uses < max_uses)
+	`, inviteID, lobbyID)
+	if err != nil {
+		return fmt.Errorf("error redeeming invite: %w", err)
+	}
+	if redeemed, _ := result.RowsAffected(); redeemed == 0 {
+		return ErrInviteUnavailable
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return nil
+}
+
+// uniqueSorted returns the distinct IDs in ascending order
+func uniqueSorted(ids []int) []int {
+	seen := make(map[int]bool, len(ids))
+	var unique []int
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	sort.Ints(unique)
+	return unique
+}
+
+// isUniqueViolation reports whether err is PostgreSQL refusing a row that
+// would duplicate a unique column or index
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
@@ -0,0 +1,129 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/galexander77/chat-app/api/models"
+	"github.com/lib/pq"
+)
+
+// ErrMentionNotFound is returned when a mention does not exist for the user
+var ErrMentionNotFound = errors.New("mention not found")
+
+// MentionRepository handles database operations for mentions
+type MentionRepository struct {
+	DB *sql.DB
+}
+
+// NewMentionRepository creates a new MentionRepository
+func NewMentionRepository(db *sql.DB) *MentionRepository {
+	return &MentionRepository{DB: db}
+}
+
+// CreateMentions records that users were mentioned in a message and returns
+// the IDs of users who were not already mentioned in it
+func (r *MentionRepository) CreateMentions(messageID int, kind string, userIDs []int) ([]int, error) {
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+
+	rows, err := r.DB.Query(`
+		INSERT INTO mentions (message_id, user_id, kind)
+		SELECT $1, unnest($2::int[]), $3
+		ON CONFLICT DO NOTHING
+		RETURNING user_id
+	`, messageID, pq.Array(userIDs), kind)
+	if err != nil {
+		return nil, fmt.Errorf("error creating mentions: %w", err)
+	}
+	defer rows.Close()
+
+	var created []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("error scanning mention data: %w", err)
+		}
+		created = append(created, userID)
+	}
+
+	return created, nil
+}
+
+// GetMentions gets a user's mentions, newest first. A positive before
+// returns only mentions with a smaller ID. Mentions in lobbies the user can
+// no longer read, such as private lobbies they left or lobbies they are
+// banned from, are left out.
+func (r *MentionRepository) GetMentions(userID int, unreadOnly bool, before, limit int) ([]models.Mention, error) {
+	rows, err := r.DB.Query(`
+		SELECT `+messageColumns+`, mn.id, mn.kind, mn.created_at, mn.read_at
+		FROM mentions mn, `+messageTables+`
+		WHERE mn.message_id = m.id AND mn.user_id = $1 AND m.deleted_at IS NULL AND `+notBlockedBy("$1")+`
+			AND `+canAccess("m.lobby_id", "$1")+`
+			AND (NOT $2 OR mn.read_at IS NULL)
+			AND ($3 <= 0 OR mn.id < $3)
+		ORDER BY mn.id DESC
+		LIMIT $4
+	`, userID, unreadOnly, before, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching mentions: %w", err)
+	}
+	defer rows.Close()
+
+	var mentions []models.Mention
+	for rows.Next() {
+		var mention models.Mention
+		var readAt sql.NullTime
+		mention.Message, err = scanMessage(rows, &mention.ID, &mention.Kind, &mention.CreatedAt, &readAt)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning mention data: %w", err)
+		}
+		if readAt.Valid {
+			mention.ReadAt = &readAt.Time
+		}
+		mentions = append(mentions, mention)
+	}
+
+	return mentions, rows.Err()
+}
+
+// MarkMentionRead marks one of a user's mentions as read
+func (r *MentionRepository) MarkMentionRead(mentionID, userID int) error {
+	result, err := r.DB.Exec("UPDATE mentions SET read_at = COALESCE(read_at, CURRENT_TIMESTAMP) WHERE id = $1 AND user_id = $2",
+		mentionID, userID)
+	if err != nil {
+		return fmt.Errorf("error marking mention read: %w", err)
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrMentionNotFound
+	}
+
+	return nil
+}
+
+// GetLobbyParticipantIDs gets the IDs of users who have posted in a lobby
+func (r *MentionRepository) GetLobbyParticipantIDs(lobbyID int) ([]int, error) {
+	rows, err := r.DB.Query("SELECT DISTINCT user_id FROM messages WHERE lobby_id = $1 AND deleted_at IS NULL", lobbyID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching lobby participants: %w", err)
+	}
+	defer rows.Close()
+
+	var userIDs []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("error scanning participant data: %w", err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+
+	return userIDs, nil
+}
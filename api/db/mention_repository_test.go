@@ -0,0 +1,88 @@
+package db_test
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/galexander77/chat-app/api/db"
+	"github.com/galexander77/chat-app/api/db/dbtest"
+	"github.com/galexander77/chat-app/api/models"
+)
+
+// mentionIn creates a lobby owned by ownerID with a message mentioning
+// userID, returning the lobby and message IDs
+func mentionIn(t *testing.T, database *sql.DB, visibility string, ownerID, userID int) (int, int) {
+	t.Helper()
+
+	lobbyRepo := db.NewLobbyRepository(database)
+	lobbyID, err := lobbyRepo.CreateLobby(dbtest.Name("mention"), visibility, ownerID, models.LobbySettings{PostPolicy: models.PostPolicyEveryone})
+	if err != nil {
+		t.Fatalf("error creating lobby: %v", err)
+	}
+	if err := lobbyRepo.AddMember(lobbyID, userID); err != nil {
+		t.Fatalf("error adding member: %v", err)
+	}
+	messageID, err := db.NewMessageRepository(database).SaveMessage("hi", ownerID, lobbyID, nil, nil, 0)
+	if err != nil {
+		t.Fatalf("error saving message: %v", err)
+	}
+	if _, err := db.NewMentionRepository(database).CreateMentions(messageID, models.MentionUser, []int{userID}); err != nil {
+		t.Fatalf("error creating mention: %v", err)
+	}
+	return lobbyID, messageID
+}
+
+func TestGetMentionsAccess(t *testing.T) {
+	database := dbtest.Open(t)
+	mentionRepo := db.NewMentionRepository(database)
+	ownerID := dbtest.CreateUser(t, database)
+
+	tests := []struct {
+		name       string
+		visibility string
+		lose       func(lobbyID, userID int) error
+	}{
+		{"left private lobby", models.VisibilityPrivate, func(lobbyID, userID int) error {
+			return db.NewLobbyRepository(database).RemoveMember(lobbyID, userID)
+		}},
+		{"banned from public lobby", models.VisibilityPublic, func(lobbyID, userID int) error {
+			_, err := db.NewModerationRepository(database).Restrict(lobbyID, userID, models.RestrictionBan, ownerID, "", 0)
+			return err
+		}},
+		{"banned from private lobby", models.VisibilityPrivate, func(lobbyID, userID int) error {
+			_, err := db.NewModerationRepository(database).Restrict(lobbyID, userID, models.RestrictionBan, ownerID, "", 0)
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID := dbtest.CreateUser(t, database)
+			_, keptID := mentionIn(t, database, models.VisibilityPublic, ownerID, userID)
+			lobbyID, lostID := mentionIn(t, database, tt.visibility, ownerID, userID)
+
+			messageIDs := func() []int {
+				mentions, err := mentionRepo.GetMentions(userID, false, 0, 50)
+				if err != nil {
+					t.Fatalf("GetMentions() error = %v", err)
+				}
+				var ids []int
+				for _, mention := range mentions {
+					ids = append(ids, mention.Message.ID)
+				}
+				return ids
+			}
+
+			if got, want := messageIDs(), []int{lostID, keptID}; !reflect.DeepEqual(got, want) {
+				t.Fatalf("mentions before = %v, want %v", got, want)
+			}
+			if err := tt.lose(lobbyID, userID); err != nil {
+				t.Fatalf("error removing access: %v", err)
+			}
+			if got, want := messageIDs(), []int{keptID}; !reflect.DeepEqual(got, want) {
+				t.Errorf("mentions after = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -2,12 +2,93 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/galexander77/chat-app/api/markdown"
 	"github.com/galexander77/chat-app/api/models"
+	"github.com/lib/pq"
 )
 
+// ErrMessageNotFound is returned when a message does not exist
+var ErrMessageNotFound = errors.New("message not found")
+
+// messageColumns is the column list shared by message queries, selected
+// from messageTables. Deleted messages are returned as tombstones with their
+// content blanked.
+const messageColumns = `
+	m.id, CASE WHEN m.deleted_at IS NULL THEN m.content ELSE '' END,
+	m.user_id, u.username, m.lobby_id, m.timestamp, m.deleted_at, m.deleted_by,
+	m.parent_id, t.reply_count, t.last_reply_at, m.pinned_at, m.pinned_by, m.expires_at`
+
+// messageTables joins messages with their author and thread summary.
+// Expired messages are left out even before they are deleted.
+const messageTables = `
+	(SELECT * FROM messages WHERE ` + notExpired + `) m
+	JOIN users u ON m.user_id = u.id
+	LEFT JOIN LATERAL (
+		SELECT COUNT(*) AS reply_count, MAX(r.timestamp) AS last_reply_at
+		FROM messages r
+		WHERE r.parent_id = m.id AND r.deleted_at IS NULL AND (r.expires_at IS NULL OR r.expires_at > CURRENT_TIMESTAMP)
+	) t ON true`
+
+// notExpired matches messages that have not expired, in queries on messages
+// without an alias
+const notExpired = `(expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)`
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// execer is implemented by *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// scanMessage scans a row selected with messageColumns into a message.
+// Destinations for any columns selected after messageColumns are passed as extra.
+func scanMessage(row rowScanner, extra ...interface{}) (models.Message, error) {
+	var msg models.Message
+	var deletedAt, lastReplyAt, pinnedAt, expiresAt sql.NullTime
+	var deletedBy, parentID, pinnedBy sql.NullInt64
+	dest := []interface{}{&msg.ID, &msg.Content, &msg.UserID, &msg.Username, &msg.LobbyID, &msg.Timestamp,
+		&deletedAt, &deletedBy, &parentID, &msg.ReplyCount, &lastReplyAt, &pinnedAt, &pinnedBy, &expiresAt}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return msg, err
+	}
+
+	msg.HTML = markdown.Render(msg.Content)
+	if deletedAt.Valid {
+		msg.DeletedAt = &deletedAt.Time
+	}
+	if deletedBy.Valid {
+		id := int(deletedBy.Int64)
+		msg.DeletedBy = &id
+	}
+	if parentID.Valid {
+		id := int(parentID.Int64)
+		msg.ParentID = &id
+	}
+	if lastReplyAt.Valid {
+		msg.LastReplyAt = &lastReplyAt.Time
+	}
+	if pinnedAt.Valid {
+		msg.PinnedAt = &pinnedAt.Time
+	}
+	if pinnedBy.Valid {
+		id := int(pinnedBy.Int64)
+		msg.PinnedBy = &id
+	}
+	if expiresAt.Valid {
+		msg.ExpiresAt = &expiresAt.Time
+	}
+
+	return msg, nil
+}
+
 // MessageRepository handles database operations for messages
 type MessageRepository struct {
 	DB *sql.DB
@@ -18,27 +99,255 @@ func NewMessageRepository(db *sql.DB) *MessageRepository {
 	return &MessageRepository{DB: db}
 }
 
-// SaveMessage saves a message to the database
-func (r *MessageRepository) SaveMessage(content string, userID, lobbyID int) (int, error) {
+// SaveMessage saves a message to the database along with the uploads sent
+// with it. It returns ErrAttachmentUnavailable if an attachment is not an
+// unsent upload by the author in the lobby. Ephemeral messages have an
+// expiresAt, after which they are no longer returned. A positive
+// scheduledID is the scheduled message being sent, which is marked sent
+// along with the save so it is never sent twice.
+func (r *MessageRepository) SaveMessage(content string, userID, lobbyID int, attachmentIDs []int, expiresAt *time.Time, scheduledID int) (int, error) {
+	return r.insertMessage(content, userID, lobbyID, nil, attachmentIDs, expiresAt, scheduledID)
+}
+
+// SaveReply saves a reply to a thread along with the uploads sent with it
+func (r *MessageRepository) SaveReply(content string, userID, lobbyID, parentID int, attachmentIDs []int, expiresAt *time.Time, scheduledID int) (int, error) {
+	return r.insertMessage(content, userID, lobbyID, &parentID, attachmentIDs, expiresAt, scheduledID)
+}
+
+// insertMessage saves a message or reply, links its attachments and marks
+// the scheduled message it sends as sent in one transaction
+func (r *MessageRepository) insertMessage(content string, userID, lobbyID int, parentID *int, attachmentIDs []int, expiresAt *time.Time, scheduledID int) (int, error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
 	var messageID int
-	err := r.DB.QueryRow("INSERT INTO messages (content, user_id, lobby_id) VALUES ($1, $2, $3) RETURNING id",
-		content, userID, lobbyID).Scan(&messageID)
+	var expires sql.NullTime
+	if expiresAt != nil {
+		expires = sql.NullTime{Time: expiresAt.UTC(), Valid: true}
+	}
+	err = tx.QueryRow(`
+		INSERT INTO messages (content, user_id, lobby_id, parent_id, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`, content, userID, lobbyID, parentID, expires).Scan(&messageID)
 	if err != nil {
 		return 0, fmt.Errorf("error saving message: %w", err)
 	}
 
+	if len(attachmentIDs) > 0 {
+		if err := linkAttachments(tx, messageID, lobbyID, userID, attachmentIDs); err != nil {
+			return 0, err
+		}
+	}
+	if scheduledID > 0 {
+		if err := markScheduledSent(tx, scheduledID, &messageID); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing transaction: %w", err)
+	}
+
 	return messageID, nil
 }
 
-// GetMessagesByLobbyID gets all messages for a lobby
-func (r *MessageRepository) GetMessagesByLobbyID(lobbyID int) ([]models.Message, error) {
-	rows, err := r.DB.Query(`
-		SELECT m.id, m.content, m.user_id, u.username, m.lobby_id, m.timestamp 
-		FROM messages m
-		JOIN users u ON m.user_id = u.id
-		WHERE m.lobby_id = $1
+// ImportMessages inserts messages into a lobby with their original
+// timestamps in a single transaction, returning their IDs in order and how
+// many were inserted. Messages whose import key is already in the lobby are
+// not inserted again; the existing message's ID is returned instead.
+func (r *MessageRepository) ImportMessages(lobbyID int, messages []models.ImportedMessage) ([]int, int, error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return nil, 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		WITH inserted AS (
+			INSERT INTO messages (content, user_id, lobby_id, timestamp, parent_id, import_key)
+			VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))
+			ON CONFLICT (lobby_id, import_key) WHERE import_key IS NOT NULL DO NOTHING
+			RETURNING id
+		)
+		SELECT id, true FROM inserted
+		UNION ALL
+		SELECT id, false FROM messages WHERE lobby_id = $3 AND import_key = NULLIF($6, '')
+		LIMIT 1
+	`)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error preparing import: %w", err)
+	}
+	defer stmt.Close()
+
+	ids := make([]int, len(messages))
+	added := 0
+	for i, msg := range messages {
+		var inserted bool
+		err := stmt.QueryRow(msg.Content, msg.UserID, lobbyID, msg.Timestamp.UTC(), msg.ParentID, msg.ImportKey).
+			Scan(&ids[i], &inserted)
+		if err != nil {
+			return nil, 0, fmt.Errorf("error importing message: %w", err)
+		}
+		if inserted {
+			added++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, 0, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return ids, added, nil
+}
+
+// GetMessageByID gets a single message, returning a tombstone if it was deleted
+func (r *MessageRepository) GetMessageByID(messageID int) (*models.Message, error) {
+	row := r.DB.QueryRow(`
+		SELECT `+messageColumns+`
+		FROM `+messageTables+`
+		WHERE m.id = $1
+	`, messageID)
+
+	msg, err := scanMessage(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching message: %w", err)
+	}
+
+	return &msg, nil
+}
+
+// GetMessagesByLobbyID gets all top-level messages for a lobby, leaving out
+// messages from users the viewer has blocked. Thread replies are only
+// returned by GetThread.
+func (r *MessageRepository) GetMessagesByLobbyID(lobbyID, viewerID int) ([]models.Message, error) {
+	return r.queryMessages(`
+		SELECT `+messageColumns+`
+		FROM `+messageTables+`
+		WHERE m.lobby_id = $1 AND m.parent_id IS NULL AND `+notBlockedBy("$2")+`
 		ORDER BY m.timestamp ASC
-	`, lobbyID)
+	`, lobbyID, viewerID)
+}
+
+// GetThread gets a top-level message and its replies in chronological order,
+// leaving out replies from users the viewer has blocked. Passing a reply
+// returns the thread it belongs to.
+func (r *MessageRepository) GetThread(parentID, viewerID int) (*models.Thread, error) {
+	parent, err := r.GetMessageByID(parentID)
+	if err != nil {
+		return nil, err
+	}
+	if parent.ParentID != nil {
+		return r.GetThread(*parent.ParentID, viewerID)
+	}
+
+	replies, err := r.queryMessages(`
+		SELECT `+messageColumns+`
+		FROM `+messageTables+`
+		WHERE m.parent_id = $1 AND `+notBlockedBy("$2")+`
+		ORDER BY m.timestamp ASC
+	`, parent.ID, viewerID)
+	if err != nil {
+		return nil, err
+	}
+
+	parents := []models.Message{*parent}
+	if err := r.attachReactions(parents); err != nil {
+		return nil, err
+	}
+	if err := r.attachAttachments(parents); err != nil {
+		return nil, err
+	}
+
+	return &models.Thread{Parent: parents[0], Replies: replies}, nil
+}
+
+// GetPinnedMessages gets a lobby's pinned messages, most recently pinned
+// first, leaving out messages from users the viewer has blocked
+func (r *MessageRepository) GetPinnedMessages(lobbyID, viewerID int) ([]models.Message, error) {
+	return r.queryMessages(`
+		SELECT `+messageColumns+`
+		FROM `+messageTables+`
+		WHERE m.lobby_id = $1 AND m.pinned_at IS NOT NULL AND m.deleted_at IS NULL AND `+notBlockedBy("$2")+`
+		ORDER BY m.pinned_at DESC
+	`, lobbyID, viewerID)
+}
+
+// SetPinned pins or unpins a message, reporting whether it changed.
+// Deleted messages cannot be pinned.
+func (r *MessageRepository) SetPinned(messageID, userID int, pinned bool) (bool, error) {
+	var result sql.Result
+	var err error
+	if pinned {
+		result, err = r.DB.Exec(`
+			UPDATE messages SET pinned_at = CURRENT_TIMESTAMP, pinned_by = $2
+			WHERE id = $1 AND pinned_at IS NULL AND deleted_at IS NULL
+		`, messageID, userID)
+	} else {
+		result, err = r.DB.Exec(`
+			UPDATE messages SET pinned_at = NULL, pinned_by = NULL
+			WHERE id = $1 AND pinned_at IS NOT NULL
+		`, messageID)
+	}
+	if err != nil {
+		return false, fmt.Errorf("error updating pin: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error checking pin result: %w", err)
+	}
+	return rows > 0, nil
+}
+
+// StreamMessages calls fn with each message and reply in a lobby in
+// chronological order, optionally only those sent from (inclusive) and
+// before to, without loading them all into memory. Messages from users the
+// viewer blocked are skipped. Deleted messages keep their content if
+// includeDeleted is set and are tombstones otherwise. Reactions and
+// attachments are not included.
+func (r *MessageRepository) StreamMessages(lobbyID, viewerID int, from, to *time.Time, includeDeleted bool, fn func(models.Message) error) error {
+	rows, err := r.DB.Query(`
+		SELECT `+messageColumns+`, m.content
+		FROM `+messageTables+`
+		WHERE m.lobby_id = $1 AND `+notBlockedBy("$2")+`
+			AND ($3::timestamp IS NULL OR m.timestamp >= $3)
+			AND ($4::timestamp IS NULL OR m.timestamp < $4)
+		ORDER BY m.timestamp ASC, m.id ASC
+	`, lobbyID, viewerID, from, to)
+	if err != nil {
+		return fmt.Errorf("error fetching messages: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var content string
+		msg, err := scanMessage(rows, &content)
+		if err != nil {
+			return fmt.Errorf("error scanning message data: %w", err)
+		}
+		if includeDeleted && msg.DeletedAt != nil {
+			msg.Content = content
+			msg.HTML = markdown.Render(content)
+		}
+		if err := fn(msg); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// queryMessages runs a query selecting messageColumns and attaches reactions
+// and attachments to the results
+func (r *MessageRepository) queryMessages(query string, args ...interface{}) ([]models.Message, error) {
+	rows, err := r.DB.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("error fetching messages: %w", err)
 	}
@@ -46,14 +355,184 @@ func (r *MessageRepository) GetMessagesByLobbyID(lobbyID int) ([]models.Message,
 
 	var messages []models.Message
 	for rows.Next() {
-		var msg models.Message
-		var timestamp time.Time
-		if err := rows.Scan(&msg.ID, &msg.Content, &msg.UserID, &msg.Username, &msg.LobbyID, &timestamp); err != nil {
+		msg, err := scanMessage(rows)
+		if err != nil {
 			return nil, fmt.Errorf("error scanning message data: %w", err)
 		}
-		msg.Timestamp = timestamp
 		messages = append(messages, msg)
 	}
 
+	if err := r.attachReactions(messages); err != nil {
+		return nil, err
+	}
+	if err := r.attachAttachments(messages); err != nil {
+		return nil, err
+	}
+
 	return messages, nil
 }
+
+// attachReactions fills in aggregated reaction counts for messages
+func (r *MessageRepository) attachReactions(messages []models.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	index := make(map[int]int, len(messages))
+	ids := make([]int64, len(messages))
+	for i, msg := range messages {
+		index[msg.ID] = i
+		ids[i] = int64(msg.ID)
+	}
+
+	rows, err := r.DB.Query(`
+		SELECT r.message_id, r.emoji, COUNT(*)
+		FROM message_reactions r
+		JOIN messages m ON r.message_id = m.id
+		WHERE r.message_id = ANY($1) AND m.deleted_at IS NULL
+		GROUP BY r.message_id, r.emoji
+		ORDER BY MIN(r.created_at)
+	`, pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("error fetching reactions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID int
+		var reaction models.ReactionCount
+		if err := rows.Scan(&messageID, &reaction.Emoji, &reaction.Count); err != nil {
+			return fmt.Errorf("error scanning reaction data: %w", err)
+		}
+		if i, ok := index[messageID]; ok {
+			messages[i].Reactions = append(messages[i].Reactions, reaction)
+		}
+	}
+
+	return nil
+}
+
+// attachAttachments fills in the attachments of messages. Deleted messages
+// keep no attachments.
+func (r *MessageRepository) attachAttachments(messages []models.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	index := make(map[int]int, len(messages))
+	ids := make([]int64, len(messages))
+	for i, msg := range messages {
+		index[msg.ID] = i
+		ids[i] = int64(msg.ID)
+	}
+
+	rows, err := r.DB.Query(`
+		SELECT `+attachmentColumns+`
+		FROM attachments a
+		JOIN messages m ON a.message_id = m.id
+		WHERE a.message_id = ANY($1) AND m.deleted_at IS NULL
+		ORDER BY a.id
+	`, pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("error fetching attachments: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return fmt.Errorf("error scanning attachment data: %w", err)
+		}
+		if i, ok := index[*attachment.MessageID]; ok {
+			messages[i].Attachments = append(messages[i].Attachments, attachment)
+		}
+	}
+
+	return nil
+}
+
+// DeleteMessage soft deletes a message, recording who deleted it and when.
+// A pinned message is unpinned in the same statement, and the returned bool
+// reports whether it was.
+func (r *MessageRepository) DeleteMessage(messageID, deletedBy int) (time.Time, bool, error) {
+	var deletedAt time.Time
+	var wasPinned bool
+	err := r.DB.QueryRow(`
+		UPDATE messages m SET deleted_at = CURRENT_TIMESTAMP, deleted_by = $2, pinned_at = NULL, pinned_by = NULL
+		FROM (SELECT id, pinned_at FROM messages WHERE id = $1 FOR UPDATE) old
+		WHERE m.id = old.id AND m.deleted_at IS NULL
+		RETURNING m.deleted_at, old.pinned_at IS NOT NULL
+	`, messageID, deletedBy).Scan(&deletedAt, &wasPinned)
+	if err == sql.ErrNoRows {
+		return time.Time{}, false, ErrMessageNotFound
+	}
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("error deleting message: %w", err)
+	}
+
+	return deletedAt, wasPinned, nil
+}
+
+// PurgeDeletedMessages permanently removes up to limit messages soft deleted
+// longer ago than the retention period, oldest first
+func (r *MessageRepository) PurgeDeletedMessages(retention time.Duration, limit int) (int64, error) {
+	result, err := r.DB.Exec(`
+		DELETE FROM messages
+		WHERE id IN (
+			SELECT id FROM messages
+			WHERE deleted_at IS NOT NULL AND deleted_at < CURRENT_TIMESTAMP - make_interval(secs => $1)
+			ORDER BY deleted_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+	`, retention.Seconds(), limit)
+	if err != nil {
+		return 0, fmt.Errorf("error purging deleted messages: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// DeleteExpiredMessages permanently removes up to limit ephemeral messages
+// that have expired, along with their replies, returning the removed
+// messages with only their ID, lobby and parent set
+func (r *MessageRepository) DeleteExpiredMessages(limit int) ([]models.Message, error) {
+	// Replies are deleted by name rather than left to the cascade so that
+	// they are returned and their threads hear that they are gone
+	rows, err := r.DB.Query(`
+		WITH expired AS (
+			SELECT id FROM messages
+			WHERE expires_at <= CURRENT_TIMESTAMP
+			ORDER BY expires_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		), removed AS (
+			SELECT id FROM expired
+			UNION
+			SELECT id FROM messages WHERE parent_id IN (SELECT id FROM expired)
+		)
+		DELETE FROM messages
+		WHERE id IN (SELECT id FROM removed)
+		RETURNING id, lobby_id, parent_id
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error deleting expired messages: %w", err)
+	}
+	defer rows.Close()
+
+	var expired []models.Message
+	for rows.Next() {
+		var msg models.Message
+		var parentID sql.NullInt64
+		if err := rows.Scan(&msg.ID, &msg.LobbyID, &parentID); err != nil {
+			return nil, fmt.Errorf("error scanning expired message data: %w", err)
+		}
+		if parentID.Valid {
+			id := int(parentID.Int64)
+			msg.ParentID = &id
+		}
+		expired = append(expired, msg)
+	}
+
+	return expired, rows.Err()
+}
@@ -0,0 +1,118 @@
+package db_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/galexander77/chat-app/api/db"
+	"github.com/galexander77/chat-app/api/db/dbtest"
+	"github.com/galexander77/chat-app/api/models"
+)
+
+func TestDeleteExpiredMessagesReturnsReplies(t *testing.T) {
+	database := dbtest.Open(t)
+	messageRepo := db.NewMessageRepository(database)
+	userID := dbtest.CreateUser(t, database)
+
+	lobbyID, err := db.NewLobbyRepository(database).CreateLobby(dbtest.Name("expiry"), models.VisibilityPublic, userID, models.LobbySettings{PostPolicy: models.PostPolicyEveryone})
+	if err != nil {
+		t.Fatalf("error creating lobby: %v", err)
+	}
+
+	// The reply outlives its parent, so only the parent is picked as
+	// expired and the reply goes with it
+	expired := time.Now().Add(-time.Minute)
+	later := time.Now().Add(time.Hour)
+	parentID, err := messageRepo.SaveMessage("parent", userID, lobbyID, nil, &expired, 0)
+	if err != nil {
+		t.Fatalf("error saving message: %v", err)
+	}
+	replyID, err := messageRepo.SaveReply("reply", userID, lobbyID, parentID, nil, &later, 0)
+	if err != nil {
+		t.Fatalf("error saving reply: %v", err)
+	}
+
+	got := map[int]models.Message{}
+	for {
+		messages, err := messageRepo.DeleteExpiredMessages(100)
+		if err != nil {
+			t.Fatalf("DeleteExpiredMessages() error = %v", err)
+		}
+		if len(messages) == 0 {
+			break
+		}
+		for _, msg := range messages {
+			got[msg.ID] = msg
+		}
+	}
+
+	if parent, ok := got[parentID]; !ok || parent.LobbyID != lobbyID || parent.ParentID != nil {
+		t.Errorf("parent = %+v, %v, want lobby %d without a parent", parent, ok, lobbyID)
+	}
+	reply, ok := got[replyID]
+	if !ok || reply.LobbyID != lobbyID || reply.ParentID == nil || *reply.ParentID != parentID {
+		t.Errorf("reply = %+v, %v, want lobby %d with parent %d", reply, ok, lobbyID, parentID)
+	}
+	if _, err := messageRepo.GetMessageByID(replyID); !errors.Is(err, db.ErrMessageNotFound) {
+		t.Errorf("GetMessageByID(reply) error = %v, want %v", err, db.ErrMessageNotFound)
+	}
+}
+
+func TestStreamMessagesBounds(t *testing.T) {
+	database := dbtest.Open(t)
+	messageRepo := db.NewMessageRepository(database)
+	userID := dbtest.CreateUser(t, database)
+
+	lobbyID, err := db.NewLobbyRepository(database).CreateLobby(dbtest.Name("export"), models.VisibilityPublic, userID, models.LobbySettings{PostPolicy: models.PostPolicyEveryone})
+	if err != nil {
+		t.Fatalf("error creating lobby: %v", err)
+	}
+
+	// One message a minute, so the bounds fall exactly on them
+	start := time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC)
+	var ids []int
+	for i := 0; i < 3; i++ {
+		id, err := messageRepo.SaveMessage("message", userID, lobbyID, nil, nil, 0)
+		if err != nil {
+			t.Fatalf("error saving message: %v", err)
+		}
+		if _, err := database.Exec("UPDATE messages SET timestamp = $1 WHERE id = $2", start.Add(time.Duration(i)*time.Minute), id); err != nil {
+			t.Fatalf("error setting timestamp: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	at := func(minutes int) *time.Time {
+		bound := start.Add(time.Duration(minutes) * time.Minute)
+		return &bound
+	}
+
+	tests := []struct {
+		name     string
+		from, to *time.Time
+		want     []int
+	}{
+		{"unbounded", nil, nil, ids},
+		{"from is inclusive", at(1), nil, ids[1:]},
+		{"to is exclusive", nil, at(2), ids[:2]},
+		{"both", at(1), at(2), ids[1:2]},
+		{"empty range", at(3), nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []int
+			err := messageRepo.StreamMessages(lobbyID, userID, tt.from, tt.to, false, func(message models.Message) error {
+				got = append(got, message.ID)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("StreamMessages() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("messages = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/galexander77/chat-app/api/models"
+)
+
+// ModerationRepository handles database operations for lobby bans, mutes and the moderation audit log
+type ModerationRepository struct {
+	DB *sql.DB
+}
+
+// NewModerationRepository creates a new ModerationRepository
+func NewModerationRepository(db *sql.DB) *ModerationRepository {
+	return &ModerationRepository{DB: db}
+}
+
+// activeRestriction is the condition matching restrictions that have not expired
+const activeRestriction = "(expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)"
+
+// Restrict bans or mutes a user in a lobby, replacing any existing restriction
+// of the same kind. A zero duration never expires.
+func (r *ModerationRepository) Restrict(lobbyID, userID int, kind string, createdBy int, reason string, duration time.Duration) (*time.Time, error) {
+	var expiresAt sql.NullTime
+	err := r.DB.QueryRow(`
+		INSERT INTO lobby_restrictions (lobby_id, user_id, kind, reason, created_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, CASE WHEN $6::float8 > 0 THEN CURRENT_TIMESTAMP + make_interval(secs => $6) END)
+		ON CONFLICT (lobby_id, user_id, kind) DO UPDATE
+		SET reason = EXCLUDED.reason, created_by = EXCLUDED.created_by,
+			created_at = CURRENT_TIMESTAMP, expires_at = EXCLUDED.expires_at
+		RETURNING expires_at
+	`, lobbyID, userID, kind, reason, createdBy, duration.Seconds()).Scan(&expiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("error restricting user: %w", err)
+	}
+
+	if !expiresAt.Valid {
+		return nil, nil
+	}
+	return &expiresAt.Time, nil
+}
+
+// Lift removes a ban or mute and reports whether one was active
+func (r *ModerationRepository) Lift(lobbyID, userID int, kind string) (bool, error) {
+	result, err := r.DB.Exec(`
+		DELETE FROM lobby_restrictions
+		WHERE lobby_id = $1 AND user_id = $2 AND kind = $3 AND `+activeRestriction,
+		lobbyID, userID, kind)
+	if err != nil {
+		return false, fmt.Errorf("error lifting restriction: %w", err)
+	}
+
+	lifted, err := result.RowsAffected()
+	return lifted > 0, err
+}
+
+// GetActiveRestriction gets a user's active ban or mute in a lobby, or nil if there is none
+func (r *ModerationRepository) GetActiveRestriction(lobbyID, userID int, kind string) (*models.Restriction, error) {
+	row := r.DB.QueryRow(`
+		SELECT lobby_id, user_id, kind, reason, created_by, created_at, expires_at
+		FROM lobby_restrictions
+		WHERE lobby_id = $1 AND user_id = $2 AND kind = $3 AND `+activeRestriction,
+		lobbyID, userID, kind)
+
+	restriction, err := scanRestriction(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching restriction: %w", err)
+	}
+
+	return &restriction, nil
+}
+
+// GetRestrictions gets the active bans and mutes in a lobby
+func (r *ModerationRepository) GetRestrictions(lobbyID int) ([]models.Restriction, error) {
+	rows, err := r.DB.Query(`
+		SELECT lobby_id, user_id, kind, reason, created_by, created_at, expires_at
+		FROM lobby_restrictions
+		WHERE lobby_id = $1 AND `+activeRestriction+`
+		ORDER BY created_at DESC
+	`, lobbyID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching restrictions: %w", err)
+	}
+	defer rows.Close()
+
+	var restrictions []models.Restriction
+	for rows.Next() {
+		restriction, err := scanRestriction(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning restriction data: %w", err)
+		}
+		restrictions = append(restrictions, restriction)
+	}
+
+	return restrictions, nil
+}
+
+// scanRestriction scans a lobby_restrictions row into a restriction
+func scanRestriction(row rowScanner) (models.Restriction, error) {
+	var restriction models.Restriction
+	var expiresAt sql.NullTime
+	err := row.Scan(&restriction.LobbyID, &restriction.UserID, &restriction.Kind, &restriction.Reason,
+		&restriction.CreatedBy, &restriction.CreatedAt, &expiresAt)
+	if expiresAt.Valid {
+		restriction.ExpiresAt = &expiresAt.Time
+	}
+	return restriction, err
+}
+
+// LogAction records a moderation action in the audit log
+func (r *ModerationRepository) LogAction(entry *models.ModerationLogEntry) error {
+	err := r.DB.QueryRow(`
+		INSERT INTO moderation_log (lobby_id, moderator_id, user_id, action, reason, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`, entry.LobbyID, entry.ModeratorID, entry.UserID, entry.Action, entry.Reason, entry.ExpiresAt).
+		Scan(&entry.ID, &entry.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error logging moderation action: %w", err)
+	}
+
+	return nil
+}
+
+// GetLog gets a lobby's moderation audit log, newest first. A positive
+// before returns only entries with a smaller ID.
+func (r *ModerationRepository) GetLog(lobbyID, before, limit int) ([]models.ModerationLogEntry, error) {
+	rows, err := r.DB.Query(`
+		SELECT id, lobby_id, moderator_id, user_id, action, reason, expires_at, created_at
+		FROM moderation_log
+		WHERE lobby_id = $1 AND ($2 <= 0 OR id < $2)
+		ORDER BY id DESC
+		LIMIT $3
+	`, lobbyID, before, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching moderation log: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []models.ModerationLogEntry
+	for rows.Next() {
+		var entry models.ModerationLogEntry
+		var expiresAt sql.NullTime
+		err := rows.Scan(&entry.ID, &entry.LobbyID, &entry.ModeratorID, &entry.UserID, &entry.Action,
+			&entry.Reason, &expiresAt, &entry.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning moderation log data: %w", err)
+		}
+		if expiresAt.Valid {
+			entry.ExpiresAt = &expiresAt.Time
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,119 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/galexander77/chat-app/api/emoji"
+	"github.com/galexander77/chat-app/api/models"
+)
+
+// ErrInvalidEmoji is returned when an emoji is neither bundled nor a custom lobby emoji
+var ErrInvalidEmoji = errors.New("invalid emoji")
+
+// ReactionRepository handles database operations for message reactions and custom emoji
+type ReactionRepository struct {
+	DB *sql.DB
+}
+
+// NewReactionRepository creates a new ReactionRepository
+func NewReactionRepository(db *sql.DB) *ReactionRepository {
+	return &ReactionRepository{DB: db}
+}
+
+// ValidateEmoji checks that an emoji is bundled or a custom emoji of the lobby
+// and returns the form it should be stored in
+func (r *ReactionRepository) ValidateEmoji(lobbyID int, e string) (string, error) {
+	if c, ok := emoji.Canonical(e); ok {
+		return c, nil
+	}
+
+	name, ok := emoji.ParseCustom(e)
+	if !ok {
+		return "", ErrInvalidEmoji
+	}
+
+	var exists bool
+	err := r.DB.QueryRow("SELECT EXISTS (SELECT 1 FROM lobby_emojis WHERE lobby_id = $1 AND name = $2)",
+		lobbyID, name).Scan(&exists)
+	if err != nil {
+		return "", fmt.Errorf("error checking lobby emoji: %w", err)
+	}
+	if !exists {
+		return "", ErrInvalidEmoji
+	}
+
+	return e, nil
+}
+
+// AddReaction adds a user's reaction to a message and reports whether it was new
+func (r *ReactionRepository) AddReaction(messageID, userID int, emoji string) (bool, error) {
+	result, err := r.DB.Exec(`
+		INSERT INTO message_reactions (message_id, user_id, emoji) VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+	`, messageID, userID, emoji)
+	if err != nil {
+		return false, fmt.Errorf("error adding reaction: %w", err)
+	}
+
+	added, err := result.RowsAffected()
+	return added > 0, err
+}
+
+// RemoveReaction removes a user's reaction from a message and reports whether it existed
+func (r *ReactionRepository) RemoveReaction(messageID, userID int, emoji string) (bool, error) {
+	result, err := r.DB.Exec("DELETE FROM message_reactions WHERE message_id = $1 AND user_id = $2 AND emoji = $3",
+		messageID, userID, emoji)
+	if err != nil {
+		return false, fmt.Errorf("error removing reaction: %w", err)
+	}
+
+	removed, err := result.RowsAffected()
+	return removed > 0, err
+}
+
+// CountReactions counts the reactions with an emoji on a message
+func (r *ReactionRepository) CountReactions(messageID int, emoji string) (int, error) {
+	var count int
+	err := r.DB.QueryRow("SELECT COUNT(*) FROM message_reactions WHERE message_id = $1 AND emoji = $2",
+		messageID, emoji).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("error counting reactions: %w", err)
+	}
+
+	return count, nil
+}
+
+// GetLobbyEmojis gets the custom emoji of a lobby
+func (r *ReactionRepository) GetLobbyEmojis(lobbyID int) ([]models.LobbyEmoji, error) {
+	rows, err := r.DB.Query("SELECT id, lobby_id, name, url, created_by FROM lobby_emojis WHERE lobby_id = $1 ORDER BY name",
+		lobbyID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching lobby emojis: %w", err)
+	}
+	defer rows.Close()
+
+	var emojis []models.LobbyEmoji
+	for rows.Next() {
+		var e models.LobbyEmoji
+		if err := rows.Scan(&e.ID, &e.LobbyID, &e.Name, &e.URL, &e.CreatedBy); err != nil {
+			return nil, fmt.Errorf("error scanning lobby emoji data: %w", err)
+		}
+		emojis = append(emojis, e)
+	}
+
+	return emojis, nil
+}
+
+// CreateLobbyEmoji adds a custom emoji to a lobby
+func (r *ReactionRepository) CreateLobbyEmoji(lobbyID int, name, url string, createdBy int) (int, error) {
+	var emojiID int
+	err := r.DB.QueryRow("INSERT INTO lobby_emojis (lobby_id, name, url, created_by) VALUES ($1, $2, $3, $4) RETURNING id",
+		lobbyID, name, url, createdBy).Scan(&emojiID)
+	if err != nil {
+		return 0, fmt.Errorf("error creating lobby emoji: %w", err)
+	}
+
+	return emojiID, nil
+}
@@ -0,0 +1,190 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/galexander77/chat-app/api/models"
+)
+
+// Report errors
+var (
+	ErrReportNotFound    = errors.New("report not found")
+	ErrAlreadyReported   = errors.New("message already reported by this user")
+	ErrReportUnavailable = errors.New("report is resolved or claimed by another moderator")
+)
+
+// ReportRepository handles database operations for message reports and the moderation review queue
+type ReportRepository struct {
+	DB *sql.DB
+}
+
+// NewReportRepository creates a new ReportRepository
+func NewReportRepository(db *sql.DB) *ReportRepository {
+	return &ReportRepository{DB: db}
+}
+
+// reportColumns is the column list of reports r selected after messageColumns
+const reportColumns = `r.id, r.lobby_id, r.source, r.reporter_id, r.filter, r.reason, r.details, r.status,
+	r.claimed_by, r.claimed_at, r.resolved_by, r.resolved_at, r.resolution, r.created_at`
+
+// scanReport scans a row selected with messageColumns and reportColumns into a report
+func scanReport(row rowScanner) (models.Report, error) {
+	var report models.Report
+	var reporterID, claimedBy, resolvedBy sql.NullInt64
+	var claimedAt, resolvedAt sql.NullTime
+	var err error
+	report.Message, err = scanMessage(row, &report.ID, &report.LobbyID, &report.Source, &reporterID, &report.Filter,
+		&report.Reason, &report.Details, &report.Status, &claimedBy, &claimedAt, &resolvedBy, &resolvedAt,
+		&report.Resolution, &report.CreatedAt)
+	if err != nil {
+		return report, err
+	}
+
+	if reporterID.Valid {
+		id := int(reporterID.Int64)
+		report.ReporterID = &id
+	}
+	if claimedBy.Valid {
+		id := int(claimedBy.Int64)
+		report.ClaimedBy = &id
+	}
+	if claimedAt.Valid {
+		report.ClaimedAt = &claimedAt.Time
+	}
+	if resolvedBy.Valid {
+		id := int(resolvedBy.Int64)
+		report.ResolvedBy = &id
+	}
+	if resolvedAt.Valid {
+		report.ResolvedAt = &resolvedAt.Time
+	}
+
+	return report, nil
+}
+
+// CreateReport records a user reporting a message
+func (r *ReportRepository) CreateReport(message *models.Message, reporterID int, reason, details string) (int, error) {
+	var reportID int
+	err := r.DB.QueryRow(`
+		INSERT INTO reports (message_id, lobby_id, source, reporter_id, reason, details)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (message_id, reporter_id) DO NOTHING
+		RETURNING id
+	`, message.ID, message.LobbyID, models.ReportSourceUser, reporterID, reason, details).Scan(&reportID)
+	if err == sql.ErrNoRows {
+		return 0, ErrAlreadyReported
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error creating report: %w", err)
+	}
+
+	return reportID, nil
+}
+
+// CreateFilterReport queues a message for review on behalf of a filter
+func (r *ReportRepository) CreateFilterReport(message *models.Message, filter, reason string) error {
+	_, err := r.DB.Exec(`
+		INSERT INTO reports (message_id, lobby_id, source, filter, reason, details)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, message.ID, message.LobbyID, models.ReportSourceFilter, filter, models.ReportOther, reason)
+	if err != nil {
+		return fmt.Errorf("error creating filter report: %w", err)
+	}
+
+	return nil
+}
+
+// GetReport gets a report by ID
+func (r *ReportRepository) GetReport(reportID int) (*models.Report, error) {
+	report, err := scanReport(r.DB.QueryRow(`
+		SELECT `+messageColumns+`, `+reportColumns+`
+		FROM `+messageTables+`
+		JOIN reports r ON r.message_id = m.id
+		WHERE r.id = $1
+	`, reportID))
+	if err == sql.ErrNoRows {
+		return nil, ErrReportNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching report: %w", err)
+	}
+
+	return &report, nil
+}
+
+// GetReports gets a lobby's reports, oldest first so the queue is worked in
+// order. An empty status returns unresolved reports. A positive after
+// returns only reports with a larger ID.
+func (r *ReportRepository) GetReports(lobbyID int, status string, after, limit int) ([]models.Report, error) {
+	rows, err := r.DB.Query(`
+		SELECT `+messageColumns+`, `+reportColumns+`
+		FROM `+messageTables+`
+		JOIN reports r ON r.message_id = m.id
+		WHERE r.lobby_id = $1 AND (r.status = $2 OR ($2 = '' AND r.status <> $3))
+			AND ($4 <= 0 OR r.id > $4)
+		ORDER BY r.id
+		LIMIT $5
+	`, lobbyID, status, models.ReportResolved, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching reports: %w", err)
+	}
+	defer rows.Close()
+
+	var reports []models.Report
+	for rows.Next() {
+		report, err := scanReport(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning report data: %w", err)
+		}
+		reports = append(reports, report)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading reports: %w", err)
+	}
+
+	return reports, nil
+}
+
+// ClaimReport assigns an unresolved report to a moderator. Claiming a report
+// the moderator already holds succeeds; ErrReportUnavailable is returned if
+// it is resolved or held by another moderator.
+func (r *ReportRepository) ClaimReport(reportID, moderatorID int) error {
+	result, err := r.DB.Exec(`
+		UPDATE reports SET status = $3, claimed_by = $2, claimed_at = COALESCE(claimed_at, CURRENT_TIMESTAMP)
+		WHERE id = $1 AND status <> $4 AND (claimed_by IS NULL OR claimed_by = $2)
+	`, reportID, moderatorID, models.ReportClaimed, models.ReportResolved)
+	if err != nil {
+		return fmt.Errorf("error claiming report: %w", err)
+	}
+
+	if claimed, _ := result.RowsAffected(); claimed == 0 {
+		return ErrReportUnavailable
+	}
+	return nil
+}
+
+// ResolveReports resolves a report together with the message's other
+// unresolved reports that are not claimed by another moderator. It returns
+// ErrReportUnavailable if the report itself is resolved or held by another
+// moderator.
+func (r *ReportRepository) ResolveReports(reportID, moderatorID int, resolution string) error {
+	result, err := r.DB.Exec(`
+		WITH target AS (
+			SELECT message_id FROM reports
+			WHERE id = $1 AND status <> $3 AND (claimed_by IS NULL OR claimed_by = $2)
+		)
+		UPDATE reports SET status = $3, resolved_by = $2, resolved_at = CURRENT_TIMESTAMP, resolution = $4
+		WHERE message_id = (SELECT message_id FROM target)
+			AND status <> $3 AND (claimed_by IS NULL OR claimed_by = $2)
+	`, reportID, moderatorID, models.ReportResolved, resolution)
+	if err != nil {
+		return fmt.Errorf("error resolving reports: %w", err)
+	}
+
+	if resolved, _ := result.RowsAffected(); resolved == 0 {
+		return ErrReportUnavailable
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/galexander77/chat-app/api/models"
+)
+
+// ErrRetentionNotFound is returned when a lobby has no retention policy of its own
+var ErrRetentionNotFound = errors.New("retention policy not found")
+
+// retentionColumns is the column list shared by retention policy queries
+const retentionColumns = `lobby_id, keep, days, messages, archive, updated_by, updated_at`
+
+// RetentionRepository handles database operations for message retention policies
+type RetentionRepository struct {
+	DB *sql.DB
+}
+
+// NewRetentionRepository creates a new RetentionRepository
+func NewRetentionRepository(db *sql.DB) *RetentionRepository {
+	return &RetentionRepository{DB: db}
+}
+
+// scanRetention scans a row selected with retentionColumns into a policy
+func scanRetention(row rowScanner) (models.RetentionPolicy, error) {
+	var policy models.RetentionPolicy
+	var lobbyID, updatedBy sql.NullInt64
+	var updatedAt sql.NullTime
+	err := row.Scan(&lobbyID, &policy.Keep, &policy.Days, &policy.Messages, &policy.Archive, &updatedBy, &updatedAt)
+	if err != nil {
+		return policy, err
+	}
+
+	if lobbyID.Valid {
+		id := int(lobbyID.Int64)
+		policy.LobbyID = &id
+	}
+	if updatedBy.Valid {
+		id := int(updatedBy.Int64)
+		policy.UpdatedBy = &id
+	}
+	if updatedAt.Valid {
+		policy.UpdatedAt = &updatedAt.Time
+	}
+	return policy, nil
+}
+
+// GetPolicies gets the default retention policy, which keeps messages
+// forever until one is set, and the policies of lobbies with their own
+func (r *RetentionRepository) GetPolicies() (*models.RetentionPolicies, error) {
+	policies := &models.RetentionPolicies{
+		Default: models.RetentionPolicy{Keep: models.RetentionForever},
+		Lobbies: []models.RetentionPolicy{},
+	}
+
+	rows, err := r.DB.Query("SELECT " + retentionColumns + " FROM retention_policies ORDER BY lobby_id NULLS FIRST")
+	if err != nil {
+		return nil, fmt.Errorf("error fetching retention policies: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		policy, err := scanRetention(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning retention policy data: %w", err)
+		}
+		if policy.LobbyID == nil {
+			policies.Default = policy
+		} else {
+			policies.Lobbies = append(policies.Lobbies, policy)
+		}
+	}
+
+	return policies, rows.Err()
+}
+
+// SetPolicy sets a lobby's retention policy, or the default policy if
+// lobbyID is 0
+func (r *RetentionRepository) SetPolicy(lobbyID int, req models.RetentionRequest, updatedBy int) (*models.RetentionPolicy, error) {
+	conflict := "(lobby_id)"
+	if lobbyID == 0 {
+		conflict = "((lobby_id IS NULL)) WHERE lobby_id IS NULL"
+	}
+
+	policy, err := scanRetention(r.DB.QueryRow(`
+		INSERT INTO retention_policies (lobby_id, keep, days, messages, archive, updated_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT `+conflict+` DO UPDATE SET
+			keep = EXCLUDED.keep,
+			days = EXCLUDED.days,
+			messages = EXCLUDED.messages,
+			archive = EXCLUDED.archive,
+			updated_by = EXCLUDED.updated_by,
+			updated_at = CURRENT_TIMESTAMP
+		RETURNING `+retentionColumns,
+		nullableID(lobbyID), req.Keep, req.Days, req.Messages, req.Archive, updatedBy))
+	if err != nil {
+		return nil, fmt.Errorf("error setting retention policy: %w", err)
+	}
+
+	return &policy, nil
+}
+
+// DeleteLobbyPolicy removes a lobby's own retention policy so the default applies
+func (r *RetentionRepository) DeleteLobbyPolicy(lobbyID int) error {
+	result, err := r.DB.Exec("DELETE FROM retention_policies WHERE lobby_id = $1", lobbyID)
+	if err != nil {
+		return fmt.Errorf("error deleting retention policy: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error checking retention policy deletion: %w", err)
+	}
+	if rows == 0 {
+		return ErrRetentionNotFound
+	}
+	return nil
+}
+
+// GetExpiringPolicies gets the policy in effect for every lobby whose
+// messages expire, with LobbyID set to the lobby even when it uses the
+// default policy
+func (r *RetentionRepository) GetExpiringPolicies() ([]models.RetentionPolicy, error) {
+	rows, err := r.DB.Query(`
+		SELECT l.id, p.keep, p.days, p.messages, p.archive, p.updated_by, p.updated_at
+		FROM lobbies l
+		JOIN retention_policies p ON p.lobby_id = l.id OR (
+			p.lobby_id IS NULL AND NOT EXISTS (SELECT 1 FROM retention_policies o WHERE o.lobby_id = l.id)
+		)
+		WHERE p.keep <> $1
+		ORDER BY l.id
+	`, models.RetentionForever)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching retention policies: %w", err)
+	}
+	defer rows.Close()
+
+	var policies []models.RetentionPolicy
+	for rows.Next() {
+		policy, err := scanRetention(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning retention policy data: %w", err)
+		}
+		policies = append(policies, policy)
+	}
+
+	return policies, rows.Err()
+}
+
+// PurgeExpired deletes or archives up to limit expired threads from a
+// lobby, oldest first, returning the removed top-level messages with only
+// their ID and lobby set and how many messages including replies were
+// removed. The stored files of their attachments are queued for removal by
+// the attachments delete trigger. Threads expire as a whole: under a days
+// policy once their last reply is older than the policy, and under a
+// messages policy once they fall outside the newest top-level messages.
+// Threads locked by concurrent writes, such as a reply being saved, are
+// skipped until the next batch, so purging never blocks sending messages.
+// Ephemeral messages are never archived.
+func (r *RetentionRepository) PurgeExpired(policy models.RetentionPolicy, limit int) ([]models.Message, int64, error) {
+	var expired string
+	var amount int
+	switch policy.Keep {
+	case models.RetentionDays:
+		expired = `m.timestamp < CURRENT_TIMESTAMP - make_interval(days => $2)
+			AND NOT EXISTS (
+				SELECT 1 FROM messages r
+				WHERE r.parent_id = m.id AND r.timestamp >= CURRENT_TIMESTAMP - make_interval(days => $2)
+			)`
+		amount = policy.Days
+	case models.RetentionMessages:
+		expired = `(m.timestamp, m.id) < (
+				SELECT n.timestamp, n.id FROM messages n
+				WHERE n.lobby_id = $1 AND n.parent_id IS NULL
+				ORDER BY n.timestamp DESC, n.id DESC
+				OFFSET $2 - 1 LIMIT 1
+			)`
+		amount = policy.Messages
+	default:
+		return nil, 0, nil
+	}
+	if policy.LobbyID == nil || amount <= 0 {
+		return nil, 0, nil
+	}
+
+	rows, err := r.DB.Query(`
+		WITH expired AS (
+			SELECT m.id FROM messages m
+			WHERE m.lobby_id = $1 AND m.parent_id IS NULL AND `+expired+`
+			ORDER BY m.timestamp, m.id
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		), archived AS (
+			INSERT INTO archived_messages (id, content, user_id, lobby_id, timestamp, parent_id, deleted_at, deleted_by)
+			SELECT id, content, user_id, lobby_id, timestamp, parent_id, deleted_at, deleted_by
+			FROM messages
+			WHERE $4 AND expires_at IS NULL AND (id IN (SELECT id FROM expired) OR parent_id IN (SELECT id FROM expired))
+			ON CONFLICT (id) DO NOTHING
+		), deleted AS (
+			DELETE FROM messages WHERE id IN (SELECT id FROM expired)
+			RETURNING id
+		)
+		SELECT d.id, (SELECT COUNT(*) FROM messages r WHERE r.parent_id = d.id)
+		FROM deleted d
+	`, *policy.LobbyID, amount, limit, policy.Archive)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error purging expired messages: %w", err)
+	}
+	defer rows.Close()
+
+	var purged []models.Message
+	var removed int64
+	for rows.Next() {
+		msg := models.Message{LobbyID: *policy.LobbyID}
+		var replies int64
+		if err := rows.Scan(&msg.ID, &replies); err != nil {
+			return nil, 0, fmt.Errorf("error scanning purged message data: %w", err)
+		}
+		purged = append(purged, msg)
+		removed += 1 + replies
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error purging expired messages: %w", err)
+	}
+
+	return purged, removed, nil
+}
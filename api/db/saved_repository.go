@@ -0,0 +1,154 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/galexander77/chat-app/api/models"
+	"github.com/lib/pq"
+)
+
+// ErrSavedNotFound is returned when a user has not saved a message
+var ErrSavedNotFound = errors.New("saved message not found")
+
+// SavedRepository handles database operations for saved messages
+type SavedRepository struct {
+	DB *sql.DB
+}
+
+// NewSavedRepository creates a new SavedRepository
+func NewSavedRepository(db *sql.DB) *SavedRepository {
+	return &SavedRepository{DB: db}
+}
+
+// SaveMessage saves a message to a user's list, or changes its note if
+// it is already saved
+func (r *SavedRepository) SaveMessage(userID int, message *models.Message, note string) (*models.SavedMessage, error) {
+	saved := models.SavedMessage{MessageID: message.ID, LobbyID: message.LobbyID, Note: note, Message: message}
+	err := r.DB.QueryRow(`
+		INSERT INTO saved_messages (user_id, message_id, lobby_id, note)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, message_id) DO UPDATE SET note = EXCLUDED.note
+		RETURNING id, created_at
+	`, userID, message.ID, message.LobbyID, note).Scan(&saved.ID, &saved.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error saving message: %w", err)
+	}
+
+	return &saved, nil
+}
+
+// UnsaveMessage removes a message from a user's list
+func (r *SavedRepository) UnsaveMessage(userID, messageID int) error {
+	result, err := r.DB.Exec("DELETE FROM saved_messages WHERE user_id = $1 AND message_id = $2", userID, messageID)
+	if err != nil {
+		return fmt.Errorf("error removing saved message: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error checking saved message removal: %w", err)
+	}
+	if rows == 0 {
+		return ErrSavedNotFound
+	}
+	return nil
+}
+
+// GetSavedMessages gets a user's saved messages, most recently saved first,
+// leaving out messages from users they have blocked. A positive before
+// returns only saved messages with a smaller ID. Messages in lobbies the
+// user can no longer access are returned without the message, and messages
+// deleted, purged or expired since are returned as deleted, with their
+// tombstone while there is one.
+func (r *SavedRepository) GetSavedMessages(userID, before, limit int) ([]models.SavedMessage, error) {
+	rows, err := r.DB.Query(`
+		SELECT s.id, s.message_id, s.lobby_id, s.note, s.created_at, m.id IS NOT NULL,
+			`+canAccess("s.lobby_id", "$1")+`
+		FROM saved_messages s
+		LEFT JOIN (SELECT id, user_id FROM messages WHERE `+notExpired+`) m ON m.id = s.message_id
+		WHERE s.user_id = $1 AND (m.id IS NULL OR `+notBlockedBy("$1")+`)
+			AND ($2 <= 0 OR s.id < $2)
+		ORDER BY s.id DESC
+		LIMIT $3
+	`, userID, before, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching saved messages: %w", err)
+	}
+	defer rows.Close()
+
+	saved := []models.SavedMessage{}
+	var messageIDs []int64
+	for rows.Next() {
+		var item models.SavedMessage
+		var exists, accessible bool
+		err := rows.Scan(&item.ID, &item.MessageID, &item.LobbyID, &item.Note, &item.CreatedAt, &exists, &accessible)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning saved message data: %w", err)
+		}
+
+		switch {
+		case !accessible:
+			item.Unavailable = models.SavedNoAccess
+		case !exists:
+			item.Unavailable = models.SavedDeleted
+		default:
+			messageIDs = append(messageIDs, int64(item.MessageID))
+		}
+		saved = append(saved, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	messages, err := r.getMessages(messageIDs)
+	if err != nil {
+		return nil, err
+	}
+	for i := range saved {
+		if saved[i].Unavailable != "" {
+			continue
+		}
+		// Messages can be removed between the queries
+		message, ok := messages[saved[i].MessageID]
+		if !ok {
+			saved[i].Unavailable = models.SavedDeleted
+			continue
+		}
+		saved[i].Message = &message
+		if message.DeletedAt != nil {
+			saved[i].Unavailable = models.SavedDeleted
+		}
+	}
+
+	return saved, nil
+}
+
+// getMessages gets saved messages by ID
+func (r *SavedRepository) getMessages(ids []int64) (map[int]models.Message, error) {
+	messages := make(map[int]models.Message, len(ids))
+	if len(ids) == 0 {
+		return messages, nil
+	}
+
+	rows, err := r.DB.Query(`
+		SELECT `+messageColumns+`
+		FROM `+messageTables+`
+		WHERE m.id = ANY($1)
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("error fetching saved messages: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning saved message data: %w", err)
+		}
+		messages[message.ID] = message
+	}
+
+	return messages, rows.Err()
+}
@@ -0,0 +1,250 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/galexander77/chat-app/api/models"
+)
+
+// Scheduled item errors
+var (
+	// ErrScheduledNotFound is returned when a user has no pending scheduled item with an ID
+	ErrScheduledNotFound = errors.New("scheduled item not found")
+	// ErrTooManyScheduled is returned when a user has as many pending items
+	// of a kind in a lobby as they may
+	ErrTooManyScheduled = errors.New("too many pending scheduled items")
+)
+
+// scheduledColumns is the column list shared by scheduled item queries
+const scheduledColumns = `
+	id, kind, user_id, lobby_id, content, parent_id, message_id, send_at,
+	status, sent_message_id, error, created_at, sent_at`
+
+// ScheduledRepository handles database operations for scheduled messages and reminders
+type ScheduledRepository struct {
+	DB *sql.DB
+}
+
+// NewScheduledRepository creates a new ScheduledRepository
+func NewScheduledRepository(db *sql.DB) *ScheduledRepository {
+	return &ScheduledRepository{DB: db}
+}
+
+// scanScheduled scans a row selected with scheduledColumns into a scheduled item
+func scanScheduled(row rowScanner) (models.ScheduledMessage, error) {
+	var item models.ScheduledMessage
+	var parentID, messageID, sentMessageID sql.NullInt64
+	var sentAt sql.NullTime
+	err := row.Scan(&item.ID, &item.Kind, &item.UserID, &item.LobbyID, &item.Content, &parentID, &messageID,
+		&item.SendAt, &item.Status, &sentMessageID, &item.Error, &item.CreatedAt, &sentAt)
+	if err != nil {
+		return item, err
+	}
+
+	if parentID.Valid {
+		id := int(parentID.Int64)
+		item.ParentID = &id
+	}
+	if messageID.Valid {
+		id := int(messageID.Int64)
+		item.MessageID = &id
+	}
+	if sentMessageID.Valid {
+		id := int(sentMessageID.Int64)
+		item.SentMessageID = &id
+	}
+	if sentAt.Valid {
+		item.SentAt = &sentAt.Time
+	}
+	return item, nil
+}
+
+// CreateScheduled schedules a message or reminder. A positive maxPending
+// caps the user's pending messages to its lobby, or their pending reminders
+// in every lobby, returning ErrTooManyScheduled once they have that many. The user's row stays locked
+// until the item is saved, so concurrent requests cannot exceed the cap.
+func (r *ScheduledRepository) CreateScheduled(item models.ScheduledMessage, maxPending int) (*models.ScheduledMessage, error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if maxPending > 0 {
+		if _, err := tx.Exec("SELECT 1 FROM users WHERE id = $1 FOR UPDATE", item.UserID); err != nil {
+			return nil, fmt.Errorf("error locking user: %w", err)
+		}
+		var pending int
+		err := tx.QueryRow(`
+			SELECT COUNT(*) FROM scheduled_messages
+			WHERE user_id = $1 AND kind = $2 AND status IN ($3, $4)
+			AND ($2 = $5 OR lobby_id = $6)
+		`, item.UserID, item.Kind, models.ScheduledPending, models.ScheduledSending, models.ScheduledKindReminder, item.LobbyID).Scan(&pending)
+		if err != nil {
+			return nil, fmt.Errorf("error counting scheduled items: %w", err)
+		}
+		if pending >= maxPending {
+			return nil, ErrTooManyScheduled
+		}
+	}
+
+	created, err := scanScheduled(tx.QueryRow(`
+		INSERT INTO scheduled_messages (kind, user_id, lobby_id, content, parent_id, message_id, send_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING `+scheduledColumns,
+		item.Kind, item.UserID, item.LobbyID, item.Content, item.ParentID, item.MessageID, item.SendAt.UTC()))
+	if err != nil {
+		return nil, fmt.Errorf("error scheduling %s: %w", item.Kind, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return &created, nil
+}
+
+// GetScheduled gets a user's scheduled items with a status, optionally of
+// one kind, in the order they are sent
+func (r *ScheduledRepository) GetScheduled(userID int, status, kind string) ([]models.ScheduledMessage, error) {
+	rows, err := r.DB.Query(`
+		SELECT `+scheduledColumns+`
+		FROM scheduled_messages
+		WHERE user_id = $1 AND status = $2 AND ($3 = '' OR kind = $3)
+		ORDER BY send_at, id
+	`, userID, status, kind)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching scheduled items: %w", err)
+	}
+	defer rows.Close()
+
+	items := []models.ScheduledMessage{}
+	for rows.Next() {
+		item, err := scanScheduled(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning scheduled item data: %w", err)
+		}
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+// CancelScheduled deletes one of a user's pending scheduled items
+func (r *ScheduledRepository) CancelScheduled(id, userID int) error {
+	result, err := r.DB.Exec("DELETE FROM scheduled_messages WHERE id = $1 AND user_id = $2 AND status = $3",
+		id, userID, models.ScheduledPending)
+	if err != nil {
+		return fmt.Errorf("error canceling scheduled item: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error checking cancel result: %w", err)
+	}
+	if rows == 0 {
+		return ErrScheduledNotFound
+	}
+	return nil
+}
+
+// ClaimDue marks up to limit pending items that are due as sending and
+// returns them, oldest first. Items claimed elsewhere are skipped.
+func (r *ScheduledRepository) ClaimDue(limit int) ([]models.ScheduledMessage, error) {
+	rows, err := r.DB.Query(`
+		UPDATE scheduled_messages SET status = $1
+		WHERE id IN (
+			SELECT id FROM scheduled_messages
+			WHERE status = $2 AND send_at <= CURRENT_TIMESTAMP
+			ORDER BY send_at, id
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+scheduledColumns,
+		models.ScheduledSending, models.ScheduledPending, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error claiming scheduled items: %w", err)
+	}
+	defer rows.Close()
+
+	var items []models.ScheduledMessage
+	for rows.Next() {
+		item, err := scanScheduled(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning scheduled item data: %w", err)
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if !items[i].SendAt.Equal(items[j].SendAt) {
+			return items[i].SendAt.Before(items[j].SendAt)
+		}
+		return items[i].ID < items[j].ID
+	})
+	return items, nil
+}
+
+// ReleaseClaimed makes items left sending, by a server that stopped while
+// delivering them, pending again. Scheduled messages are marked sent in the
+// transaction that saves them, so a message that was saved is never left
+// sending and is not sent twice.
+func (r *ScheduledRepository) ReleaseClaimed() (int64, error) {
+	result, err := r.DB.Exec("UPDATE scheduled_messages SET status = $1 WHERE status = $2",
+		models.ScheduledPending, models.ScheduledSending)
+	if err != nil {
+		return 0, fmt.Errorf("error releasing scheduled items: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// MarkSent records that a reminder was delivered. Scheduled messages are
+// marked sent by the message save, see markScheduledSent.
+func (r *ScheduledRepository) MarkSent(id int) error {
+	return markScheduledSent(r.DB, id, nil)
+}
+
+// markScheduledSent records that a scheduled item was delivered, as the
+// given message for scheduled messages
+func markScheduledSent(exec execer, id int, messageID *int) error {
+	_, err := exec.Exec(`
+		UPDATE scheduled_messages
+		SET status = $1, sent_message_id = $2, sent_at = CURRENT_TIMESTAMP
+		WHERE id = $3
+	`, models.ScheduledSent, messageID, id)
+	if err != nil {
+		return fmt.Errorf("error marking scheduled item sent: %w", err)
+	}
+	return nil
+}
+
+// Postpone makes a claimed item pending again, to be sent at sendAt
+func (r *ScheduledRepository) Postpone(id int, sendAt time.Time) error {
+	_, err := r.DB.Exec("UPDATE scheduled_messages SET status = $1, send_at = $2 WHERE id = $3",
+		models.ScheduledPending, sendAt.UTC(), id)
+	if err != nil {
+		return fmt.Errorf("error postponing scheduled item: %w", err)
+	}
+	return nil
+}
+
+// MarkFailed records why a scheduled item could not be delivered
+func (r *ScheduledRepository) MarkFailed(id int, reason string) error {
+	_, err := r.DB.Exec(`
+		UPDATE scheduled_messages
+		SET status = $1, error = $2, sent_at = CURRENT_TIMESTAMP
+		WHERE id = $3
+	`, models.ScheduledFailed, reason, id)
+	if err != nil {
+		return fmt.Errorf("error marking scheduled item failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/galexander77/chat-app/api/models"
+)
+
+// SearchRepository handles full-text search of messages
+type SearchRepository struct {
+	DB *sql.DB
+}
+
+// NewSearchRepository creates a new SearchRepository
+func NewSearchRepository(db *sql.DB) *SearchRepository {
+	return &SearchRepository{DB: db}
+}
+
+// Snippet highlighting. Postgres marks matches with private use characters,
+// which are swapped for <mark> tags once the rest of the snippet is escaped.
+const (
+	matchStart = "\uE000"
+	matchStop  = "\uE001"
+)
+
+// headlineOptions configures the snippets returned by ts_headline
+const headlineOptions = "StartSel=" + matchStart + ", StopSel=" + matchStop +
+	`, MaxWords=25, MinWords=10, MaxFragments=2, FragmentDelimiter=" … "`
+
+// highlighter turns the match markers in an escaped snippet into <mark> tags
+var highlighter = strings.NewReplacer(matchStart, "<mark>", matchStop, "</mark>")
+
+// searchRank is the relevance of message m to the query q
+const searchRank = "ts_rank(m.search, q.query)"
+
+// searchCursor is the position after the last result of a search page
+type searchCursor struct {
+	Rank float32 `json:"r"`
+	ID   int     `json:"id"`
+}
+
+// encode encodes a cursor as an opaque URL-safe string
+func (c searchCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeSearchCursor decodes a cursor returned by a previous search page
+func decodeSearchCursor(s string) (searchCursor, error) {
+	var c searchCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(data, &c) != nil {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// SearchMessages finds messages matching a web search style query (quoted
+// phrases, or, and -excluded words) in the lobbies a user can read, most
+// relevant first. Lobbies the searcher is banned from, deleted messages and
+// messages from users the searcher has blocked are left out. It returns the
+// cursor of the next page, or an empty string on the last page.
+func (r *SearchRepository) SearchMessages(opts models.MessageSearchOptions) ([]models.SearchResult, string, error) {
+	args := []interface{}{opts.Query, opts.UserID, opts.LobbyID, opts.FromID, opts.Before, opts.After,
+		headlineOptions, opts.Limit + 1}
+
+	after := ""
+	if opts.Cursor != "" {
+		cursor, err := decodeSearchCursor(opts.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		after = "AND (" + searchRank + ", m.id) < ($9::real, $10)"
+		args = append(args, cursor.Rank, cursor.ID)
+	}
+
+	rows, err := r.DB.Query(`
+		SELECT `+messageColumns+`, `+searchRank+`, ts_headline('english', m.content, q.query, $7)
+		FROM websearch_to_tsquery('english', $1) AS q(query), `+messageTables+`
+		WHERE m.search @@ q.query AND m.deleted_at IS NULL
+			AND `+canAccess("m.lobby_id", "$2")+`
+			AND ($3 = 0 OR m.lobby_id = $3)
+			AND ($4 = 0 OR m.user_id = $4)
+			AND ($5::timestamp IS NULL OR m.timestamp < $5)
+			AND ($6::timestamp IS NULL OR m.timestamp > $6)
+			AND `+notBlockedBy("$2")+`
+			`+after+`
+		ORDER BY `+searchRank+` DESC, m.id DESC
+		LIMIT $8
+	`, args...)
+	if err != nil {
+		return nil, "", fmt.Errorf("error searching messages: %w", err)
+	}
+	defer rows.Close()
+
+	results := []models.SearchResult{}
+	var next searchCursor
+	for rows.Next() {
+		// The extra row only tells us there is another page
+		if len(results) == opts.Limit {
+			return results, next.encode(), nil
+		}
+
+		var result models.SearchResult
+		result.Message, err = scanMessage(rows, &result.Rank, &result.Snippet)
+		if err != nil {
+			return nil, "", fmt.Errorf("error scanning search result: %w", err)
+		}
+		result.Snippet = highlighter.Replace(html.EscapeString(result.Snippet))
+		results = append(results, result)
+		next = searchCursor{Rank: result.Rank, ID: result.Message.ID}
+	}
+
+	return results, "", nil
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 
 	"github.com/galexander77/chat-app/api/models"
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -83,3 +84,86 @@ func (r *UserRepository) GetUsernameByID(userID int) (string, error) {
 
 	return username, nil
 }
+
+// GetUserRole gets the role of a user
+func (r *UserRepository) GetUserRole(userID int) (string, error) {
+	var role string
+	err := r.DB.QueryRow("SELECT role FROM users WHERE id = $1", userID).Scan(&role)
+	if err != nil {
+		return "", fmt.Errorf("error getting user role: %w", err)
+	}
+
+	return role, nil
+}
+
+// IsModerator reports whether a user has the moderator or admin role
+func (r *UserRepository) IsModerator(userID int) (bool, error) {
+	role, err := r.GetUserRole(userID)
+	if err != nil {
+		return false, err
+	}
+
+	return role == models.RoleModerator || role == models.RoleAdmin, nil
+}
+
+// IsAdmin reports whether a user has the admin role
+func (r *UserRepository) IsAdmin(userID int) (bool, error) {
+	role, err := r.GetUserRole(userID)
+	if err != nil {
+		return false, err
+	}
+
+	return role == models.RoleAdmin, nil
+}
+
+// GetUserIDsByUsernames resolves usernames to user IDs, skipping unknown usernames
+func (r *UserRepository) GetUserIDsByUsernames(usernames []string) (map[string]int, error) {
+	userIDs := make(map[string]int)
+	if len(usernames) == 0 {
+		return userIDs, nil
+	}
+
+	rows, err := r.DB.Query("SELECT id, username FROM users WHERE username = ANY($1)", pq.Array(usernames))
+	if err != nil {
+		return nil, fmt.Errorf("error resolving usernames: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var username string
+		if err := rows.Scan(&id, &username); err != nil {
+			return nil, fmt.Errorf("error scanning user data: %w", err)
+		}
+		userIDs[username] = id
+	}
+
+	return userIDs, nil
+}
+
+// CreatePlaceholderUsers creates users with no password for the usernames
+// that are not taken yet, such as the authors of imported messages, and
+// returns the IDs of all the usernames
+func (r *UserRepository) CreatePlaceholderUsers(usernames []string) (map[string]int, error) {
+	_, err := r.DB.Exec(`
+		INSERT INTO users (username, password)
+		SELECT unnest($1::text[]), ''
+		ON CONFLICT (username) DO NOTHING
+	`, pq.Array(usernames))
+	if err != nil {
+		return nil, fmt.Errorf("error creating placeholder users: %w", err)
+	}
+
+	return r.GetUserIDsByUsernames(usernames)
+}
+
+// UsersExist reports whether every given user ID belongs to a user. IDs may repeat.
+func (r *UserRepository) UsersExist(userIDs []int) (bool, error) {
+	var count int
+	err := r.DB.QueryRow("SELECT COUNT(*) FROM users WHERE id = ANY($1)", pq.Array(userIDs)).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("error checking users: %w", err)
+	}
+
+	return count == len(uniqueSorted(userIDs)), nil
+}
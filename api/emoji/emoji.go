@@ -0,0 +1,64 @@
+package emoji
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// variationSelector is the emoji presentation selector, which clients
+// include or omit inconsistently
+const variationSelector = "\ufe0f"
+
+// customPattern matches custom lobby emoji shortcodes such as :party_parrot:
+var customPattern = regexp.MustCompile(`^:([a-z0-9_]{2,32}):$`)
+
+// bundled is the list of standard emoji accepted as reactions
+var bundled = []string{
+	"👍", "👎", "👏", "🙌", "🙏", "💪", "👀", "👋", "🤝", "✌️",
+	"😀", "😃", "😄", "😁", "😆", "😅", "😂", "🤣", "🙂", "😉",
+	"😊", "😇", "😍", "🥰", "😘", "😎", "🤓", "🤔", "🤨", "😐",
+	"😑", "😶", "🙄", "😏", "😬", "😌", "😴", "😷", "🤒", "🤯",
+	"🥳", "😕", "😟", "😮", "😲", "😳", "🥺", "😢", "😭", "😱",
+	"😤", "😡", "🤬", "💀", "💩", "🤡", "👻", "🤖", "😺", "🙈",
+	"❤️", "🧡", "💛", "💚", "💙", "💜", "🖤", "💔", "💯", "💥",
+	"🔥", "✨", "⭐", "🌟", "🎉", "🎊", "🎂", "🎁", "🏆", "🚀",
+	"✅", "❌", "❓", "❗", "⚠️", "💡", "📌", "📣", "🔔", "⏰",
+	"☕", "🍕", "🍺", "🍻", "🐛", "🐐", "🦄", "🌈", "☀️", "🌧️",
+}
+
+// canonical maps each bundled emoji, without variation selectors, to its bundled form
+var canonical = make(map[string]string, len(bundled))
+
+func init() {
+	for _, e := range bundled {
+		canonical[strings.ReplaceAll(e, variationSelector, "")] = e
+	}
+}
+
+// Canonical returns the bundled form of a standard emoji and whether it is bundled
+func Canonical(e string) (string, bool) {
+	c, ok := canonical[strings.ReplaceAll(e, variationSelector, "")]
+	return c, ok
+}
+
+// ParseCustom returns the name of a custom emoji shortcode such as :name:
+func ParseCustom(e string) (string, bool) {
+	match := customPattern.FindStringSubmatch(e)
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
+
+// ValidName reports whether name can be used for a custom lobby emoji
+func ValidName(name string) bool {
+	return customPattern.MatchString(":" + name + ":")
+}
+
+// ValidURL reports whether rawURL can be used as a custom lobby emoji image,
+// which must be an absolute https URL
+func ValidURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && u.Scheme == "https" && u.Host != "" && u.User == nil
+}
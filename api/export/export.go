@@ -0,0 +1,246 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/galexander77/chat-app/api/models"
+)
+
+// Export formats
+const (
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+	FormatHTML = "html"
+	FormatText = "txt"
+)
+
+// ErrUnknownFormat is returned for an unsupported export format
+var ErrUnknownFormat = errors.New("unknown export format")
+
+// timeFormat is how timestamps are written in exports, always in UTC
+const timeFormat = time.RFC3339
+
+// Writer writes a lobby transcript one message at a time, so transcripts
+// of any length can be streamed. Begin is called once before the messages
+// and End once after them.
+type Writer interface {
+	Begin(lobby *models.Lobby) error
+	Write(message models.Message) error
+	End() error
+}
+
+// NewWriter creates a Writer for a format
+func NewWriter(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case FormatJSON:
+		return &jsonWriter{w: w}, nil
+	case FormatCSV:
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	case FormatHTML:
+		return &htmlWriter{w: w}, nil
+	case FormatText:
+		return &textWriter{w: w}, nil
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
+
+// ContentType gets the MIME type of a format
+func ContentType(format string) string {
+	switch format {
+	case FormatJSON:
+		return "application/json"
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatHTML:
+		return "text/html; charset=utf-8"
+	default:
+		return "text/plain; charset=utf-8"
+	}
+}
+
+// jsonWriter writes a JSON object with the lobby and an array of messages
+type jsonWriter struct {
+	w     io.Writer
+	count int
+}
+
+// Begin opens the object and writes the lobby
+func (j *jsonWriter) Begin(lobby *models.Lobby) error {
+	data, err := json.Marshal(lobby)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(j.w, `{"lobby":%s,"messages":[`, data)
+	return err
+}
+
+// Write appends a message to the messages array
+func (j *jsonWriter) Write(message models.Message) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	if j.count > 0 {
+		if _, err := io.WriteString(j.w, ","); err != nil {
+			return err
+		}
+	}
+	j.count++
+	_, err = j.w.Write(data)
+	return err
+}
+
+// End closes the messages array and the object
+func (j *jsonWriter) End() error {
+	_, err := io.WriteString(j.w, "]}\n")
+	return err
+}
+
+// csvWriter writes a row per message under a header row
+type csvWriter struct {
+	w *csv.Writer
+}
+
+// Begin writes the header row
+func (c *csvWriter) Begin(lobby *models.Lobby) error {
+	return c.w.Write([]string{"id", "timestamp", "user_id", "username", "parent_id", "content", "deleted_at", "deleted_by"})
+}
+
+// Write writes a message row
+func (c *csvWriter) Write(message models.Message) error {
+	return c.w.Write([]string{
+		strconv.Itoa(message.ID),
+		message.Timestamp.UTC().Format(timeFormat),
+		strconv.Itoa(message.UserID),
+		message.Username,
+		optionalID(message.ParentID),
+		message.Content,
+		optionalTime(message.DeletedAt),
+		optionalID(message.DeletedBy),
+	})
+}
+
+// End flushes buffered rows
+func (c *csvWriter) End() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// htmlWriter writes a standalone HTML page. Content is written from the
+// message's sanitized HTML.
+type htmlWriter struct {
+	w io.Writer
+}
+
+// Begin writes the page head and the lobby name as its heading
+func (h *htmlWriter) Begin(lobby *models.Lobby) error {
+	title := html.EscapeString(lobby.Name)
+	_, err := fmt.Fprintf(h.w, `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+<style>
+body { font-family: sans-serif; max-width: 50rem; margin: 2rem auto; }
+.message { margin: 0.5rem 0; }
+.reply { margin-left: 2rem; }
+.meta { color: #666; font-size: 0.85rem; }
+.deleted { color: #999; font-style: italic; }
+</style>
+</head>
+<body>
+<h1>%s</h1>
+`, title, title)
+	return err
+}
+
+// Write writes a message, indenting replies
+func (h *htmlWriter) Write(message models.Message) error {
+	class := "message"
+	if message.ParentID != nil {
+		class += " reply"
+	}
+
+	body := message.HTML
+	if message.DeletedAt != nil {
+		note := `<p class="deleted">Message deleted</p>`
+		if message.Content != "" {
+			note = `<p class="deleted">Deleted:</p>` + body
+		}
+		body = note
+	}
+
+	_, err := fmt.Fprintf(h.w, `<div class="%s" id="message-%d"><div class="meta"><strong>%s</strong> <time datetime="%s">%s</time></div>%s</div>
+`, class, message.ID, html.EscapeString(message.Username), message.Timestamp.UTC().Format(timeFormat),
+		message.Timestamp.UTC().Format("2006-01-02 15:04:05"), body)
+	return err
+}
+
+// End closes the page
+func (h *htmlWriter) End() error {
+	_, err := io.WriteString(h.w, "</body>\n</html>\n")
+	return err
+}
+
+// textWriter writes a line per message, indenting continuation lines and replies
+type textWriter struct {
+	w io.Writer
+}
+
+// Begin writes the lobby name as a heading
+func (t *textWriter) Begin(lobby *models.Lobby) error {
+	_, err := fmt.Fprintf(t.w, "# %s\n\n", lobby.Name)
+	return err
+}
+
+// Write writes a message line
+func (t *textWriter) Write(message models.Message) error {
+	indent := ""
+	if message.ParentID != nil {
+		indent = "    "
+	}
+
+	content := message.Content
+	if message.DeletedAt != nil {
+		if content == "" {
+			content = "[message deleted]"
+		} else {
+			content = "[deleted] " + content
+		}
+	}
+	content = strings.ReplaceAll(content, "\n", "\n"+indent+"    ")
+
+	_, err := fmt.Fprintf(t.w, "%s[%s] %s: %s\n", indent, message.Timestamp.UTC().Format("2006-01-02 15:04:05"),
+		message.Username, content)
+	return err
+}
+
+// End does nothing, as text transcripts have no footer
+func (t *textWriter) End() error {
+	return nil
+}
+
+// optionalID formats an optional ID, empty if unset
+func optionalID(id *int) string {
+	if id == nil {
+		return ""
+	}
+	return strconv.Itoa(*id)
+}
+
+// optionalTime formats an optional time, empty if unset
+func optionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(timeFormat)
+}
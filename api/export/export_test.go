@@ -0,0 +1,174 @@
+package export
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/galexander77/chat-app/api/markdown"
+	"github.com/galexander77/chat-app/api/models"
+)
+
+// transcript is a lobby with a message, a reply, a tombstone and a deleted
+// message shown to a moderator, with HTML in every name and message
+func transcript() (*models.Lobby, []models.Message) {
+	start := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	deletedAt := start.Add(6 * time.Minute)
+	parentID, moderatorID := 1, 2
+
+	message := func(id int, username, content string) models.Message {
+		return models.Message{
+			ID:        id,
+			Content:   content,
+			HTML:      markdown.Render(content),
+			UserID:    3,
+			Username:  username,
+			LobbyID:   1,
+			Timestamp: start.Add(time.Duration(id-1) * time.Minute),
+		}
+	}
+
+	first := message(1, "<b>amy</b>", "<i>hi</i> & *there*\nbye")
+	first.UserID = 2
+	reply := message(2, "bob", "ok")
+	reply.ParentID = &parentID
+	tombstone := message(3, "bob", "")
+	tombstone.HTML = ""
+	tombstone.DeletedAt, tombstone.DeletedBy = &deletedAt, &moderatorID
+	deleted := message(4, "bob", "spam")
+	deleted.DeletedAt, deleted.DeletedBy = &deletedAt, &moderatorID
+
+	return &models.Lobby{ID: 1, Name: "Tea & <Cakes>"}, []models.Message{first, reply, tombstone, deleted}
+}
+
+func TestWriters(t *testing.T) {
+	tests := []struct {
+		format string
+		// want are parts of the output in the order they must appear
+		want    []string
+		notWant []string
+	}{
+		{
+			format: FormatJSON,
+			want: []string{
+				`{"lobby":{"id":1,"name":"Tea \u0026 \u003cCakes\u003e"`,
+				`"messages":[{"id":1,`,
+				`"username":"\u003cb\u003eamy\u003c/b\u003e"`,
+				`},{"id":2,"content":"ok"`,
+				`"parent_id":1`,
+				`},{"id":3,"content":"","html":""`,
+				`"deleted_at":"2024-01-02T15:10:05Z","deleted_by":2`,
+				`},{"id":4,"content":"spam"`,
+				"}]}\n",
+			},
+			notWant: []string{"<b>"},
+		},
+		{
+			format: FormatCSV,
+			want: []string{
+				"id,timestamp,user_id,username,parent_id,content,deleted_at,deleted_by\n" +
+					"1,2024-01-02T15:04:05Z,2,<b>amy</b>,,\"<i>hi</i> & *there*\nbye\",,\n" +
+					"2,2024-01-02T15:05:05Z,3,bob,1,ok,,\n" +
+					"3,2024-01-02T15:06:05Z,3,bob,,,2024-01-02T15:10:05Z,2\n" +
+					"4,2024-01-02T15:07:05Z,3,bob,,spam,2024-01-02T15:10:05Z,2\n",
+			},
+		},
+		{
+			format: FormatHTML,
+			want: []string{
+				"<!DOCTYPE html>",
+				"<title>Tea &amp; &lt;Cakes&gt;</title>",
+				"<h1>Tea &amp; &lt;Cakes&gt;</h1>\n",
+				`<div class="message" id="message-1"><div class="meta"><strong>&lt;b&gt;amy&lt;/b&gt;</strong> <time datetime="2024-01-02T15:04:05Z">2024-01-02 15:04:05</time></div>` +
+					"<p>&lt;i&gt;hi&lt;/i&gt; &amp; <em>there</em><br>bye</p></div>\n",
+				`<div class="message reply" id="message-2">`,
+				"<p>ok</p></div>\n",
+				`<div class="message" id="message-3">`,
+				`</div><p class="deleted">Message deleted</p></div>` + "\n",
+				`<div class="message" id="message-4">`,
+				`</div><p class="deleted">Deleted:</p><p>spam</p></div>` + "\n",
+				"</body>\n</html>\n",
+			},
+			notWant: []string{"<b>", "<i>", "<Cakes>"},
+		},
+		{
+			format: FormatText,
+			want: []string{
+				"# Tea & <Cakes>\n\n" +
+					"[2024-01-02 15:04:05] <b>amy</b>: <i>hi</i> & *there*\n    bye\n" +
+					"    [2024-01-02 15:05:05] bob: ok\n" +
+					"[2024-01-02 15:06:05] bob: [message deleted]\n" +
+					"[2024-01-02 15:07:05] bob: [deleted] spam\n",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			lobby, messages := transcript()
+			var b strings.Builder
+			w, err := NewWriter(tt.format, &b)
+			if err != nil {
+				t.Fatalf("NewWriter() error = %v", err)
+			}
+			if err := w.Begin(lobby); err != nil {
+				t.Fatalf("Begin() error = %v", err)
+			}
+			for _, message := range messages {
+				if err := w.Write(message); err != nil {
+					t.Fatalf("Write() error = %v", err)
+				}
+			}
+			if err := w.End(); err != nil {
+				t.Fatalf("End() error = %v", err)
+			}
+			out := b.String()
+
+			rest := out
+			for _, want := range tt.want {
+				i := strings.Index(rest, want)
+				if i < 0 {
+					t.Fatalf("output is missing %q in order:\n%s", want, out)
+				}
+				rest = rest[i+len(want):]
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(out, notWant) {
+					t.Errorf("output contains unescaped %q:\n%s", notWant, out)
+				}
+			}
+			if tt.format == FormatJSON && !json.Valid([]byte(out)) {
+				t.Errorf("output is not valid JSON:\n%s", out)
+			}
+		})
+	}
+}
+
+func TestJSONWriterEmpty(t *testing.T) {
+	var b strings.Builder
+	w, _ := NewWriter(FormatJSON, &b)
+	if err := w.Begin(&models.Lobby{ID: 1, Name: "empty"}); err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	if err := w.End(); err != nil {
+		t.Fatalf("End() error = %v", err)
+	}
+
+	var got struct {
+		Lobby    models.Lobby     `json:"lobby"`
+		Messages []models.Message `json:"messages"`
+	}
+	if err := json.Unmarshal([]byte(b.String()), &got); err != nil {
+		t.Fatalf("error decoding %q: %v", b.String(), err)
+	}
+	if got.Lobby.Name != "empty" || got.Messages == nil || len(got.Messages) != 0 {
+		t.Errorf("transcript = %+v, want lobby empty with no messages", got)
+	}
+}
+
+func TestNewWriterUnknownFormat(t *testing.T) {
+	if _, err := NewWriter("pdf", nil); err != ErrUnknownFormat {
+		t.Errorf("NewWriter(pdf) error = %v, want %v", err, ErrUnknownFormat)
+	}
+}
@@ -0,0 +1,79 @@
+package filter
+
+// Filter actions
+const (
+	Allow   = "allow"   // deliver the message unchanged
+	Rewrite = "rewrite" // deliver the message with rewritten content
+	Reject  = "reject"  // refuse the message
+	Queue   = "queue"   // deliver the message and queue it for moderator review
+)
+
+// Message is a chat message about to be saved
+type Message struct {
+	LobbyID int
+	UserID  int
+	Content string
+}
+
+// Verdict is a filter's decision about a message. Content holds the
+// rewritten content for Rewrite, and Filter and Reason explain Reject and
+// Queue verdicts.
+type Verdict struct {
+	Action  string
+	Content string
+	Filter  string
+	Reason  string
+}
+
+// MessageFilter inspects chat messages before they are saved
+type MessageFilter interface {
+	Name() string
+	Check(msg Message) Verdict
+}
+
+// Chain runs message filters in order
+type Chain []MessageFilter
+
+// Run passes a message through the chain. Rewrites are applied before the
+// next filter runs, the first rejection stops the chain, and a message
+// queued by any filter is queued once the chain completes. The returned
+// verdict's Content is always the content to save.
+func (c Chain) Run(msg Message) Verdict {
+	result := Verdict{Action: Allow, Content: msg.Content}
+
+	for _, f := range c {
+		v := f.Check(msg)
+		switch v.Action {
+		case Rewrite:
+			msg.Content = v.Content
+			result.Content = v.Content
+			if result.Action == Allow {
+				result.Action = Rewrite
+			}
+		case Reject:
+			return Verdict{Action: Reject, Filter: f.Name(), Reason: v.Reason}
+		case Queue:
+			if result.Action != Queue {
+				result.Action = Queue
+				result.Filter = f.Name()
+				result.Reason = v.Reason
+			}
+		}
+	}
+
+	return result
+}
+
+// ValidAction reports whether an action may be configured for a filter match
+func ValidAction(action string) bool {
+	return action == Rewrite || action == Reject || action == Queue
+}
+
+// match returns the verdict of a filter that matched a message. Rewrite
+// verdicts carry the rewritten content.
+func match(action, content, reason string) Verdict {
+	if action == Rewrite {
+		return Verdict{Action: Rewrite, Content: content, Reason: reason}
+	}
+	return Verdict{Action: action, Reason: reason}
+}
@@ -0,0 +1,67 @@
+package filter
+
+import "testing"
+
+// stubFilter returns a fixed verdict and records the content it was given
+type stubFilter struct {
+	name    string
+	verdict Verdict
+	seen    *[]string
+}
+
+func (f stubFilter) Name() string {
+	return f.name
+}
+
+func (f stubFilter) Check(msg Message) Verdict {
+	if f.seen != nil {
+		*f.seen = append(*f.seen, msg.Content)
+	}
+	return f.verdict
+}
+
+func TestChainRun(t *testing.T) {
+	allow := stubFilter{name: "allow", verdict: Verdict{Action: Allow}}
+	rewrite := stubFilter{name: "rewrite", verdict: Verdict{Action: Rewrite, Content: "rewritten"}}
+	reject := stubFilter{name: "reject", verdict: Verdict{Action: Reject, Reason: "rejected"}}
+	queue := stubFilter{name: "queue", verdict: Verdict{Action: Queue, Reason: "queued"}}
+	queueAgain := stubFilter{name: "queue again", verdict: Verdict{Action: Queue, Reason: "queued again"}}
+
+	tests := []struct {
+		name  string
+		chain Chain
+		want  Verdict
+	}{
+		{"empty chain", nil, Verdict{Action: Allow, Content: "hello"}},
+		{"all allow", Chain{allow, allow}, Verdict{Action: Allow, Content: "hello"}},
+		{"rewrite", Chain{allow, rewrite}, Verdict{Action: Rewrite, Content: "rewritten"}},
+		{"reject stops the chain", Chain{rewrite, reject, queue}, Verdict{Action: Reject, Filter: "reject", Reason: "rejected"}},
+		{"queue keeps content", Chain{queue}, Verdict{Action: Queue, Content: "hello", Filter: "queue", Reason: "queued"}},
+		{"queue after rewrite", Chain{rewrite, queue}, Verdict{Action: Queue, Content: "rewritten", Filter: "queue", Reason: "queued"}},
+		{"rewrite after queue stays queued", Chain{queue, rewrite}, Verdict{Action: Queue, Content: "rewritten", Filter: "queue", Reason: "queued"}},
+		{"first queue is reported", Chain{queue, queueAgain}, Verdict{Action: Queue, Content: "hello", Filter: "queue", Reason: "queued"}},
+		{"reject after queue", Chain{queue, reject}, Verdict{Action: Reject, Filter: "reject", Reason: "rejected"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.chain.Run(Message{LobbyID: 1, UserID: 1, Content: "hello"})
+			if got != tt.want {
+				t.Errorf("Run() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChainRunPassesRewrites(t *testing.T) {
+	var seen []string
+	chain := Chain{
+		stubFilter{name: "rewrite", verdict: Verdict{Action: Rewrite, Content: "rewritten"}},
+		stubFilter{name: "allow", verdict: Verdict{Action: Allow}, seen: &seen},
+	}
+
+	chain.Run(Message{Content: "hello"})
+	if len(seen) != 1 || seen[0] != "rewritten" {
+		t.Errorf("next filter saw %q, want [rewritten]", seen)
+	}
+}
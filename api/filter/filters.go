@@ -0,0 +1,293 @@
+package filter
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/galexander77/chat-app/api/config"
+)
+
+// NewChain builds the chain of built-in filters enabled in the configuration
+func NewChain(cfg config.FiltersConfig) Chain {
+	var chain Chain
+	if len(cfg.Words) > 0 {
+		chain = append(chain, NewWordFilter(cfg.Words, cfg.WordsAction))
+	}
+	if len(cfg.AllowedDomains) > 0 || len(cfg.DeniedDomains) > 0 {
+		chain = append(chain, NewLinkFilter(cfg.AllowedDomains, cfg.DeniedDomains, cfg.LinksAction))
+	}
+	if cfg.RepeatLimit > 0 && cfg.RepeatWindow > 0 {
+		chain = append(chain, NewSpamFilter(cfg.RepeatLimit, cfg.RepeatWindow, cfg.RepeatAction))
+	}
+	if cfg.CapsMinLength > 0 {
+		chain = append(chain, NewCapsFilter(cfg.CapsMinLength, cfg.CapsAction))
+	}
+	return chain
+}
+
+// actionOr returns action if it is valid, otherwise the fallback
+func actionOr(action, fallback string) string {
+	if ValidAction(action) {
+		return action
+	}
+	return fallback
+}
+
+// WordFilter matches messages containing listed words. Rewriting masks the words.
+type WordFilter struct {
+	pattern *regexp.Regexp
+	action  string
+}
+
+// NewWordFilter creates a WordFilter matching whole words case-insensitively.
+// Words are delimited by anything but letters and digits in any script, as
+// \b only knows ASCII. The action defaults to Rewrite.
+func NewWordFilter(words []string, action string) *WordFilter {
+	quoted := make([]string, len(words))
+	for i, w := range words {
+		quoted[i] = regexp.QuoteMeta(w)
+	}
+	return &WordFilter{
+		pattern: regexp.MustCompile(`(?i)(?:^|[^\p{L}\p{N}])(` + strings.Join(quoted, "|") + `)`),
+		action:  actionOr(action, Rewrite),
+	}
+}
+
+// Name returns the filter's name
+func (f *WordFilter) Name() string {
+	return "words"
+}
+
+// Check checks a message for listed words
+func (f *WordFilter) Check(msg Message) Verdict {
+	var masked strings.Builder
+	matched, last := false, 0
+	for _, m := range f.pattern.FindAllStringSubmatchIndex(msg.Content, -1) {
+		// The pattern checks the boundary before the word, and the one
+		// after it is checked here so it remains free to start the next match
+		start, end := m[2], m[3]
+		if next, _ := utf8.DecodeRuneInString(msg.Content[end:]); end < len(msg.Content) && isWordRune(next) {
+			continue
+		}
+
+		matched = true
+		masked.WriteString(msg.Content[last:start])
+		masked.WriteString(strings.Repeat("*", utf8.RuneCountInString(msg.Content[start:end])))
+		last = end
+	}
+	if !matched {
+		return Verdict{Action: Allow}
+	}
+
+	masked.WriteString(msg.Content[last:])
+	return match(f.action, masked.String(), "Message contains a blocked word")
+}
+
+// isWordRune reports whether a rune is a letter or digit in any script
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r)
+}
+
+// linkPattern matches links with a scheme or a www. prefix
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"]+`)
+
+// LinkFilter matches messages linking to denied domains or, if an allow list
+// is set, to domains not on it. Rewriting removes the links. Domains match
+// their subdomains.
+type LinkFilter struct {
+	allowed []string
+	denied  []string
+	action  string
+}
+
+// NewLinkFilter creates a LinkFilter. The action defaults to Reject.
+func NewLinkFilter(allowed, denied []string, action string) *LinkFilter {
+	return &LinkFilter{
+		allowed: lowerAll(allowed),
+		denied:  lowerAll(denied),
+		action:  actionOr(action, Reject),
+	}
+}
+
+// Name returns the filter's name
+func (f *LinkFilter) Name() string {
+	return "links"
+}
+
+// Check checks the domains of a message's links
+func (f *LinkFilter) Check(msg Message) Verdict {
+	matched := false
+	rewritten := linkPattern.ReplaceAllStringFunc(msg.Content, func(link string) string {
+		if f.permitted(link) {
+			return link
+		}
+		matched = true
+		return "[link removed]"
+	})
+	if !matched {
+		return Verdict{Action: Allow}
+	}
+
+	return match(f.action, rewritten, "Message links to a domain that is not allowed")
+}
+
+// permitted reports whether a link's domain is allowed
+func (f *LinkFilter) permitted(link string) bool {
+	if !strings.Contains(link, "://") {
+		link = "http://" + link
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+
+	if matchesDomain(host, f.denied) {
+		return false
+	}
+	return len(f.allowed) == 0 || matchesDomain(host, f.allowed)
+}
+
+// matchesDomain reports whether a host is one of the domains or a subdomain of one
+func matchesDomain(host string, domains []string) bool {
+	for _, d := range domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// lowerAll lowercases a list of strings
+func lowerAll(values []string) []string {
+	lowered := make([]string, len(values))
+	for i, v := range values {
+		lowered[i] = strings.ToLower(v)
+	}
+	return lowered
+}
+
+// SpamFilter matches users repeating the same message in a lobby
+type SpamFilter struct {
+	limit  int
+	window time.Duration
+	action string
+
+	mutex     sync.Mutex
+	recent    map[spamKey]*repeats
+	lastPrune time.Time
+}
+
+// spamKey identifies a user's messages in a lobby
+type spamKey struct {
+	lobbyID int
+	userID  int
+}
+
+// repeats tracks how often a user has sent the same message
+type repeats struct {
+	content string
+	count   int
+	first   time.Time
+}
+
+// NewSpamFilter creates a SpamFilter matching a user's message once it has
+// been sent limit times in a row within window. The action defaults to
+// Reject; Rewrite is not supported.
+func NewSpamFilter(limit int, window time.Duration, action string) *SpamFilter {
+	if action == Rewrite {
+		action = Reject
+	}
+	return &SpamFilter{
+		limit:  limit,
+		window: window,
+		action: actionOr(action, Reject),
+		recent: make(map[spamKey]*repeats),
+	}
+}
+
+// Name returns the filter's name
+func (f *SpamFilter) Name() string {
+	return "spam"
+}
+
+// Check records a message and checks whether it repeats the user's previous messages
+func (f *SpamFilter) Check(msg Message) Verdict {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	now := time.Now()
+	f.prune(now)
+
+	content := strings.ToLower(strings.Join(strings.Fields(msg.Content), " "))
+	key := spamKey{lobbyID: msg.LobbyID, userID: msg.UserID}
+	r, ok := f.recent[key]
+	if !ok || r.content != content || now.Sub(r.first) > f.window {
+		f.recent[key] = &repeats{content: content, count: 1, first: now}
+		return Verdict{Action: Allow}
+	}
+
+	r.count++
+	if r.count < f.limit {
+		return Verdict{Action: Allow}
+	}
+	return match(f.action, "", "Message repeated too many times")
+}
+
+// prune forgets repeats older than the window, at most once per window.
+// The caller must hold mutex.
+func (f *SpamFilter) prune(now time.Time) {
+	if now.Sub(f.lastPrune) < f.window {
+		return
+	}
+	for key, r := range f.recent {
+		if now.Sub(r.first) > f.window {
+			delete(f.recent, key)
+		}
+	}
+	f.lastPrune = now
+}
+
+// capsRatio is the share of uppercase letters above which a message is shouting
+const capsRatio = 0.7
+
+// CapsFilter matches messages written mostly in capital letters. Rewriting
+// lowercases them.
+type CapsFilter struct {
+	minLength int
+	action    string
+}
+
+// NewCapsFilter creates a CapsFilter ignoring messages with fewer than
+// minLength letters. The action defaults to Rewrite.
+func NewCapsFilter(minLength int, action string) *CapsFilter {
+	return &CapsFilter{minLength: minLength, action: actionOr(action, Rewrite)}
+}
+
+// Name returns the filter's name
+func (f *CapsFilter) Name() string {
+	return "caps"
+}
+
+// Check checks the share of uppercase letters in a message
+func (f *CapsFilter) Check(msg Message) Verdict {
+	letters, upper := 0, 0
+	for _, r := range msg.Content {
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+	}
+	if letters < f.minLength || float64(upper) < capsRatio*float64(letters) {
+		return Verdict{Action: Allow}
+	}
+
+	return match(f.action, strings.ToLower(msg.Content), "Message is in capital letters")
+}
@@ -0,0 +1,137 @@
+package filter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWordFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		words   []string
+		action  string
+		content string
+		want    Verdict
+	}{
+		{"no match", []string{"darn"}, "", "hello there", Verdict{Action: Allow}},
+		{"masks the word", []string{"darn"}, "", "oh darn it", Verdict{Action: Rewrite, Content: "oh **** it", Reason: "Message contains a blocked word"}},
+		{"case insensitive", []string{"darn"}, "", "DaRn", Verdict{Action: Rewrite, Content: "****", Reason: "Message contains a blocked word"}},
+		{"whole words only", []string{"ass"}, "", "classic assignment", Verdict{Action: Allow}},
+		{"several words", []string{"darn", "heck"}, "", "darn heck", Verdict{Action: Rewrite, Content: "**** ****", Reason: "Message contains a blocked word"}},
+		{"adjacent words", []string{"darn"}, "", "darn darn", Verdict{Action: Rewrite, Content: "**** ****", Reason: "Message contains a blocked word"}},
+		{"word followed by a longer word", []string{"darn"}, "", "darnit darn", Verdict{Action: Rewrite, Content: "darnit ****", Reason: "Message contains a blocked word"}},
+		{"non-ASCII word", []string{"café"}, "", "un café!", Verdict{Action: Rewrite, Content: "un ****!", Reason: "Message contains a blocked word"}},
+		{"inside a non-ASCII word", []string{"darn"}, "", "ädarn darnö", Verdict{Action: Allow}},
+		{"other scripts", []string{"дурак"}, "", "ты дурак", Verdict{Action: Rewrite, Content: "ты *****", Reason: "Message contains a blocked word"}},
+		{"quotes metacharacters", []string{"a.b"}, "", "axb a.b", Verdict{Action: Rewrite, Content: "axb ***", Reason: "Message contains a blocked word"}},
+		{"reject", []string{"darn"}, Reject, "darn", Verdict{Action: Reject, Reason: "Message contains a blocked word"}},
+		{"queue", []string{"darn"}, Queue, "darn", Verdict{Action: Queue, Reason: "Message contains a blocked word"}},
+		{"invalid action defaults to rewrite", []string{"darn"}, "allow", "darn", Verdict{Action: Rewrite, Content: "****", Reason: "Message contains a blocked word"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewWordFilter(tt.words, tt.action).Check(Message{Content: tt.content})
+			if got != tt.want {
+				t.Errorf("Check(%q) = %+v, want %+v", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLinkFilter(t *testing.T) {
+	const reason = "Message links to a domain that is not allowed"
+
+	tests := []struct {
+		name    string
+		allowed []string
+		denied  []string
+		action  string
+		content string
+		want    Verdict
+	}{
+		{"no links", nil, []string{"bad.com"}, "", "hello", Verdict{Action: Allow}},
+		{"allowed link", nil, []string{"bad.com"}, "", "see https://good.com/x", Verdict{Action: Allow}},
+		{"denied link", nil, []string{"bad.com"}, "", "see https://bad.com/x", Verdict{Action: Reject, Reason: reason}},
+		{"denied subdomain", nil, []string{"bad.com"}, "", "http://www.Bad.COM", Verdict{Action: Reject, Reason: reason}},
+		{"lookalike domain", nil, []string{"bad.com"}, "", "https://notbad.com", Verdict{Action: Allow}},
+		{"www link without scheme", nil, []string{"bad.com"}, "", "www.bad.com", Verdict{Action: Reject, Reason: reason}},
+		{"allow list", []string{"good.com"}, nil, "", "https://docs.good.com", Verdict{Action: Allow}},
+		{"not on allow list", []string{"good.com"}, nil, "", "https://other.com", Verdict{Action: Reject, Reason: reason}},
+		{"deny wins over allow", []string{"good.com"}, []string{"evil.good.com"}, "", "https://evil.good.com", Verdict{Action: Reject, Reason: reason}},
+		{"rewrite removes the link", nil, []string{"bad.com"}, Rewrite, "see https://bad.com and https://good.com", Verdict{Action: Rewrite, Content: "see [link removed] and https://good.com", Reason: reason}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewLinkFilter(tt.allowed, tt.denied, tt.action).Check(Message{Content: tt.content})
+			if got != tt.want {
+				t.Errorf("Check(%q) = %+v, want %+v", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpamFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []Message
+		// want is the action of the last message
+		want string
+	}{
+		{"under the limit", []Message{{UserID: 1, Content: "hi"}, {UserID: 1, Content: "hi"}}, Allow},
+		{"at the limit", []Message{{UserID: 1, Content: "hi"}, {UserID: 1, Content: "hi"}, {UserID: 1, Content: "hi"}}, Reject},
+		{"ignores case and spacing", []Message{{UserID: 1, Content: "hi there"}, {UserID: 1, Content: "HI  there"}, {UserID: 1, Content: " hi there "}}, Reject},
+		{"different message resets", []Message{{UserID: 1, Content: "hi"}, {UserID: 1, Content: "hi"}, {UserID: 1, Content: "bye"}}, Allow},
+		{"other users", []Message{{UserID: 1, Content: "hi"}, {UserID: 2, Content: "hi"}, {UserID: 3, Content: "hi"}}, Allow},
+		{"other lobbies", []Message{{LobbyID: 1, UserID: 1, Content: "hi"}, {LobbyID: 2, UserID: 1, Content: "hi"}, {LobbyID: 3, UserID: 1, Content: "hi"}}, Allow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewSpamFilter(3, time.Minute, "")
+			var got Verdict
+			for _, msg := range tt.messages {
+				got = f.Check(msg)
+			}
+			if got.Action != tt.want {
+				t.Errorf("last message action = %q, want %q", got.Action, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpamFilterRewriteRejects(t *testing.T) {
+	if f := NewSpamFilter(3, time.Minute, Rewrite); f.action != Reject {
+		t.Errorf("action = %q, want %q", f.action, Reject)
+	}
+}
+
+func TestCapsFilter(t *testing.T) {
+	const reason = "Message is in capital letters"
+
+	tests := []struct {
+		name    string
+		action  string
+		content string
+		want    Verdict
+	}{
+		{"lowercase", "", "hello everyone", Verdict{Action: Allow}},
+		{"too short", "", "OK", Verdict{Action: Allow}},
+		{"shouting", "", "HELLO EVERYONE", Verdict{Action: Rewrite, Content: "hello everyone", Reason: reason}},
+		{"mostly caps", "", "HELLO EVERYONe", Verdict{Action: Rewrite, Content: "hello everyone", Reason: reason}},
+		{"some caps", "", "Hello Everyone", Verdict{Action: Allow}},
+		{"digits do not count", "", "AB 1234567890", Verdict{Action: Allow}},
+		{"non-ASCII letters", "", "ÉCOUTEZ TOUS", Verdict{Action: Rewrite, Content: "écoutez tous", Reason: reason}},
+		{"queue", Queue, "HELLO EVERYONE", Verdict{Action: Queue, Reason: reason}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewCapsFilter(5, tt.action).Check(Message{Content: tt.content})
+			if got != tt.want {
+				t.Errorf("Check(%q) = %+v, want %+v", tt.content, got, tt.want)
+			}
+		})
+	}
+}
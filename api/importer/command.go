@@ -0,0 +1,176 @@
+package importer
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/galexander77/chat-app/api/db"
+)
+
+// Import sources
+const (
+	SourceSlack = "slack"
+	SourceIRC   = "irc"
+)
+
+// Run runs the import command with its command-line arguments, writing the
+// report to out:
+//
+//	import -source=slack [-owner=username] [-dry-run] export.zip
+//	import -source=irc [-lobby=name] [-date=2024-01-02] [-owner=username] [-dry-run] file.log...
+//
+// Messages longer than maxMessageLength characters are skipped.
+func Run(database *sql.DB, args []string, maxMessageLength int, out io.Writer) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.SetOutput(out)
+	source := flags.String("source", "", "format of the history to import: slack or irc")
+	lobby := flags.String("lobby", "", "lobby to import IRC logs into (default: from each file's name)")
+	date := flags.String("date", "", "date of IRC logs whose lines and file names have no date (YYYY-MM-DD)")
+	owner := flags.String("owner", "", "username of the user who owns the lobbies created by the import")
+	dryRun := flags.Bool("dry-run", false, "report what would be imported without importing anything")
+	batchSize := flags.Int("batch", defaultBatchSize, "number of messages inserted per transaction")
+	if err := flags.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("no files to import")
+	}
+	if *batchSize <= 0 {
+		return errors.New("batch must be positive")
+	}
+
+	var channels []Channel
+	var err error
+	switch *source {
+	case SourceSlack:
+		if flags.NArg() != 1 {
+			return errors.New("slack imports take a single export zip")
+		}
+		channels, err = ParseSlack(flags.Arg(0))
+	case SourceIRC:
+		channels, err = parseIRCFiles(flags.Args(), *lobby, *date)
+	default:
+		return errors.New("source must be slack or irc")
+	}
+	if err != nil {
+		return err
+	}
+
+	im := NewImporter(db.NewUserRepository(database), db.NewLobbyRepository(database), db.NewMessageRepository(database))
+	im.BatchSize = *batchSize
+	im.MaxMessageLength = maxMessageLength
+	if *owner != "" {
+		ownerIDs, err := im.Users.GetUserIDsByUsernames([]string{*owner})
+		if err != nil {
+			return err
+		}
+		if im.OwnerID = ownerIDs[*owner]; im.OwnerID == 0 {
+			return fmt.Errorf("owner %s not found", *owner)
+		}
+	}
+
+	report, err := im.Import(channels, *dryRun)
+	if err != nil {
+		return err
+	}
+	writeReport(out, report)
+	return nil
+}
+
+// parseIRCFiles parses IRC logs, merging the logs of each lobby. Lobby
+// names and dates are taken from the file names unless given.
+func parseIRCFiles(filenames []string, lobby, date string) ([]Channel, error) {
+	var defaultDay time.Time
+	if date != "" {
+		var err error
+		if defaultDay, err = time.Parse("2006-01-02", date); err != nil {
+			return nil, errors.New("date must be formatted as YYYY-MM-DD")
+		}
+	}
+
+	byName := make(map[string]*Channel)
+	var names []string
+	for _, filename := range filenames {
+		name, day := IRCFileInfo(filename)
+		if lobby != "" {
+			name = lobby
+		}
+		if name == "" {
+			return nil, fmt.Errorf("%s: cannot tell the lobby from the file name; give one", filename)
+		}
+		if day.IsZero() {
+			day = defaultDay
+		}
+
+		file, err := os.Open(filename)
+		if err != nil {
+			return nil, fmt.Errorf("error opening IRC log: %w", err)
+		}
+		parsed, err := ParseIRC(name, file, day)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+
+		channel, ok := byName[name]
+		if !ok {
+			channel = &Channel{Name: name}
+			byName[name] = channel
+			names = append(names, name)
+		}
+		channel.Messages = append(channel.Messages, parsed.Messages...)
+		channel.Skipped += parsed.Skipped
+	}
+
+	channels := make([]Channel, 0, len(names))
+	for _, name := range names {
+		channel := byName[name]
+		sort.SliceStable(channel.Messages, func(i, j int) bool {
+			return channel.Messages[i].Timestamp.Before(channel.Messages[j].Timestamp)
+		})
+		channels = append(channels, *channel)
+	}
+	return channels, nil
+}
+
+// writeReport writes a summary of an import
+func writeReport(out io.Writer, report *Report) {
+	if report.DryRun {
+		fmt.Fprintln(out, "Dry run, nothing was imported")
+	}
+
+	for _, lobby := range report.Lobbies {
+		status := fmt.Sprintf("existing lobby %d", lobby.LobbyID)
+		switch {
+		case lobby.Created && report.DryRun:
+			status = "new lobby"
+		case lobby.Created:
+			status = fmt.Sprintf("new lobby %d", lobby.LobbyID)
+		}
+		fmt.Fprintf(out, "%s (%s %s): %d messages, %d of them replies", lobby.Name, lobby.Visibility, status, lobby.Messages, lobby.Replies)
+		if lobby.Messages > 0 {
+			fmt.Fprintf(out, ", %s to %s", lobby.First.Format(time.RFC3339), lobby.Last.Format(time.RFC3339))
+		}
+		if lobby.Existing > 0 {
+			fmt.Fprintf(out, ", %d already imported", lobby.Existing)
+		}
+		fmt.Fprintln(out)
+	}
+
+	fmt.Fprintf(out, "Authors matching existing users: %d\n", len(report.ExistingUsers))
+	fmt.Fprintf(out, "Placeholder users: %d", len(report.NewUsers))
+	if len(report.NewUsers) > 0 {
+		fmt.Fprintf(out, " (%s)", strings.Join(report.NewUsers, ", "))
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintf(out, "Skipped lines, events and messages: %d\n", report.Skipped)
+}
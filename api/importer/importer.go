@@ -0,0 +1,319 @@
+package importer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/galexander77/chat-app/api/content"
+	"github.com/galexander77/chat-app/api/db"
+	"github.com/galexander77/chat-app/api/models"
+)
+
+// maxNameLength is the longest username or lobby name the database accepts
+const maxNameLength = 50
+
+// defaultBatchSize is how many messages are inserted per transaction
+const defaultBatchSize = 500
+
+// Message is a message parsed from another chat system's history
+type Message struct {
+	// Key identifies the message within its channel so replies can refer to
+	// it and imports can be repeated without duplicating it
+	Key string
+	// ParentKey is the Key of the message this replies to, if any
+	ParentKey string
+	Author    string
+	Content   string
+	Timestamp time.Time
+}
+
+// Channel is a channel's parsed history, imported into the lobby of the same name
+type Channel struct {
+	Name string
+	// Visibility is the visibility of the lobby the channel is imported
+	// into, public if empty
+	Visibility string
+	// Members are the usernames added to a private channel's lobby
+	Members  []string
+	Messages []Message
+	// Skipped counts lines and events that are not chat messages, such as
+	// joins, or that have no text or are too long to import
+	Skipped int
+}
+
+// Importer imports parsed history, mapping authors to existing users by
+// username and creating placeholder users for the rest
+type Importer struct {
+	Users    *db.UserRepository
+	Lobbies  *db.LobbyRepository
+	Messages *db.MessageRepository
+	// OwnerID owns the lobbies created by the import, if positive
+	OwnerID int
+	// BatchSize is how many messages are inserted per transaction
+	BatchSize int
+	// MaxMessageLength is the longest message in characters that is imported
+	MaxMessageLength int
+}
+
+// Report describes what an import did, or would do in a dry run
+type Report struct {
+	DryRun        bool
+	Lobbies       []LobbyReport
+	ExistingUsers []string
+	NewUsers      []string
+	Skipped       int
+}
+
+// LobbyReport describes the messages imported into one lobby
+type LobbyReport struct {
+	Name       string
+	Visibility string
+	// LobbyID is 0 in a dry run if the lobby would be created
+	LobbyID  int
+	Created  bool
+	Messages int
+	Replies  int
+	// Existing counts messages already imported by an earlier run, which
+	// are not imported again. It is 0 in a dry run.
+	Existing int
+	First    time.Time
+	Last     time.Time
+}
+
+// NewImporter creates an Importer using the given repositories
+func NewImporter(users *db.UserRepository, lobbies *db.LobbyRepository, messages *db.MessageRepository) *Importer {
+	return &Importer{Users: users, Lobbies: lobbies, Messages: messages, BatchSize: defaultBatchSize}
+}
+
+// Import imports channels into lobbies of the same name and visibility,
+// creating the lobbies that do not exist. Existing lobbies of another
+// visibility are refused, so private history never lands in a public lobby.
+// Message content is cleaned like chat messages, and messages left empty or
+// longer than MaxMessageLength are skipped. Messages already imported into
+// the lobby are not imported again. A dry run only reports what would be
+// imported. Each batch of messages is committed separately, so a failed
+// import may leave the batches before the failure imported.
+func (im *Importer) Import(channels []Channel, dryRun bool) (*Report, error) {
+	report := &Report{DryRun: dryRun}
+
+	for i := range channels {
+		if channels[i].Visibility == "" {
+			channels[i].Visibility = models.VisibilityPublic
+		}
+		var skipped int
+		channels[i].Messages, skipped = cleanMessages(channels[i].Messages, im.MaxMessageLength)
+		channels[i].Skipped += skipped
+		assignKeys(channels[i].Messages)
+	}
+
+	userIDs, err := im.mapAuthors(channels, report)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, channel := range channels {
+		lobby := LobbyReport{Name: truncate(channel.Name), Visibility: channel.Visibility}
+		report.Skipped += channel.Skipped
+
+		existing, err := im.Lobbies.GetLobbyByName(lobby.Name)
+		switch {
+		case err == nil && existing.Visibility != channel.Visibility:
+			return nil, fmt.Errorf("cannot import %s channel %s into %s lobby %d",
+				channel.Visibility, channel.Name, existing.Visibility, existing.ID)
+		case err == nil:
+			lobby.LobbyID = existing.ID
+		case errors.Is(err, db.ErrLobbyNotFound):
+			lobby.Created = true
+		default:
+			return nil, err
+		}
+
+		topLevel, replies := splitReplies(channel.Messages)
+		lobby.Messages = len(topLevel) + len(replies)
+		lobby.Replies = len(replies)
+		if len(channel.Messages) > 0 {
+			lobby.First = channel.Messages[0].Timestamp
+			lobby.Last = channel.Messages[len(channel.Messages)-1].Timestamp
+		}
+
+		if !dryRun {
+			if err := im.importChannel(&lobby, channel.Members, topLevel, replies, userIDs); err != nil {
+				return nil, fmt.Errorf("error importing %s: %w", lobby.Name, err)
+			}
+		}
+		report.Lobbies = append(report.Lobbies, lobby)
+	}
+
+	return report, nil
+}
+
+// mapAuthors resolves the authors of all messages and the members of
+// private channels to user IDs, creating placeholder users for unknown ones
+// unless this is a dry run. The existing and new usernames are recorded in
+// the report.
+func (im *Importer) mapAuthors(channels []Channel, report *Report) (map[string]int, error) {
+	seen := make(map[string]bool)
+	var authors []string
+	add := func(name string) {
+		name = truncate(name)
+		if !seen[name] {
+			seen[name] = true
+			authors = append(authors, name)
+		}
+	}
+	for _, channel := range channels {
+		for _, msg := range channel.Messages {
+			add(msg.Author)
+		}
+		for _, member := range channel.Members {
+			add(member)
+		}
+	}
+	sort.Strings(authors)
+
+	userIDs, err := im.Users.GetUserIDsByUsernames(authors)
+	if err != nil {
+		return nil, err
+	}
+	for _, author := range authors {
+		if _, ok := userIDs[author]; ok {
+			report.ExistingUsers = append(report.ExistingUsers, author)
+		} else {
+			report.NewUsers = append(report.NewUsers, author)
+		}
+	}
+
+	if report.DryRun || len(report.NewUsers) == 0 {
+		return userIDs, nil
+	}
+	return im.Users.CreatePlaceholderUsers(authors)
+}
+
+// importChannel creates the channel's lobby if needed, adds the members of
+// a private channel and inserts its messages in batches, top-level messages
+// first so replies can refer to their parents' IDs
+func (im *Importer) importChannel(lobby *LobbyReport, members []string, topLevel, replies []Message, userIDs map[string]int) error {
+	if lobby.Created {
+		settings := models.LobbySettings{PostPolicy: models.PostPolicyEveryone}
+		lobbyID, err := im.Lobbies.CreateLobby(lobby.Name, lobby.Visibility, im.OwnerID, settings)
+		if err != nil {
+			return err
+		}
+		lobby.LobbyID = lobbyID
+	}
+
+	if lobby.Visibility == models.VisibilityPrivate && len(members) > 0 {
+		memberIDs := make([]int, len(members))
+		for i, member := range members {
+			memberIDs[i] = userIDs[truncate(member)]
+		}
+		if err := im.Lobbies.AddMembers(lobby.LobbyID, memberIDs); err != nil {
+			return err
+		}
+	}
+
+	messageIDs := make(map[string]int)
+	for _, group := range [][]Message{topLevel, replies} {
+		for start := 0; start < len(group); start += im.BatchSize {
+			batch := group[start:min(start+im.BatchSize, len(group))]
+
+			imported := make([]models.ImportedMessage, len(batch))
+			for i, msg := range batch {
+				imported[i] = models.ImportedMessage{
+					UserID:    userIDs[truncate(msg.Author)],
+					Content:   msg.Content,
+					Timestamp: msg.Timestamp,
+					ImportKey: msg.Key,
+				}
+				if msg.ParentKey != "" {
+					parentID := messageIDs[msg.ParentKey]
+					imported[i].ParentID = &parentID
+				}
+			}
+
+			ids, added, err := im.Messages.ImportMessages(lobby.LobbyID, imported)
+			if err != nil {
+				return err
+			}
+			lobby.Existing += len(batch) - added
+			for i, msg := range batch {
+				messageIDs[msg.Key] = ids[i]
+			}
+		}
+	}
+
+	return nil
+}
+
+// cleanMessages cleans message content like chat messages, dropping the
+// messages left empty or longer than maxLength, and returns the kept
+// messages and how many were dropped
+func cleanMessages(messages []Message, maxLength int) ([]Message, int) {
+	kept := messages[:0]
+	for _, msg := range messages {
+		cleaned, err := content.Clean(msg.Content, maxLength)
+		if err != nil {
+			continue
+		}
+		msg.Content = cleaned
+		kept = append(kept, msg)
+	}
+	return kept, len(messages) - len(kept)
+}
+
+// assignKeys gives messages without a key, such as IRC lines, one derived
+// from their timestamp, author and content, so importing the same history
+// again finds them. Identical messages sent at the same time are told apart
+// by the order they appear in.
+func assignKeys(messages []Message) {
+	seen := make(map[string]int)
+	for i, msg := range messages {
+		if msg.Key != "" {
+			continue
+		}
+		hash := sha256.Sum256([]byte(msg.Timestamp.UTC().Format(time.RFC3339Nano) + "\x00" + msg.Author + "\x00" + msg.Content))
+		key := hex.EncodeToString(hash[:16])
+		seen[key]++
+		messages[i].Key = key + "-" + strconv.Itoa(seen[key])
+	}
+}
+
+// splitReplies separates replies from top-level messages. Threads are one
+// level deep, so replies whose parent is missing from the history or is
+// itself a reply become top-level messages.
+func splitReplies(messages []Message) ([]Message, []Message) {
+	isTopLevel := make(map[string]bool)
+	for _, msg := range messages {
+		if msg.Key != "" && msg.ParentKey == "" {
+			isTopLevel[msg.Key] = true
+		}
+	}
+
+	var topLevel, replies []Message
+	for _, msg := range messages {
+		if msg.ParentKey != "" && isTopLevel[msg.ParentKey] {
+			replies = append(replies, msg)
+			continue
+		}
+		msg.ParentKey = ""
+		topLevel = append(topLevel, msg)
+	}
+
+	return topLevel, replies
+}
+
+// truncate shortens a username or lobby name to the longest the database
+// accepts, trimming surrounding whitespace
+func truncate(name string) string {
+	name = strings.TrimSpace(name)
+	if runes := []rune(name); len(runes) > maxNameLength {
+		name = string(runes[:maxNameLength])
+	}
+	return name
+}
@@ -0,0 +1,60 @@
+package importer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCleanMessages(t *testing.T) {
+	messages := []Message{
+		{Key: "1", Content: "  hello\x07  "},
+		{Key: "2", Content: " \t "},
+		{Key: "3", Content: strings.Repeat("a", 11)},
+		{Key: "4", Content: "é"},
+	}
+
+	kept, skipped := cleanMessages(messages, 10)
+	if skipped != 2 {
+		t.Errorf("skipped = %d, want 2", skipped)
+	}
+	var got []string
+	for _, msg := range kept {
+		got = append(got, msg.Key+":"+msg.Content)
+	}
+	if want := []string{"1:hello", "4:é"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("kept = %q, want %q", got, want)
+	}
+}
+
+func TestAssignKeys(t *testing.T) {
+	at := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	messages := func() []Message {
+		return []Message{
+			{Author: "alice", Content: "hi", Timestamp: at},
+			{Author: "alice", Content: "hi", Timestamp: at},
+			{Author: "bob", Content: "hi", Timestamp: at},
+			{Key: "1704207845.000100", Author: "alice", Content: "hi", Timestamp: at},
+		}
+	}
+
+	first := messages()
+	assignKeys(first)
+	seen := make(map[string]bool)
+	for _, msg := range first {
+		if msg.Key == "" || seen[msg.Key] {
+			t.Errorf("key %q is empty or repeated", msg.Key)
+		}
+		seen[msg.Key] = true
+	}
+	if first[3].Key != "1704207845.000100" {
+		t.Errorf("existing key = %q, want it kept", first[3].Key)
+	}
+
+	second := messages()
+	assignKeys(second)
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("keys differ between runs: %+v and %+v", first, second)
+	}
+}
@@ -0,0 +1,180 @@
+package importer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ircNick matches an IRC nickname, which starts with a letter or one of []\`_^{|}
+const ircNick = "[A-Za-z\\[\\]\\\\`_^{|}][^\\s<>]*"
+
+var (
+	// ircDateTime matches a line starting with a full date and time, as
+	// written by ZNC, WeeChat and many bouncers
+	ircDateTime = regexp.MustCompile(`^\[?(\d{4}-\d{2}-\d{2})[ T](\d{2}:\d{2}(?::\d{2})?)(?:\.\d+)?(Z|[+-]\d{2}:?\d{2})?\]?\s+(.*)$`)
+	// ircTime matches a line starting with a time of day, as written by irssi
+	ircTime = regexp.MustCompile(`^\[?(\d{2}:\d{2}(?::\d{2})?)\]?\s+(.*)$`)
+	// ircSay matches "<nick> text", allowing a channel mode prefix on the nick
+	ircSay = regexp.MustCompile(`^<[ @+%&~]?(` + ircNick + `)>\s?(.*)$`)
+	// ircTabSay matches WeeChat's "nick<tab>text"
+	ircTabSay = regexp.MustCompile(`^[@+%&~]?(` + ircNick + `)\t(.*)$`)
+	// ircAction matches "* nick text", a /me action
+	ircAction = regexp.MustCompile(`^\*\s+(` + ircNick + `)\s+(.*)$`)
+	// ircDayMarker matches irssi's log opened and day changed lines
+	ircDayMarker = regexp.MustCompile(`^--- (?:Day changed|Log opened) (.*)$`)
+	// ircFileDate matches a date in a log file's name
+	ircFileDate = regexp.MustCompile(`(\d{4})-?(\d{2})-?(\d{2})`)
+)
+
+// ParseIRC parses a plain-text IRC log. Lines are timestamped with a full
+// date or a time of day, which is taken to be on day (in UTC) until a
+// "Day changed" or "Log opened" line, or on the next day if the time goes
+// backwards. Messages and /me actions are kept and other lines, such as
+// joins and quits, are skipped.
+func ParseIRC(name string, r io.Reader, day time.Time) (Channel, error) {
+	channel := Channel{Name: name}
+	var last time.Time
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if m := ircDayMarker.FindStringSubmatch(line); m != nil {
+			if parsed, ok := parseIRCDay(m[1]); ok {
+				day = parsed
+			}
+			continue
+		}
+
+		var timestamp time.Time
+		var rest string
+		if m := ircDateTime.FindStringSubmatch(line); m != nil {
+			t, err := time.Parse("2006-01-02 "+clockLayout(m[2]), m[1]+" "+m[2])
+			if err != nil {
+				channel.Skipped++
+				continue
+			}
+			timestamp, rest = t.Add(-zoneOffset(m[3])), m[4]
+			day = startOfDay(timestamp)
+		} else if m := ircTime.FindStringSubmatch(line); m != nil {
+			if day.IsZero() {
+				return channel, fmt.Errorf("line %d has no date; name the file by date or give one", lineNumber)
+			}
+			clock, err := time.Parse(clockLayout(m[1]), m[1])
+			if err != nil {
+				channel.Skipped++
+				continue
+			}
+			timestamp = day.Add(clock.Sub(startOfDay(clock)))
+			if timestamp.Before(last) {
+				day = day.AddDate(0, 0, 1)
+				timestamp = timestamp.AddDate(0, 0, 1)
+			}
+			rest = m[2]
+		} else {
+			channel.Skipped++
+			continue
+		}
+
+		author, content, ok := parseIRCText(rest)
+		if !ok {
+			channel.Skipped++
+			continue
+		}
+		channel.Messages = append(channel.Messages, Message{Author: author, Content: content, Timestamp: timestamp})
+		last = timestamp
+	}
+	if err := scanner.Err(); err != nil {
+		return channel, fmt.Errorf("error reading IRC log: %w", err)
+	}
+
+	return channel, nil
+}
+
+// parseIRCText gets the author and text of a message or /me action,
+// reporting false for other lines
+func parseIRCText(rest string) (string, string, bool) {
+	if m := ircSay.FindStringSubmatch(rest); m != nil {
+		content := strings.TrimSpace(m[2])
+		return m[1], content, content != ""
+	}
+	if m := ircTabSay.FindStringSubmatch(rest); m != nil {
+		content := strings.TrimSpace(m[2])
+		return m[1], content, content != ""
+	}
+	if m := ircAction.FindStringSubmatch(rest); m != nil {
+		content := strings.TrimSpace(m[2])
+		return m[1], "_" + content + "_", content != ""
+	}
+	return "", "", false
+}
+
+// parseIRCDay parses the date of an irssi day marker such as
+// "Tue Jan 02 2024" or "Tue Jan 02 15:04:05 2024"
+func parseIRCDay(s string) (time.Time, bool) {
+	for _, layout := range []string{"Mon Jan 02 2006", "Mon Jan 02 15:04:05 2006"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return startOfDay(t), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// IRCFileInfo derives a lobby name and date from an IRC log's file name,
+// such as "#golang.2024-01-02.log". Either is empty or zero if the name has
+// none.
+func IRCFileInfo(filename string) (string, time.Time) {
+	name := filepath.Base(filename)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+
+	var day time.Time
+	if loc := ircFileDate.FindStringSubmatchIndex(name); loc != nil {
+		parsed, err := time.Parse("20060102", name[loc[2]:loc[3]]+name[loc[4]:loc[5]]+name[loc[6]:loc[7]])
+		if err == nil {
+			day = parsed
+			name = name[:loc[0]] + name[loc[1]:]
+		}
+	}
+
+	name = strings.Trim(name, "#._- ")
+	return name, day
+}
+
+// clockLayout gets the layout of a time of day with or without seconds
+func clockLayout(clock string) string {
+	if strings.Count(clock, ":") == 2 {
+		return "15:04:05"
+	}
+	return "15:04"
+}
+
+// zoneOffset parses a UTC offset such as "+02:00" or "-0500", which is zero
+// for "Z" or no offset
+func zoneOffset(zone string) time.Duration {
+	if len(zone) < 5 {
+		return 0
+	}
+	digits := strings.ReplaceAll(zone[1:], ":", "")
+	hours, _ := strconv.Atoi(digits[:2])
+	minutes, _ := strconv.Atoi(digits[2:])
+	offset := time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute
+	if zone[0] == '-' {
+		offset = -offset
+	}
+	return offset
+}
+
+// startOfDay truncates a time to midnight
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
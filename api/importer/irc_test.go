@@ -0,0 +1,125 @@
+package importer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseIRC(t *testing.T) {
+	day := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	at := func(d, h, m, s int) time.Time {
+		return time.Date(2024, 1, d, h, m, s, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name        string
+		log         string
+		day         time.Time
+		want        []Message
+		wantSkipped int
+	}{
+		{
+			name: "irssi",
+			log:  "15:04 <alice> hello\n15:05 <@bob> hi there\n15:06 * alice waves",
+			day:  day,
+			want: []Message{
+				{Author: "alice", Content: "hello", Timestamp: at(2, 15, 4, 0)},
+				{Author: "bob", Content: "hi there", Timestamp: at(2, 15, 5, 0)},
+				{Author: "alice", Content: "_waves_", Timestamp: at(2, 15, 6, 0)},
+			},
+		},
+		{
+			name: "bracketed times with seconds",
+			log:  "[15:04:05] <alice> hello",
+			day:  day,
+			want: []Message{{Author: "alice", Content: "hello", Timestamp: at(2, 15, 4, 5)}},
+		},
+		{
+			name: "full dates with zones",
+			log:  "[2024-01-03 10:00:00] <alice> utc\n2024-01-03T10:00:00+02:00 <bob> offset",
+			want: []Message{
+				{Author: "alice", Content: "utc", Timestamp: at(3, 10, 0, 0)},
+				{Author: "bob", Content: "offset", Timestamp: at(3, 8, 0, 0)},
+			},
+		},
+		{
+			name: "WeeChat",
+			log:  "2024-01-03 10:00:00\t@alice\thello\n2024-01-03 10:00:01\t-->\tbob has joined",
+			want: []Message{{Author: "alice", Content: "hello", Timestamp: at(3, 10, 0, 0)}},
+			// The join's "-->" is not a nick
+			wantSkipped: 1,
+		},
+		{
+			name: "day markers",
+			log:  "--- Log opened Tue Jan 02 23:00:00 2024\n23:59 <alice> late\n--- Day changed Wed Jan 03 2024\n00:01 <bob> early",
+			want: []Message{
+				{Author: "alice", Content: "late", Timestamp: at(2, 23, 59, 0)},
+				{Author: "bob", Content: "early", Timestamp: at(3, 0, 1, 0)},
+			},
+		},
+		{
+			name: "time going backwards starts the next day",
+			log:  "23:59 <alice> late\n00:01 <bob> early",
+			day:  day,
+			want: []Message{
+				{Author: "alice", Content: "late", Timestamp: at(2, 23, 59, 0)},
+				{Author: "bob", Content: "early", Timestamp: at(3, 0, 1, 0)},
+			},
+		},
+		{
+			name: "skips events and empty messages",
+			log:  "15:04 -!- bob has joined #golang\n15:05 <alice>   \nnot a log line\n\n15:06 <alice> hi",
+			day:  day,
+			want: []Message{
+				{Author: "alice", Content: "hi", Timestamp: at(2, 15, 6, 0)},
+			},
+			wantSkipped: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			channel, err := ParseIRC("golang", strings.NewReader(tt.log), tt.day)
+			if err != nil {
+				t.Fatalf("ParseIRC() error = %v", err)
+			}
+			if !reflect.DeepEqual(channel.Messages, tt.want) {
+				t.Errorf("messages = %+v, want %+v", channel.Messages, tt.want)
+			}
+			if channel.Skipped != tt.wantSkipped {
+				t.Errorf("skipped = %d, want %d", channel.Skipped, tt.wantSkipped)
+			}
+		})
+	}
+}
+
+func TestParseIRCNeedsDate(t *testing.T) {
+	if _, err := ParseIRC("golang", strings.NewReader("15:04 <alice> hello"), time.Time{}); err == nil {
+		t.Error("ParseIRC() without a date succeeded, want an error")
+	}
+}
+
+func TestIRCFileInfo(t *testing.T) {
+	tests := []struct {
+		filename string
+		wantName string
+		wantDay  time.Time
+	}{
+		{"logs/#golang.2024-01-02.log", "golang", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"golang_20240102.txt", "golang", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"#golang.log", "golang", time.Time{}},
+		{"2024-01-02.log", "", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"golang.2024-13-40.log", "golang.2024-13-40", time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			name, day := IRCFileInfo(tt.filename)
+			if name != tt.wantName || !day.Equal(tt.wantDay) {
+				t.Errorf("IRCFileInfo(%q) = %q, %v, want %q, %v", tt.filename, name, day, tt.wantName, tt.wantDay)
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package importer
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/galexander77/chat-app/api/models"
+)
+
+// slackDayFile matches the per-day message files in a Slack export's channel folders
+var slackDayFile = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}\.json$`)
+
+// slackReference matches Slack's <...> mention, channel and link markup
+var slackReference = regexp.MustCompile(`<([^<>]+)>`)
+
+// slackUser is an entry of a Slack export's users.json
+type slackUser struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// slackChannel is an entry of a Slack export's channels.json, listing
+// public channels, or groups.json, listing private channels. Members are
+// user IDs.
+type slackChannel struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+}
+
+// slackMessage is a message in a Slack export's per-day files
+type slackMessage struct {
+	Type     string `json:"type"`
+	Subtype  string `json:"subtype"`
+	User     string `json:"user"`
+	Username string `json:"username"`
+	Text     string `json:"text"`
+	TS       string `json:"ts"`
+	ThreadTS string `json:"thread_ts"`
+}
+
+// ParseSlack parses the channels of a Slack export zip. Public channels,
+// listed in channels.json, and private channels, listed in groups.json with
+// their members, are parsed; direct messages and group direct messages are
+// skipped. Authors and members are named by their Slack username and thread
+// replies keep their threads. Joins, topic changes and other events are
+// skipped, as are files, which are not imported.
+func ParseSlack(filename string) ([]Channel, error) {
+	archive, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error opening Slack export: %w", err)
+	}
+	defer archive.Close()
+
+	users := make(map[string]string)
+	var public, private []slackChannel
+	days := make(map[string][]*zip.File)
+	for _, file := range archive.File {
+		switch name := path.Base(file.Name); {
+		case name == "users.json":
+			var list []slackUser
+			if err := readJSON(file, &list); err != nil {
+				return nil, err
+			}
+			for _, user := range list {
+				users[user.ID] = user.Name
+			}
+		case name == "channels.json":
+			if err := readJSON(file, &public); err != nil {
+				return nil, err
+			}
+		case name == "groups.json":
+			if err := readJSON(file, &private); err != nil {
+				return nil, err
+			}
+		case slackDayFile.MatchString(name):
+			channel := path.Base(path.Dir(file.Name))
+			days[channel] = append(days[channel], file)
+		}
+	}
+
+	// Channel folders are named after the channel. Folders of direct
+	// messages are not listed, so they are never read.
+	channelNames := make(map[string]string)
+	listed := make(map[string]Channel)
+	for visibility, list := range map[string][]slackChannel{models.VisibilityPublic: public, models.VisibilityPrivate: private} {
+		for _, sc := range list {
+			channelNames[sc.ID] = sc.Name
+			channel := Channel{Name: sc.Name, Visibility: visibility}
+			if visibility == models.VisibilityPrivate {
+				for _, member := range sc.Members {
+					if name := users[member]; name != "" {
+						channel.Members = append(channel.Members, name)
+					}
+				}
+			}
+			listed[sc.Name] = channel
+		}
+	}
+
+	var channels []Channel
+	for name, files := range days {
+		channel, ok := listed[name]
+		if !ok {
+			continue
+		}
+
+		// Day files are named by date, so sorting them sorts the history
+		sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+		for _, file := range files {
+			var messages []slackMessage
+			if err := readJSON(file, &messages); err != nil {
+				return nil, err
+			}
+			for _, msg := range messages {
+				parsed, ok := parseSlackMessage(msg, users, channelNames)
+				if !ok {
+					channel.Skipped++
+					continue
+				}
+				channel.Messages = append(channel.Messages, parsed)
+			}
+		}
+		sort.SliceStable(channel.Messages, func(i, j int) bool {
+			return channel.Messages[i].Timestamp.Before(channel.Messages[j].Timestamp)
+		})
+		channels = append(channels, channel)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i].Name < channels[j].Name })
+
+	return channels, nil
+}
+
+// parseSlackMessage converts a Slack message, reporting false if it is an
+// event or has no text
+func parseSlackMessage(msg slackMessage, users, channelNames map[string]string) (Message, bool) {
+	if msg.Type != "message" {
+		return Message{}, false
+	}
+	switch msg.Subtype {
+	case "", "thread_broadcast", "me_message", "bot_message", "file_share":
+	default:
+		return Message{}, false
+	}
+
+	timestamp, err := parseSlackTS(msg.TS)
+	if err != nil {
+		return Message{}, false
+	}
+
+	author := users[msg.User]
+	switch {
+	case author != "":
+	case msg.Username != "":
+		author = msg.Username
+	case msg.User != "":
+		author = msg.User
+	default:
+		return Message{}, false
+	}
+
+	content := strings.TrimSpace(slackText(msg.Text, users, channelNames))
+	if content == "" {
+		return Message{}, false
+	}
+	if msg.Subtype == "me_message" {
+		content = "_" + content + "_"
+	}
+
+	parsed := Message{Key: msg.TS, Author: author, Content: content, Timestamp: timestamp}
+	if msg.ThreadTS != "" && msg.ThreadTS != msg.TS {
+		parsed.ParentKey = msg.ThreadTS
+	}
+	return parsed, true
+}
+
+// parseSlackTS parses a Slack message timestamp, Unix seconds with a
+// fractional part
+func parseSlackTS(ts string) (time.Time, error) {
+	seconds, fraction, _ := strings.Cut(ts, ".")
+	sec, err := strconv.ParseInt(seconds, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var nsec int64
+	if fraction != "" {
+		fraction = (fraction + "000000000")[:9]
+		if nsec, err = strconv.ParseInt(fraction, 10, 64); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return time.Unix(sec, nsec).UTC(), nil
+}
+
+// slackText converts Slack's message markup to plain text and Markdown:
+// user and channel references become @username and #channel, and links
+// become Markdown links
+func slackText(text string, users, channelNames map[string]string) string {
+	text = slackReference.ReplaceAllStringFunc(text, func(ref string) string {
+		target, label, _ := strings.Cut(ref[1:len(ref)-1], "|")
+		switch {
+		case strings.HasPrefix(target, "@"):
+			if name := users[target[1:]]; name != "" {
+				return "@" + name
+			}
+			if label != "" {
+				return "@" + strings.TrimPrefix(label, "@")
+			}
+			return "@" + target[1:]
+		case strings.HasPrefix(target, "#"):
+			if name := channelNames[target[1:]]; name != "" {
+				return "#" + name
+			}
+			if label != "" {
+				return "#" + label
+			}
+			return target
+		case strings.HasPrefix(target, "!"):
+			// Special mentions such as <!here> and user groups
+			if label != "" {
+				return label
+			}
+			name, _, _ := strings.Cut(target[1:], "^")
+			return "@" + name
+		case label != "" && label != target:
+			return "[" + label + "](" + target + ")"
+		default:
+			return target
+		}
+	})
+
+	// Slack escapes only these characters
+	return strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&").Replace(text)
+}
+
+// readJSON decodes a JSON file from a zip archive
+func readJSON(file *zip.File, v interface{}) error {
+	r, err := file.Open()
+	if err != nil {
+		return fmt.Errorf("error opening %s: %w", file.Name, err)
+	}
+	defer r.Close()
+
+	if err := json.NewDecoder(r).Decode(v); err != nil {
+		return fmt.Errorf("error reading %s: %w", file.Name, err)
+	}
+	return nil
+}
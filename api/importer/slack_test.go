@@ -0,0 +1,197 @@
+package importer
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSlackTS(t *testing.T) {
+	tests := []struct {
+		ts      string
+		want    time.Time
+		wantErr bool
+	}{
+		{"1704207845.000200", time.Date(2024, 1, 2, 15, 4, 5, 200000, time.UTC), false},
+		{"1704207845", time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC), false},
+		{"1704207845.5", time.Date(2024, 1, 2, 15, 4, 5, 500000000, time.UTC), false},
+		{"", time.Time{}, true},
+		{"abc.123", time.Time{}, true},
+		{"1704207845.x", time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ts, func(t *testing.T) {
+			got, err := parseSlackTS(tt.ts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSlackTS(%q) error = %v, want error %v", tt.ts, err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("parseSlackTS(%q) = %v, want %v", tt.ts, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSlackText(t *testing.T) {
+	users := map[string]string{"U1": "alice"}
+	channelNames := map[string]string{"C1": "general"}
+
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"plain", "hello", "hello"},
+		{"known user", "hi <@U1>", "hi @alice"},
+		{"unknown user with label", "hi <@U9|bob>", "hi @bob"},
+		{"unknown user", "hi <@U9>", "hi @U9"},
+		{"known channel", "see <#C1>", "see #general"},
+		{"unknown channel with label", "see <#C9|random>", "see #random"},
+		{"special mention", "<!here> look", "@here look"},
+		{"special mention with label", "<!subteam^S1|@team> look", "@team look"},
+		{"link", "<https://example.com>", "https://example.com"},
+		{"labelled link", "<https://example.com|Example>", "[Example](https://example.com)"},
+		{"escapes", "a &lt;b&gt; &amp;amp;", "a <b> &amp;"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := slackText(tt.text, users, channelNames); got != tt.want {
+				t.Errorf("slackText(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSlackMessage(t *testing.T) {
+	users := map[string]string{"U1": "alice"}
+	at := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		msg    slackMessage
+		want   Message
+		wantOK bool
+	}{
+		{"message", slackMessage{Type: "message", User: "U1", Text: "hi", TS: "1704207845"},
+			Message{Key: "1704207845", Author: "alice", Content: "hi", Timestamp: at}, true},
+		{"thread reply", slackMessage{Type: "message", User: "U1", Text: "hi", TS: "1704207845", ThreadTS: "1704207800"},
+			Message{Key: "1704207845", ParentKey: "1704207800", Author: "alice", Content: "hi", Timestamp: at}, true},
+		{"thread parent", slackMessage{Type: "message", User: "U1", Text: "hi", TS: "1704207845", ThreadTS: "1704207845"},
+			Message{Key: "1704207845", Author: "alice", Content: "hi", Timestamp: at}, true},
+		{"me message", slackMessage{Type: "message", Subtype: "me_message", User: "U1", Text: "waves", TS: "1704207845"},
+			Message{Key: "1704207845", Author: "alice", Content: "_waves_", Timestamp: at}, true},
+		{"bot message", slackMessage{Type: "message", Subtype: "bot_message", Username: "deploybot", Text: "done", TS: "1704207845"},
+			Message{Key: "1704207845", Author: "deploybot", Content: "done", Timestamp: at}, true},
+		{"unknown user", slackMessage{Type: "message", User: "U9", Text: "hi", TS: "1704207845"},
+			Message{Key: "1704207845", Author: "U9", Content: "hi", Timestamp: at}, true},
+		{"join", slackMessage{Type: "message", Subtype: "channel_join", User: "U1", Text: "joined", TS: "1704207845"}, Message{}, false},
+		{"not a message", slackMessage{Type: "file", User: "U1", Text: "hi", TS: "1704207845"}, Message{}, false},
+		{"no text", slackMessage{Type: "message", User: "U1", Text: "  ", TS: "1704207845"}, Message{}, false},
+		{"no author", slackMessage{Type: "message", Text: "hi", TS: "1704207845"}, Message{}, false},
+		{"bad timestamp", slackMessage{Type: "message", User: "U1", Text: "hi", TS: "soon"}, Message{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseSlackMessage(tt.msg, users, nil)
+			if ok != tt.wantOK {
+				t.Fatalf("parseSlackMessage() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSlackMessage() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSlack(t *testing.T) {
+	filename := writeZip(t, map[string]string{
+		"users.json":    `[{"id": "U1", "name": "alice"}, {"id": "U2", "name": "bob"}]`,
+		"channels.json": `[{"id": "C1", "name": "general"}]`,
+		"groups.json":   `[{"id": "G1", "name": "secret", "members": ["U1", "U2", "U9"]}]`,
+		"secret/2024-01-02.json": `[
+			{"type": "message", "user": "U1", "text": "psst", "ts": "1704207845.000100"}
+		]`,
+		"D1/2024-01-02.json": `[
+			{"type": "message", "user": "U1", "text": "direct", "ts": "1704207845.000100"}
+		]`,
+		"mpdm-alice--bob-1/2024-01-02.json": `[
+			{"type": "message", "user": "U1", "text": "group direct", "ts": "1704207845.000100"}
+		]`,
+		"general/2024-01-03.json": `[
+			{"type": "message", "user": "U2", "text": "later", "ts": "1704300000.000100"}
+		]`,
+		"general/2024-01-02.json": `[
+			{"type": "message", "subtype": "channel_join", "user": "U2", "text": "joined", "ts": "1704207800.000000"},
+			{"type": "message", "user": "U2", "text": "reply", "ts": "1704207900.000100", "thread_ts": "1704207845.000100"},
+			{"type": "message", "user": "U1", "text": "hello <#C1>", "ts": "1704207845.000100"}
+		]`,
+	})
+
+	channels, err := ParseSlack(filename)
+	if err != nil {
+		t.Fatalf("ParseSlack() error = %v", err)
+	}
+	if len(channels) != 2 {
+		t.Fatalf("ParseSlack() returned %d channels, want 2", len(channels))
+	}
+
+	channel := channels[0]
+	if channel.Name != "general" || channel.Visibility != "public" || channel.Skipped != 1 {
+		t.Errorf("channel = %s %q with %d skipped, want public general with 1 skipped",
+			channel.Visibility, channel.Name, channel.Skipped)
+	}
+	var got []string
+	for _, msg := range channel.Messages {
+		got = append(got, msg.Author+": "+msg.Content)
+	}
+	want := []string{"alice: hello #general", "bob: reply", "bob: later"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("messages = %q, want %q", got, want)
+	}
+	if channel.Messages[1].ParentKey != channel.Messages[0].Key {
+		t.Errorf("reply parent = %q, want %q", channel.Messages[1].ParentKey, channel.Messages[0].Key)
+	}
+
+	private := channels[1]
+	if private.Name != "secret" || private.Visibility != "private" || len(private.Messages) != 1 {
+		t.Errorf("channel = %s %q with %d messages, want private secret with 1 message",
+			private.Visibility, private.Name, len(private.Messages))
+	}
+	if want := []string{"alice", "bob"}; !reflect.DeepEqual(private.Members, want) {
+		t.Errorf("members = %q, want %q", private.Members, want)
+	}
+}
+
+// writeZip writes files to a zip archive in a temporary directory,
+// returning its name
+func writeZip(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	filename := filepath.Join(t.TempDir(), "export.zip")
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w := zip.NewWriter(f)
+	for name, content := range files {
+		fw, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
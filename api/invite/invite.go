@@ -0,0 +1,66 @@
+package invite
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidToken is returned when an invite token is malformed or its signature does not match
+var ErrInvalidToken = errors.New("invalid invite token")
+
+// Signer signs and verifies invite tokens. A token names the invite and
+// lobby it grants access to; expiry and use limits are enforced against
+// the stored invite.
+type Signer struct {
+	secret []byte
+}
+
+// NewSigner creates a new Signer
+func NewSigner(secret []byte) *Signer {
+	return &Signer{secret: secret}
+}
+
+// Sign creates the token for an invite
+func (s *Signer) Sign(inviteID, lobbyID int) string {
+	payload := fmt.Sprintf("%d.%d", inviteID, lobbyID)
+	return payload + "." + s.signature(payload)
+}
+
+// Verify checks a token's signature and returns the invite and lobby IDs it names
+func (s *Signer) Verify(token string) (inviteID, lobbyID int, err error) {
+	i := strings.LastIndex(token, ".")
+	if i < 0 {
+		return 0, 0, ErrInvalidToken
+	}
+	payload, signature := token[:i], token[i+1:]
+	if !hmac.Equal([]byte(signature), []byte(s.signature(payload))) {
+		return 0, 0, ErrInvalidToken
+	}
+
+	parts := strings.Split(payload, ".")
+	if len(parts) != 2 {
+		return 0, 0, ErrInvalidToken
+	}
+	inviteID, err = strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, ErrInvalidToken
+	}
+	lobbyID, err = strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, ErrInvalidToken
+	}
+
+	return inviteID, lobbyID, nil
+}
+
+// signature computes the HMAC-SHA256 signature of a token payload
+func (s *Signer) signature(payload string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
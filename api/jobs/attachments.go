@@ -0,0 +1,90 @@
+package jobs
+
+import (
+	"log"
+	"time"
+
+	"github.com/galexander77/chat-app/api/db"
+	"github.com/galexander77/chat-app/api/storage"
+)
+
+// attachmentBatchSize is how many unsent uploads or deleted files are
+// removed at a time
+const attachmentBatchSize = 500
+
+// StartAttachmentCleanup periodically deletes uploads that were never sent
+// with a message within unsentTTL, then removes the stored files of every
+// deleted attachment. Files that cannot be removed stay queued and are
+// retried on the next run.
+func StartAttachmentCleanup(attachmentRepo *db.AttachmentRepository, store storage.Storage, unsentTTL, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if unsentTTL > 0 {
+				deleteUnsent(attachmentRepo, unsentTTL)
+			}
+			removeDeletedFiles(attachmentRepo, store)
+		}
+	}()
+}
+
+// deleteUnsent deletes every unsent upload older than the TTL in batches
+func deleteUnsent(attachmentRepo *db.AttachmentRepository, ttl time.Duration) {
+	var deleted int64
+	for {
+		batch, err := attachmentRepo.DeleteUnsentAttachments(ttl, attachmentBatchSize)
+		if err != nil {
+			log.Println("Error deleting unsent attachments:", err)
+			break
+		}
+		deleted += batch
+		if batch < attachmentBatchSize {
+			break
+		}
+	}
+
+	if deleted > 0 {
+		log.Printf("Deleted %d unsent attachments", deleted)
+	}
+}
+
+// removeDeletedFiles removes the stored files of deleted attachments in
+// batches, stopping after a batch in which any file could not be removed
+func removeDeletedFiles(attachmentRepo *db.AttachmentRepository, store storage.Storage) {
+	var removed int
+	for {
+		keys, err := attachmentRepo.GetDeletedFiles(attachmentBatchSize)
+		if err != nil {
+			log.Println("Error fetching deleted attachment files:", err)
+			break
+		}
+
+		failed := false
+		for _, key := range keys {
+			if err := store.Delete(key); err != nil {
+				log.Println("Error removing attachment file:", err)
+				failed = true
+				continue
+			}
+			if err := attachmentRepo.ForgetDeletedFile(key); err != nil {
+				log.Println("Error removing attachment file:", err)
+				failed = true
+				continue
+			}
+			removed++
+		}
+		if failed || len(keys) < attachmentBatchSize {
+			break
+		}
+	}
+
+	if removed > 0 {
+		log.Printf("Removed %d attachment files", removed)
+	}
+}
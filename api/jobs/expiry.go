@@ -0,0 +1,54 @@
+package jobs
+
+import (
+	"log"
+	"time"
+
+	"github.com/galexander77/chat-app/api/db"
+	"github.com/galexander77/chat-app/api/websocket"
+)
+
+// expiryBatchSize is how many expired messages are deleted at a time
+const expiryBatchSize = 500
+
+// StartMessageExpiry periodically deletes ephemeral messages that have
+// expired and notifies their lobbies. Expired messages are never returned
+// by message queries, so they stay hidden between runs and while the server
+// is down; messages that expired while it was down are deleted on start.
+func StartMessageExpiry(messageRepo *db.MessageRepository, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		deleteExpired(messageRepo)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			deleteExpired(messageRepo)
+		}
+	}()
+}
+
+// deleteExpired deletes every expired message in batches
+func deleteExpired(messageRepo *db.MessageRepository) {
+	var deleted int
+	for {
+		expired, err := messageRepo.DeleteExpiredMessages(expiryBatchSize)
+		if err != nil {
+			log.Println("Error deleting expired messages:", err)
+			break
+		}
+		websocket.NotifyExpired(expired)
+		deleted += len(expired)
+		if len(expired) < expiryBatchSize {
+			break
+		}
+	}
+
+	if deleted > 0 {
+		log.Printf("Deleted %d expired messages", deleted)
+	}
+}
@@ -0,0 +1,49 @@
+package jobs
+
+import (
+	"log"
+	"time"
+
+	"github.com/galexander77/chat-app/api/db"
+)
+
+// purgeBatchSize is how many deleted messages are purged at a time
+const purgeBatchSize = 500
+
+// StartDeletedMessagePurge periodically hard deletes messages that were
+// soft deleted longer ago than the retention period. Messages are removed
+// in batches, each in its own short transaction.
+func StartDeletedMessagePurge(messageRepo *db.MessageRepository, retention, interval time.Duration) {
+	if retention <= 0 || interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			purgeDeleted(messageRepo, retention)
+		}
+	}()
+}
+
+// purgeDeleted purges every deleted message past the retention period in batches
+func purgeDeleted(messageRepo *db.MessageRepository, retention time.Duration) {
+	var purged int64
+	for {
+		batch, err := messageRepo.PurgeDeletedMessages(retention, purgeBatchSize)
+		if err != nil {
+			log.Println("Error purging deleted messages:", err)
+			break
+		}
+		purged += batch
+		if batch < purgeBatchSize {
+			break
+		}
+	}
+
+	if purged > 0 {
+		log.Printf("Purged %d deleted messages", purged)
+	}
+}
@@ -0,0 +1,70 @@
+package jobs
+
+import (
+	"log"
+	"time"
+
+	"github.com/galexander77/chat-app/api/db"
+	"github.com/galexander77/chat-app/api/storage"
+	"github.com/galexander77/chat-app/api/websocket"
+)
+
+// StartRetentionPurge periodically deletes or archives the messages that
+// have expired under their lobby's retention policy, notifies their lobbies
+// and removes the stored files of their attachments. Messages are removed
+// in batches of batchSize threads, each in its own short transaction, so
+// the purge does not hold up messages being sent.
+func StartRetentionPurge(retentionRepo *db.RetentionRepository, attachmentRepo *db.AttachmentRepository, store storage.Storage, interval time.Duration, batchSize int) {
+	if interval <= 0 || batchSize <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if purgeExpired(retentionRepo, batchSize) > 0 {
+				removeDeletedFiles(attachmentRepo, store)
+			}
+		}
+	}()
+}
+
+// purgeExpired removes the expired messages of every lobby with a policy
+// that expires them, returning how many messages were removed
+func purgeExpired(retentionRepo *db.RetentionRepository, batchSize int) int64 {
+	policies, err := retentionRepo.GetExpiringPolicies()
+	if err != nil {
+		log.Println("Error fetching retention policies:", err)
+		return 0
+	}
+
+	var total int64
+	for _, policy := range policies {
+		var purged int64
+		for {
+			threads, messages, err := retentionRepo.PurgeExpired(policy, batchSize)
+			if err != nil {
+				log.Printf("Error purging expired messages in lobby %d: %v", *policy.LobbyID, err)
+				break
+			}
+			websocket.NotifyExpired(threads)
+			purged += messages
+			if len(threads) < batchSize {
+				break
+			}
+		}
+		total += purged
+
+		if purged > 0 {
+			action := "Deleted"
+			if policy.Archive {
+				action = "Archived"
+			}
+			log.Printf("%s %d expired messages in lobby %d", action, purged, *policy.LobbyID)
+		}
+	}
+
+	return total
+}
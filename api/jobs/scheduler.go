@@ -0,0 +1,107 @@
+package jobs
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/galexander77/chat-app/api/db"
+	"github.com/galexander77/chat-app/api/models"
+	"github.com/galexander77/chat-app/api/websocket"
+)
+
+// scheduledBatchSize is how many due items the scheduler claims at a time
+const scheduledBatchSize = 100
+
+// StartScheduler periodically sends the scheduled messages and reminders
+// that are due. They are stored in the database, so items that came due
+// while the server was down are sent when it starts. It must be started
+// after the WebSocket routes are registered, as messages are sent the same
+// way as over a connection.
+func StartScheduler(database *sql.DB, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	scheduledRepo := db.NewScheduledRepository(database)
+	repos := &websocket.Repositories{
+		Users:       db.NewUserRepository(database),
+		Lobbies:     db.NewLobbyRepository(database),
+		Messages:    db.NewMessageRepository(database),
+		Reactions:   db.NewReactionRepository(database),
+		Mentions:    db.NewMentionRepository(database),
+		Moderation:  db.NewModerationRepository(database),
+		Reports:     db.NewReportRepository(database),
+		Blocks:      db.NewBlockRepository(database),
+		Attachments: db.NewAttachmentRepository(database),
+	}
+
+	// Items being sent when the server last stopped are sent again
+	if released, err := scheduledRepo.ReleaseClaimed(); err != nil {
+		log.Println("Error releasing scheduled items:", err)
+	} else if released > 0 {
+		log.Printf("Retrying %d interrupted scheduled items", released)
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			sendDue(repos, scheduledRepo)
+			websocket.ForgetIdleLimits()
+		}
+	}()
+}
+
+// sendDue sends every scheduled item that is due
+func sendDue(repos *websocket.Repositories, scheduledRepo *db.ScheduledRepository) {
+	for {
+		items, err := scheduledRepo.ClaimDue(scheduledBatchSize)
+		if err != nil {
+			log.Println("Error fetching due scheduled items:", err)
+			return
+		}
+
+		for _, item := range items {
+			sendScheduled(repos, scheduledRepo, item)
+		}
+		if len(items) < scheduledBatchSize {
+			return
+		}
+	}
+}
+
+// sendScheduled sends a scheduled message or reminder and records the
+// outcome. Messages the rate limit or slow mode holds back are postponed
+// until they may be sent; sent messages are recorded as they are saved.
+func sendScheduled(repos *websocket.Repositories, scheduledRepo *db.ScheduledRepository, item models.ScheduledMessage) {
+	if item.Kind == models.ScheduledKindMessage {
+		_, err := websocket.SendScheduled(repos, item)
+		var retry *websocket.RetryError
+		switch {
+		case errors.As(err, &retry):
+			if err := scheduledRepo.Postpone(item.ID, retry.RetryAt); err != nil {
+				log.Println("Error postponing scheduled item:", err)
+			}
+		case err != nil:
+			if err := scheduledRepo.MarkFailed(item.ID, err.Error()); err != nil {
+				log.Println("Error recording scheduled item failure:", err)
+			}
+			websocket.NotifyScheduledFailed(item, err.Error())
+		}
+		return
+	}
+
+	if err := websocket.SendReminder(repos, item); err != nil {
+		if err := scheduledRepo.MarkFailed(item.ID, err.Error()); err != nil {
+			log.Println("Error recording scheduled item failure:", err)
+		}
+		return
+	}
+
+	if err := scheduledRepo.MarkSent(item.ID); err != nil {
+		log.Println("Error recording scheduled item as sent:", err)
+	}
+}
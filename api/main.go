@@ -1,11 +1,18 @@
 package main
 
 import (
+	"crypto/rand"
 	"log"
+	"os"
 
 	"github.com/galexander77/chat-app/api/config"
 	"github.com/galexander77/chat-app/api/db"
+	"github.com/galexander77/chat-app/api/filter"
+	"github.com/galexander77/chat-app/api/importer"
+	"github.com/galexander77/chat-app/api/invite"
+	"github.com/galexander77/chat-app/api/jobs"
 	"github.com/galexander77/chat-app/api/routes"
+	"github.com/galexander77/chat-app/api/storage"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
@@ -33,6 +40,36 @@ func main() {
 		log.Fatal("Error creating tables:", err)
 	}
 
+	// Import chat history instead of serving when run as "import"
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		if err := importer.Run(database, os.Args[2:], cfg.WebSocket.MaxMessageLength, os.Stdout); err != nil {
+			log.Fatal("Error importing chat history:", err)
+		}
+		return
+	}
+
+	// Set up invite link signing
+	inviteSecret := []byte(cfg.Invites.Secret)
+	if len(inviteSecret) == 0 {
+		log.Println("WARNING: INVITE_SECRET is not set, invite links will stop working on restart")
+		inviteSecret = make([]byte, 32)
+		if _, err := rand.Read(inviteSecret); err != nil {
+			log.Fatal("Error generating invite secret:", err)
+		}
+	}
+	inviteSigner := invite.NewSigner(inviteSecret)
+
+	// Set up attachment storage
+	store, err := storage.New(cfg.Storage)
+	if err != nil {
+		log.Fatal("Error setting up storage:", err)
+	}
+
+	// Start background jobs
+	jobs.StartDeletedMessagePurge(db.NewMessageRepository(database), cfg.Messages.DeletedRetention, cfg.Messages.PurgeInterval)
+	jobs.StartRetentionPurge(db.NewRetentionRepository(database), db.NewAttachmentRepository(database), store, cfg.Messages.PurgeInterval, cfg.Messages.RetentionBatchSize)
+	jobs.StartAttachmentCleanup(db.NewAttachmentRepository(database), store, cfg.Attachments.UnsentTTL, cfg.Attachments.CleanupInterval)
+
 	// Initialize Fiber app
 	app := fiber.New(fiber.Config{
 		ErrorHandler: func(c *fiber.Ctx, err error) error {
@@ -56,8 +93,9 @@ func main() {
 	app.Use(logger.New())
 	app.Use(cors.New(cors.Config{
 		AllowOrigins:     "http://localhost:3000",
-		AllowMethods:     "GET,POST,PUT,DELETE",
+		AllowMethods:     "GET,POST,PUT,PATCH,DELETE",
 		AllowHeaders:     "Origin,Content-Type,Accept,Authorization",
+		ExposeHeaders:    "X-Next-Cursor",
 		AllowCredentials: true,
 	}))
 
@@ -72,7 +110,24 @@ func main() {
 	// Register routes
 	routes.RegisterAuthRoutes(app, database)
 	routes.RegisterLobbyRoutes(app, database)
-	routes.RegisterWebSocketRoutes(app, database)
+	routes.RegisterMessageRoutes(app, database)
+	routes.RegisterMeRoutes(app, database)
+	routes.RegisterDMRoutes(app, database)
+	routes.RegisterModerationRoutes(app, database)
+	routes.RegisterReportRoutes(app, database)
+	routes.RegisterSearchRoutes(app, database)
+	routes.RegisterExportRoutes(app, database)
+	routes.RegisterRetentionRoutes(app, database)
+	routes.RegisterScheduledRoutes(app, database, cfg.WebSocket.MaxMessageLength)
+	routes.RegisterAttachmentRoutes(app, database, store, cfg.Attachments)
+	routes.RegisterInviteRoutes(app, database, inviteSigner, cfg.Invites.BaseURL)
+	routes.RegisterWebSocketRoutes(app, database, cfg.WebSocket, filter.NewChain(cfg.Filters))
+
+	// Scheduled messages are sent like WebSocket messages, and expiring
+	// messages notify WebSocket clients, so start these jobs once the
+	// WebSocket routes are set up
+	jobs.StartScheduler(database, cfg.Messages.SchedulerInterval)
+	jobs.StartMessageExpiry(db.NewMessageRepository(database), cfg.Messages.ExpiryInterval)
 
 	// Start server
 	log.Printf("Server starting on port %s\n", cfg.Server.Port)
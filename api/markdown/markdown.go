@@ -0,0 +1,292 @@
+package markdown
+
+import (
+	"html"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Render converts the Markdown subset supported in chat messages to HTML:
+// **bold**, *italics*, `code`, fenced code blocks, [links](url), bare URLs
+// and > quotes. Everything else is plain text. All text is escaped and only
+// the tags produced here are emitted, so the result is safe to insert into
+// a page as is.
+func Render(src string) string {
+	var b strings.Builder
+	var pending []string
+	quoting := false
+
+	// flush writes the pending lines as a paragraph or quote
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		if quoting {
+			b.WriteString("<blockquote>")
+		} else {
+			b.WriteString("<p>")
+		}
+		for i, line := range pending {
+			if i > 0 {
+				b.WriteString("<br>")
+			}
+			renderInline(&b, line, true)
+		}
+		if quoting {
+			b.WriteString("</blockquote>")
+		} else {
+			b.WriteString("</p>")
+		}
+		pending = nil
+	}
+
+	lines := strings.Split(src, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		// Fenced code blocks run to the closing fence or the end of the message
+		if lang, ok := openFence(line); ok {
+			flush()
+			var code []string
+			for i++; i < len(lines) && strings.TrimSpace(lines[i]) != "```"; i++ {
+				code = append(code, lines[i])
+			}
+			b.WriteString("<pre><code")
+			if lang != "" {
+				b.WriteString(` class="language-` + lang + `"`)
+			}
+			b.WriteString(">" + html.EscapeString(strings.Join(code, "\n")) + "</code></pre>")
+			continue
+		}
+
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+
+		quote := strings.HasPrefix(line, ">")
+		if quote != quoting {
+			flush()
+			quoting = quote
+		}
+		if quote {
+			line = strings.TrimPrefix(strings.TrimPrefix(line, ">"), " ")
+		}
+		pending = append(pending, line)
+	}
+	flush()
+
+	return b.String()
+}
+
+// openFence reports whether a line opens a fenced code block, and its
+// language if it names a simple one
+func openFence(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "```") {
+		return "", false
+	}
+
+	lang := strings.TrimSpace(line[3:])
+	for _, r := range lang {
+		if !isWordRune(r) && r != '+' && r != '-' && r != '#' {
+			return "", true
+		}
+	}
+	return lang, true
+}
+
+// renderInline writes a line's inline formatting. Link text cannot contain
+// further links, so links is false while rendering it.
+func renderInline(b *strings.Builder, s string, links bool) {
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && isPunct(s[i+1]):
+			b.WriteString(html.EscapeString(s[i+1 : i+2]))
+			i += 2
+			continue
+
+		case c == '`':
+			if n := codeSpan(b, s[i:]); n > 0 {
+				i += n
+				continue
+			}
+
+		case c == '*' || c == '_':
+			if n := emphasis(b, s, i, links); n > 0 {
+				i += n
+				continue
+			}
+
+		case c == '[' && links:
+			if n := link(b, s[i:]); n > 0 {
+				i += n
+				continue
+			}
+
+		case (c == 'h' || c == 'H') && links && (i == 0 || !isWordByte(s[i-1])):
+			if n := autolink(b, s[i:]); n > 0 {
+				i += n
+				continue
+			}
+		}
+
+		_, size := utf8.DecodeRuneInString(s[i:])
+		b.WriteString(html.EscapeString(s[i : i+size]))
+		i += size
+	}
+}
+
+// codeSpan writes a code span starting at the beginning of s, returning
+// how much of s it used or 0 if the backticks are not closed
+func codeSpan(b *strings.Builder, s string) int {
+	ticks := len(s) - len(strings.TrimLeft(s, "`"))
+	fence := s[:ticks]
+	end := strings.Index(s[ticks:], fence)
+	if end <= 0 {
+		return 0
+	}
+
+	code := s[ticks : ticks+end]
+	if len(code) > 1 && code[0] == ' ' && code[len(code)-1] == ' ' {
+		code = code[1 : len(code)-1]
+	}
+	b.WriteString("<code>" + html.EscapeString(code) + "</code>")
+	return ticks + end + ticks
+}
+
+// emphasis writes bold (doubled delimiters) or italics starting at s[i],
+// returning how much of s it used or 0 if the delimiters do not match up.
+// Underscores inside words, as in snake_case, are left alone.
+func emphasis(b *strings.Builder, s string, i int, links bool) int {
+	c := s[i]
+	delim := string(c)
+	if strings.HasPrefix(s[i:], delim+delim) {
+		delim += delim
+	}
+	if c == '_' && i > 0 && isWordByte(s[i-1]) {
+		return 0
+	}
+
+	start := i + len(delim)
+	if start >= len(s) || s[start] == ' ' {
+		return 0
+	}
+
+	for from := start; from < len(s); {
+		end := strings.Index(s[from:], delim)
+		if end < 0 {
+			return 0
+		}
+		end += from
+		after := end + len(delim)
+
+		closes := end > start && s[end-1] != ' ' &&
+			(c != '_' || after >= len(s) || !isWordByte(s[after])) &&
+			// A single * or _ does not close on half of a double one
+			(len(delim) == 2 || after >= len(s) || s[after] != c)
+		if closes {
+			tag := "em"
+			if len(delim) == 2 {
+				tag = "strong"
+			}
+			b.WriteString("<" + tag + ">")
+			renderInline(b, s[start:end], links)
+			b.WriteString("</" + tag + ">")
+			return after - i
+		}
+		from = end + 1
+	}
+	return 0
+}
+
+// link writes a [text](url) link starting at the beginning of s, returning
+// how much of s it used or 0 if it is not a link to a safe URL
+func link(b *strings.Builder, s string) int {
+	textEnd := strings.Index(s, "](")
+	if textEnd <= 1 || strings.Contains(s[1:textEnd], "[") {
+		return 0
+	}
+	urlEnd := strings.IndexByte(s[textEnd+2:], ')')
+	if urlEnd < 0 {
+		return 0
+	}
+
+	href, ok := safeURL(strings.TrimSpace(s[textEnd+2 : textEnd+2+urlEnd]))
+	if !ok {
+		return 0
+	}
+	writeLink(b, href, func() { renderInline(b, s[1:textEnd], false) })
+	return textEnd + 2 + urlEnd + 1
+}
+
+// autolink writes a bare http or https URL at the beginning of s as a link,
+// returning how much of s it used or 0 if s does not start with one
+func autolink(b *strings.Builder, s string) int {
+	lower := strings.ToLower(s[:min(len(s), 8)])
+	if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
+		return 0
+	}
+
+	end := strings.IndexFunc(s, func(r rune) bool { return unicode.IsSpace(r) || r == '<' || r == '>' })
+	if end < 0 {
+		end = len(s)
+	}
+	// Trailing punctuation usually ends the sentence rather than the URL
+	raw := strings.TrimRight(s[:end], ".,;:!?'\")*_")
+
+	href, ok := safeURL(raw)
+	if !ok {
+		return 0
+	}
+	writeLink(b, href, func() { b.WriteString(html.EscapeString(raw)) })
+	return len(raw)
+}
+
+// writeLink writes an anchor opening in a new tab around the text written by text
+func writeLink(b *strings.Builder, href string, text func()) {
+	b.WriteString(`<a href="` + html.EscapeString(href) + `" rel="nofollow noopener noreferrer" target="_blank">`)
+	text()
+	b.WriteString("</a>")
+}
+
+// safeURL checks that a link target is an absolute http, https or mailto URL
+func safeURL(raw string) (string, bool) {
+	if raw == "" || strings.IndexFunc(raw, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0 {
+		return "", false
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", false
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		if u.Host == "" {
+			return "", false
+		}
+	case "mailto":
+	default:
+		return "", false
+	}
+	return raw, true
+}
+
+// isPunct reports whether a byte is ASCII punctuation, which a backslash escapes
+func isPunct(c byte) bool {
+	return c < utf8.RuneSelf && unicode.IsPunct(rune(c)) || strings.IndexByte("$+<=>^`|~", c) >= 0
+}
+
+// isWordByte reports whether a byte is part of a word. Bytes of multi-byte
+// characters count as word bytes.
+func isWordByte(c byte) bool {
+	return c >= utf8.RuneSelf || isWordRune(rune(c))
+}
+
+// isWordRune reports whether a rune is a letter, digit or underscore
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
@@ -0,0 +1,78 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+)
+
+// anchor is the opening tag written for a link to href
+func anchor(href string) string {
+	return `<a href="` + href + `" rel="nofollow noopener noreferrer" target="_blank">`
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"plain text", "hello", "<p>hello</p>"},
+		{"escapes HTML", `a <b> & "c"`, "<p>a &lt;b&gt; &amp; &#34;c&#34;</p>"},
+		{"emphasis", "**bold** and *it* and _it_", "<p><strong>bold</strong> and <em>it</em> and <em>it</em></p>"},
+		{"underscores inside words", "snake_case_name", "<p>snake_case_name</p>"},
+		{"unclosed emphasis", "**unclosed", "<p>**unclosed</p>"},
+		{"backslash escapes", `\*not\*`, "<p>*not*</p>"},
+		{"code span", "`<code>`", "<p><code>&lt;code&gt;</code></p>"},
+		{"code span with backtick", "``a ` b``", "<p><code>a ` b</code></p>"},
+		{"fenced code", "```go\nfmt.Println(\"<x>\")\n```", `<pre><code class="language-go">fmt.Println(&#34;&lt;x&gt;&#34;)</code></pre>`},
+		{"unclosed fence", "```\nx", "<pre><code>x</code></pre>"},
+		{"line breaks", "line1\nline2", "<p>line1<br>line2</p>"},
+		{"quotes", "> quoted\n> more\n\nafter", "<blockquote>quoted<br>more</blockquote><p>after</p>"},
+		{"link", "[text](https://example.com)", "<p>" + anchor("https://example.com") + "text</a></p>"},
+		{"formatted link text", "[**b**](https://e.com)", "<p>" + anchor("https://e.com") + "<strong>b</strong></a></p>"},
+		{"mailto link", "[x](mailto:a@b.com)", "<p>" + anchor("mailto:a@b.com") + "x</a></p>"},
+		{"link without host", "[x](https://)", "<p>[x](https://)</p>"},
+		{"bare URL", "https://example.com/path.", "<p>" + anchor("https://example.com/path") + "https://example.com/path</a>.</p>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Render(tt.src); got != tt.want {
+				t.Errorf("Render(%q) = %q, want %q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderXSS(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"raw tag", "<img src=x onerror=alert(1)>", "<p>&lt;img src=x onerror=alert(1)&gt;</p>"},
+		{"script tag", "<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>"},
+		{"javascript link", "[x](javascript:alert(1))", "<p>[x](javascript:alert(1))</p>"},
+		{"mixed case javascript link", "[x](JavaScript:alert(1))", "<p>[x](JavaScript:alert(1))</p>"},
+		{"javascript link with whitespace", "[x](java\tscript:alert(1))", "<p>[x](java\tscript:alert(1))</p>"},
+		{"data link", "[x](data:text/html,hi)", "<p>[x](data:text/html,hi)</p>"},
+		{"bare javascript URL", "javascript:alert(1)", "<p>javascript:alert(1)</p>"},
+		{"quote in link target", `[x](https://e.com/"onmouseover="alert(1))`, "<p>" + anchor("https://e.com/&#34;onmouseover=&#34;alert(1") + "x</a>)</p>"},
+		{"tag after bare URL", "see http://a.com/<script>", "<p>see " + anchor("http://a.com/") + "http://a.com/</a>&lt;script&gt;</p>"},
+		{"attribute in fence language", "```\"><script>\nx", "<pre><code>x</code></pre>"},
+		{"attribute after fence language", "```js onload=x\ncode\n```", "<pre><code>code</code></pre>"},
+		{"tag in link text", "[<b>x</b>](https://e.com)", "<p>" + anchor("https://e.com") + "&lt;b&gt;x&lt;/b&gt;</a></p>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Render(tt.src)
+			if got != tt.want {
+				t.Errorf("Render(%q) = %q, want %q", tt.src, got, tt.want)
+			}
+			if strings.Contains(strings.ToLower(got), "<script") {
+				t.Errorf("Render(%q) = %q contains a script tag", tt.src, got)
+			}
+		})
+	}
+}
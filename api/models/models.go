@@ -4,6 +4,13 @@ import (
 	"time"
 )
 
+// User roles
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
 // User represents a user in the system
 type User struct {
 	ID       int    `json:"id"`
@@ -29,28 +36,594 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
-// Lobby represents a chat lobby
+// Lobby kinds
+const (
+	LobbyKindLobby = "lobby"
+	LobbyKindDM    = "dm"
+)
+
+// Lobby visibilities
+const (
+	VisibilityPublic  = "public"
+	VisibilityPrivate = "private"
+)
+
+// Lobby represents a chat lobby or direct message conversation
 type Lobby struct {
-	ID   int    `json:"id"`
-	Name string `json:"name"`
+	ID          int           `json:"id"`
+	Name        string        `json:"name"`
+	Kind        string        `json:"kind"`
+	Visibility  string        `json:"visibility"`
+	Topic       string        `json:"topic"`
+	Description string        `json:"description"`
+	ArchivedAt  *time.Time    `json:"archived_at,omitempty"`
+	CreatedBy   *int          `json:"created_by,omitempty"`
+	CreatedAt   time.Time     `json:"created_at"`
+	OwnerID     *int          `json:"owner_id,omitempty"`
+	Settings    LobbySettings `json:"settings"`
+	MemberIDs   []int         `json:"member_ids,omitempty"`
+}
+
+// LobbySettings represents the owner-configurable settings of a lobby
+type LobbySettings struct {
+	MaxMembers int    `json:"max_members"` // zero means unlimited
+	PostPolicy string `json:"post_policy"` // who may send messages
+	SlowMode   int    `json:"slow_mode"`   // seconds between a user's messages, zero to disable
+	MessageTTL int    `json:"message_ttl"` // seconds until messages expire, zero to keep them
+}
+
+// MaxMessageTTL is the longest, in seconds, messages may be kept before
+// they expire
+const MaxMessageTTL = 30 * 24 * 60 * 60
+
+// Lobby post policies
+const (
+	PostPolicyEveryone   = "everyone"
+	PostPolicyMembers    = "members"
+	PostPolicyModerators = "moderators"
+)
+
+// LobbySummary represents a lobby as shown in the lobby listing
+type LobbySummary struct {
+	Lobby
+	MemberCount int             `json:"member_count"`
+	OnlineCount int             `json:"online_count"`
+	LastMessage *MessagePreview `json:"last_message,omitempty"`
+}
+
+// MessagePreview represents the start of a lobby's latest message
+type MessagePreview struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	Content   string    `json:"content"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// LobbyListOptions filters, sorts and pages the lobby listing
+type LobbyListOptions struct {
+	UserID          int
+	Query           string
+	Sort            string
+	Cursor          string
+	Limit           int
+	IncludeArchived bool
+}
+
+// ImportedMessage is a message imported from another chat system with its
+// original timestamp
+type ImportedMessage struct {
+	UserID    int
+	Content   string
+	Timestamp time.Time
+	ParentID  *int
+	// ImportKey identifies the message in the history it was imported from,
+	// so it is not imported into the same lobby twice
+	ImportKey string
+}
+
+// MessageSearchOptions filters and pages a message search. Only lobbies
+// UserID can read are searched.
+type MessageSearchOptions struct {
+	UserID  int
+	Query   string
+	LobbyID int
+	FromID  int
+	Before  *time.Time
+	After   *time.Time
+	Cursor  string
+	Limit   int
 }
 
+// SearchResult is a message matching a search, with its relevance and a
+// snippet of its content with the matches highlighted in <mark> tags
+type SearchResult struct {
+	Message Message `json:"message"`
+	Rank    float32 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
+
+// Lobby listing sort orders
+const (
+	LobbySortActivity = "activity"
+	LobbySortName     = "name"
+	LobbySortMembers  = "members"
+)
+
 // LobbyRequest represents a request to create a lobby
 type LobbyRequest struct {
-	Name string `json:"name"`
+	Name       string         `json:"name"`
+	Visibility string         `json:"visibility,omitempty"`
+	Settings   *LobbySettings `json:"settings,omitempty"`
+}
+
+// LobbyUpdateRequest represents a partial update of a lobby's details
+type LobbyUpdateRequest struct {
+	Name        *string        `json:"name,omitempty"`
+	Topic       *string        `json:"topic,omitempty"`
+	Description *string        `json:"description,omitempty"`
+	Settings    *LobbySettings `json:"settings,omitempty"`
+}
+
+// OwnerTransferRequest represents a request to transfer a lobby to another user
+type OwnerTransferRequest struct {
+	UserID int `json:"user_id"`
+}
+
+// Invite represents a signed invite link to a lobby
+type Invite struct {
+	ID        int       `json:"id"`
+	LobbyID   int       `json:"lobby_id"`
+	Token     string    `json:"token,omitempty"`
+	CreatedBy int       `json:"created_by"`
+	ExpiresAt time.Time `json:"expires_at"`
+	MaxUses   *int      `json:"max_uses,omitempty"`
+	Uses      int       `json:"uses"`
+}
+
+// InviteRequest represents a request to create an invite link
+type InviteRequest struct {
+	ExpiresIn int `json:"expires_in,omitempty"` // seconds
+	MaxUses   int `json:"max_uses,omitempty"`
+}
+
+// DMRequest represents a request to open a direct message conversation
+type DMRequest struct {
+	UserIDs []int `json:"user_ids"`
 }
 
 // Message represents a chat message
 type Message struct {
-	ID        int       `json:"id"`
-	Content   string    `json:"content"`
+	ID          int             `json:"id"`
+	Content     string          `json:"content"`
+	HTML        string          `json:"html"` // Content rendered from Markdown and sanitized
+	UserID      int             `json:"user_id"`
+	Username    string          `json:"username"`
+	LobbyID     int             `json:"lobby_id"`
+	Timestamp   time.Time       `json:"timestamp"`
+	DeletedAt   *time.Time      `json:"deleted_at,omitempty"`
+	DeletedBy   *int            `json:"deleted_by,omitempty"`
+	Reactions   []ReactionCount `json:"reactions,omitempty"`
+	ParentID    *int            `json:"parent_id,omitempty"`
+	ReplyCount  int             `json:"reply_count,omitempty"`
+	LastReplyAt *time.Time      `json:"last_reply_at,omitempty"`
+	Attachments []Attachment    `json:"attachments,omitempty"`
+	PinnedAt    *time.Time      `json:"pinned_at,omitempty"`
+	PinnedBy    *int            `json:"pinned_by,omitempty"`
+	ExpiresAt   *time.Time      `json:"expires_at,omitempty"`
+}
+
+// Attachment represents a file uploaded to a lobby. It belongs to the
+// uploader until it is sent with a message.
+type Attachment struct {
+	ID          int       `json:"id"`
+	LobbyID     int       `json:"lobby_id"`
+	MessageID   *int      `json:"message_id,omitempty"`
+	UploaderID  int       `json:"uploader_id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	URL         string    `json:"url"`
+	CreatedAt   time.Time `json:"created_at"`
+	StorageKey  string    `json:"-"`
+}
+
+// Thread represents a message and its replies
+type Thread struct {
+	Parent  Message   `json:"parent"`
+	Replies []Message `json:"replies"`
+}
+
+// MessageRequest represents a request received over the WebSocket.
+// An empty Type sends a chat message.
+type MessageRequest struct {
+	Type      string `json:"type,omitempty"`
+	Content   string `json:"content"`
+	MessageID int    `json:"message_id,omitempty"`
+	ParentID  int    `json:"parent_id,omitempty"`
+	Emoji     string `json:"emoji,omitempty"`
+	// AttachmentIDs are uploaded attachments to send with a chat message
+	AttachmentIDs []int `json:"attachment_ids,omitempty"`
+	// TTL is how many seconds a chat message is kept, at most the lobby's
+	// message TTL if it has one
+	TTL int `json:"ttl,omitempty"`
+	ModerationRequest
+}
+
+// WebSocket request types
+const (
+	RequestReactionAdd       = "reaction.add"
+	RequestReactionRemove    = "reaction.remove"
+	RequestThreadSubscribe   = "thread.subscribe"
+	RequestThreadUnsubscribe = "thread.unsubscribe"
+	RequestModeration        = "moderation"
+	RequestPin               = "message.pin"
+	RequestUnpin             = "message.unpin"
+)
+
+// ReactionCount represents the number of users who reacted with an emoji
+type ReactionCount struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+}
+
+// ReactionRequest represents a request to add a reaction
+type ReactionRequest struct {
+	Emoji string `json:"emoji"`
+}
+
+// LobbyEmoji represents a custom emoji available in a lobby
+type LobbyEmoji struct {
+	ID        int    `json:"id"`
+	LobbyID   int    `json:"lobby_id"`
+	Name      string `json:"name"`
+	URL       string `json:"url"`
+	CreatedBy int    `json:"created_by"`
+}
+
+// LobbyEmojiRequest represents a request to add a custom lobby emoji
+type LobbyEmojiRequest struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// WebSocket event types
+const (
+	EventMessageDeleted  = "message.deleted"
+	EventMessageExpired  = "message.expired"
+	EventMessagePinned   = "message.pinned"
+	EventMessageUnpinned = "message.unpinned"
+	EventReactionAdded   = "reaction.added"
+	EventReactionRemoved = "reaction.removed"
+	EventThreadUpdated   = "thread.updated"
+	EventMention         = "notification.mention"
+	EventReminder        = "notification.reminder"
+	EventScheduledFailed = "notification.scheduled_failed"
+	EventModeration      = "moderation.action"
+	EventLobbyUpdated    = "lobby.updated"
+	EventLobbyArchived   = "lobby.archived"
+	EventLobbyUnarchived = "lobby.unarchived"
+	EventLobbyDeleted    = "lobby.deleted"
+	EventError           = "error"
+)
+
+// Event represents a typed WebSocket event sent to clients
+type Event struct {
+	Type    string      `json:"type"`
+	LobbyID int         `json:"lobby_id"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// MessageDeletedEvent is the payload of a message.deleted event
+type MessageDeletedEvent struct {
+	MessageID int       `json:"message_id"`
+	DeletedBy int       `json:"deleted_by"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// MessageExpiredEvent is the payload of a message.expired event. Replies
+// to an expired message expire with it.
+type MessageExpiredEvent struct {
+	MessageID int  `json:"message_id"`
+	ParentID  *int `json:"parent_id,omitempty"`
+}
+
+// MessageUnpinnedEvent is the payload of a message.unpinned event. The
+// payload of message.pinned is the pinned message.
+type MessageUnpinnedEvent struct {
+	MessageID  int `json:"message_id"`
+	UnpinnedBy int `json:"unpinned_by"`
+}
+
+// ReactionEvent is the payload of reaction.added and reaction.removed events
+type ReactionEvent struct {
+	MessageID int    `json:"message_id"`
+	UserID    int    `json:"user_id"`
+	Emoji     string `json:"emoji"`
+	Count     int    `json:"count"`
+}
+
+// Mention kinds
+const (
+	MentionUser     = "user"
+	MentionHere     = "here"
+	MentionEveryone = "everyone"
+)
+
+// Mention represents a user being mentioned in a message
+type Mention struct {
+	ID        int        `json:"id"`
+	Kind      string     `json:"kind"`
+	Message   Message    `json:"message"`
+	CreatedAt time.Time  `json:"created_at"`
+	ReadAt    *time.Time `json:"read_at,omitempty"`
+}
+
+// Reasons a saved message is unavailable
+const (
+	SavedDeleted  = "deleted"
+	SavedNoAccess = "no_access"
+)
+
+// SavedMessage is a message a user saved to their personal list. Saved
+// messages that were deleted keep their tombstone, while those removed for
+// good and those in lobbies the user can no longer access have no message;
+// Unavailable says why.
+type SavedMessage struct {
+	ID          int       `json:"id"`
+	MessageID   int       `json:"message_id"`
+	LobbyID     int       `json:"lobby_id"`
+	Note        string    `json:"note"`
+	CreatedAt   time.Time `json:"created_at"`
+	Message     *Message  `json:"message,omitempty"`
+	Unavailable string    `json:"unavailable,omitempty"`
+}
+
+// SaveMessageRequest saves a message, or changes the note of a saved message
+type SaveMessageRequest struct {
+	Note string `json:"note"`
+}
+
+// Scheduled item kinds
+const (
+	ScheduledKindMessage  = "message"
+	ScheduledKindReminder = "reminder"
+)
+
+// Scheduled item statuses. Items are sending while the scheduler delivers them.
+const (
+	ScheduledPending = "pending"
+	ScheduledSending = "sending"
+	ScheduledSent    = "sent"
+	ScheduledFailed  = "failed"
+)
+
+// ScheduledMessage is a message a user scheduled to be sent to a lobby
+// later, or a reminder about a message. Content is the message to send, or
+// the reminder's optional note. A scheduled message that fails to send has
+// the reason in Error.
+type ScheduledMessage struct {
+	ID            int        `json:"id"`
+	Kind          string     `json:"kind"`
+	UserID        int        `json:"user_id"`
+	LobbyID       int        `json:"lobby_id"`
+	Content       string     `json:"content"`
+	ParentID      *int       `json:"parent_id,omitempty"`
+	MessageID     *int       `json:"message_id,omitempty"`
+	SendAt        time.Time  `json:"send_at"`
+	Status        string     `json:"status"`
+	SentMessageID *int       `json:"sent_message_id,omitempty"`
+	Error         string     `json:"error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	SentAt        *time.Time `json:"sent_at,omitempty"`
+}
+
+// ScheduleMessageRequest schedules a message, or a thread reply if
+// ParentID is set
+type ScheduleMessageRequest struct {
+	Content  string    `json:"content"`
+	ParentID int       `json:"parent_id,omitempty"`
+	SendAt   time.Time `json:"send_at"`
+}
+
+// ReminderRequest sets a reminder about a message
+type ReminderRequest struct {
+	SendAt time.Time `json:"send_at"`
+	Note   string    `json:"note,omitempty"`
+}
+
+// ReminderEvent is the payload of a notification.reminder event
+type ReminderEvent struct {
+	Reminder ScheduledMessage `json:"reminder"`
+	Message  Message          `json:"message"`
+}
+
+// Moderation actions
+const (
+	ModerationKick   = "kick"
+	ModerationBan    = "ban"
+	ModerationUnban  = "unban"
+	ModerationMute   = "mute"
+	ModerationUnmute = "unmute"
+
+	// ModerationDeleteMessage is logged when a moderator deletes another user's message
+	ModerationDeleteMessage = "delete_message"
+)
+
+// Restriction kinds
+const (
+	RestrictionBan  = "ban"
+	RestrictionMute = "mute"
+)
+
+// Retention policy kinds
+const (
+	RetentionForever  = "forever"
+	RetentionDays     = "days"
+	RetentionMessages = "messages"
+)
+
+// RetentionPolicy is how long a lobby's messages are kept: forever, for a
+// number of days or only the newest number of messages. Expired messages
+// are deleted, or moved to the archive if Archive is set. The default
+// policy, used by lobbies without their own, has no lobby ID.
+type RetentionPolicy struct {
+	LobbyID   *int       `json:"lobby_id"`
+	Keep      string     `json:"keep"`
+	Days      int        `json:"days,omitempty"`
+	Messages  int        `json:"messages,omitempty"`
+	Archive   bool       `json:"archive"`
+	UpdatedBy *int       `json:"updated_by,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// RetentionPolicies lists the default retention policy and the lobbies
+// with their own
+type RetentionPolicies struct {
+	Default RetentionPolicy   `json:"default"`
+	Lobbies []RetentionPolicy `json:"lobbies"`
+}
+
+// RetentionRequest sets a retention policy
+type RetentionRequest struct {
+	Keep     string `json:"keep"`
+	Days     int    `json:"days,omitempty"`
+	Messages int    `json:"messages,omitempty"`
+	Archive  bool   `json:"archive,omitempty"`
+}
+
+// ModerationRequest represents a moderator acting on a user in a lobby.
+// Duration is in seconds; zero makes bans and mutes permanent.
+type ModerationRequest struct {
+	Action   string `json:"action,omitempty"`
+	UserID   int    `json:"user_id,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	Duration int    `json:"duration,omitempty"`
+}
+
+// Restriction represents an active ban or mute of a user in a lobby
+type Restriction struct {
+	LobbyID   int        `json:"lobby_id"`
+	UserID    int        `json:"user_id"`
+	Kind      string     `json:"kind"`
+	Reason    string     `json:"reason,omitempty"`
+	CreatedBy int        `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// ModerationLogEntry represents a moderation action recorded in the audit log
+type ModerationLogEntry struct {
+	ID          int        `json:"id"`
+	LobbyID     int        `json:"lobby_id"`
+	ModeratorID int        `json:"moderator_id"`
+	UserID      int        `json:"user_id"`
+	Action      string     `json:"action"`
+	Reason      string     `json:"reason,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// Report reason codes
+const (
+	ReportSpam       = "spam"
+	ReportHarassment = "harassment"
+	ReportHate       = "hate"
+	ReportNSFW       = "nsfw"
+	ReportOther      = "other"
+)
+
+// Report sources
+const (
+	ReportSourceUser   = "user"
+	ReportSourceFilter = "filter"
+)
+
+// Report statuses
+const (
+	ReportOpen     = "open"
+	ReportClaimed  = "claimed"
+	ReportResolved = "resolved"
+)
+
+// ReportDismiss resolves a report without acting on it
+const ReportDismiss = "dismiss"
+
+// Report represents a message reported by a user or flagged by a filter for moderator review
+type Report struct {
+	ID         int        `json:"id"`
+	LobbyID    int        `json:"lobby_id"`
+	Message    Message    `json:"message"`
+	Source     string     `json:"source"`
+	ReporterID *int       `json:"reporter_id,omitempty"`
+	Filter     string     `json:"filter,omitempty"`
+	Reason     string     `json:"reason"`
+	Details    string     `json:"details,omitempty"`
+	Status     string     `json:"status"`
+	ClaimedBy  *int       `json:"claimed_by,omitempty"`
+	ClaimedAt  *time.Time `json:"claimed_at,omitempty"`
+	ResolvedBy *int       `json:"resolved_by,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	Resolution string     `json:"resolution,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// ReportRequest represents a user reporting a message
+type ReportRequest struct {
+	Reason  string `json:"reason"`
+	Details string `json:"details,omitempty"`
+}
+
+// ReportResolveRequest represents a moderator resolving a report. Action is
+// dismiss, delete_message, mute or ban; mutes and bans apply to the
+// message's author.
+type ReportResolveRequest struct {
+	Action   string `json:"action"`
+	Reason   string `json:"reason,omitempty"`
+	Duration int    `json:"duration,omitempty"`
+}
+
+// Block represents a user blocked by the acting user
+type Block struct {
 	UserID    int       `json:"user_id"`
 	Username  string    `json:"username"`
-	LobbyID   int       `json:"lobby_id"`
-	Timestamp time.Time `json:"timestamp"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
-// MessageRequest represents a request to send a message
-type MessageRequest struct {
-	Content string `json:"content"`
+// ErrorEvent is the payload of an error event, sent only to the client whose
+// request was refused
+type ErrorEvent struct {
+	Code    string     `json:"code"`
+	Message string     `json:"message"`
+	RetryAt *time.Time `json:"retry_at,omitempty"` // when the request may be retried
+}
+
+// Error event codes
+const (
+	ErrorRateLimited    = "rate_limited"
+	ErrorSlowMode       = "slow_mode"
+	ErrorNotAllowed     = "not_allowed"
+	ErrorInvalidRequest = "invalid_request"
+	ErrorInvalidContent = "invalid_content"
+	ErrorRejected       = "message_rejected"
+	ErrorInternal       = "internal_error"
+)
+
+// LobbyDeletedEvent is the payload of a lobby.deleted event
+type LobbyDeletedEvent struct {
+	LobbyID       int `json:"lobby_id"`
+	MessagesMoved int `json:"messages_moved_to,omitempty"`
+}
+
+// ThreadUpdatedEvent is the payload of a thread.updated event
+type ThreadUpdatedEvent struct {
+	ParentID    int        `json:"parent_id"`
+	ReplyCount  int        `json:"reply_count"`
+	LastReplyAt *time.Time `json:"last_reply_at,omitempty"`
+}
+
+// MentionEvent is the payload of a notification.mention event
+type MentionEvent struct {
+	Kind    string  `json:"kind"`
+	Message Message `json:"message"`
 }
@@ -0,0 +1,232 @@
+package routes
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/galexander77/chat-app/api/config"
+	"github.com/galexander77/chat-app/api/db"
+	"github.com/galexander77/chat-app/api/storage"
+	"github.com/galexander77/chat-app/api/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// maxFilenameLength is the most characters kept of an uploaded file's name
+const maxFilenameLength = 255
+
+// uploadPath matches the path of the upload route, which fiber matches case-insensitively
+var uploadPath = regexp.MustCompile(`(?i)^/api/lobbies/[^/]+/attachments/?$`)
+
+// RegisterAttachmentRoutes registers attachment upload and download routes
+func RegisterAttachmentRoutes(app *fiber.App, database *sql.DB, store storage.Storage, cfg config.AttachmentsConfig) {
+	repos := &websocket.Repositories{
+		Lobbies:    db.NewLobbyRepository(database),
+		Moderation: db.NewModerationRepository(database),
+	}
+	attachmentRepo := db.NewAttachmentRepository(database)
+
+	// Leave room for the largest attachment and its multipart framing
+	allowLargeUploads(app, int(cfg.MaxSize)+1024*1024)
+
+	// Routes
+	app.Post("/api/lobbies/:id/attachments", uploadAttachmentHandler(repos, attachmentRepo, store, cfg))
+	app.Get("/api/attachments/:id", getAttachmentHandler(repos.Lobbies, attachmentRepo, store))
+}
+
+// allowLargeUploads raises the request body limit to limit for the upload
+// route only. Every other route keeps the app's BodyLimit, so their bodies
+// are refused before being read if they are larger.
+func allowLargeUploads(app *fiber.App, limit int) {
+	app.Server().HeaderReceived = func(header *fasthttp.RequestHeader) fasthttp.RequestConfig {
+		uriPath, _, _ := bytes.Cut(header.RequestURI(), []byte("?"))
+		if header.IsPost() && uploadPath.Match(uriPath) {
+			return fasthttp.RequestConfig{MaxRequestBodySize: limit}
+		}
+		return fasthttp.RequestConfig{}
+	}
+}
+
+// uploadAttachmentHandler handles uploading a file to a lobby as a multipart
+// form with a file field. The file's type is detected from its contents,
+// ignoring the type the client claims. The returned attachment is sent by
+// passing its ID in a chat message's attachment_ids.
+func uploadAttachmentHandler(repos *websocket.Repositories, attachmentRepo *db.AttachmentRepository, store storage.Storage, cfg config.AttachmentsConfig) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := getUserID(c)
+		if err != nil {
+			return err
+		}
+		lobbyID, err := getIDParam(c, "id")
+		if err != nil {
+			return err
+		}
+		if err := requireLobbyAccess(repos.Lobbies, lobbyID, userID); err != nil {
+			return err
+		}
+		// Uploads are only sent as messages, so refuse them where the user cannot post
+		if err := requirePosting(repos, lobbyID, userID); err != nil {
+			return err
+		}
+
+		header, err := c.FormFile("file")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "A file is required")
+		}
+		if header.Size > cfg.MaxSize {
+			return fiber.NewError(fiber.StatusRequestEntityTooLarge, fmt.Sprintf("Files may be at most %d bytes", cfg.MaxSize))
+		}
+		if header.Size == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "File is empty")
+		}
+
+		file, err := header.Open()
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error reading upload: "+err.Error())
+		}
+		defer file.Close()
+
+		contentType, err := sniffContentType(file)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error reading upload: "+err.Error())
+		}
+		if !allowedType(cfg.AllowedTypes, contentType) {
+			return fiber.NewError(fiber.StatusUnsupportedMediaType, "Files of type "+contentType+" are not allowed")
+		}
+
+		key, err := newStorageKey(lobbyID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error storing file: "+err.Error())
+		}
+		if err := store.Put(key, file, header.Size, contentType); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error storing file: "+err.Error())
+		}
+
+		attachment, err := attachmentRepo.CreateAttachment(lobbyID, userID, key, cleanFilename(header.Filename), contentType, header.Size)
+		if err != nil {
+			if err := store.Delete(key); err != nil {
+				log.Println("Error deleting stored file:", err)
+			}
+			return fiber.NewError(fiber.StatusInternalServerError, "Error saving attachment: "+err.Error())
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(attachment)
+	}
+}
+
+// getAttachmentHandler handles downloading an attachment. Users who can read
+// the lobby can download sent attachments, but only the uploader can
+// download one that has not been sent yet.
+func getAttachmentHandler(lobbyRepo *db.LobbyRepository, attachmentRepo *db.AttachmentRepository, store storage.Storage) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		attachmentID, err := getIDParam(c, "id")
+		if err != nil {
+			return err
+		}
+		userID := c.QueryInt("userID")
+
+		attachment, err := attachmentRepo.GetAttachment(attachmentID)
+		if errors.Is(err, db.ErrAttachmentNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "Attachment not found")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error fetching attachment: "+err.Error())
+		}
+		if attachment.MessageID == nil && attachment.UploaderID != userID {
+			return fiber.NewError(fiber.StatusNotFound, "Attachment not found")
+		}
+		if err := requireLobbyAccess(lobbyRepo, attachment.LobbyID, userID); err != nil {
+			return err
+		}
+
+		body, err := store.Open(attachment.StorageKey)
+		if errors.Is(err, storage.ErrNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "Attachment not found")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error reading attachment: "+err.Error())
+		}
+
+		// Images are shown inline, anything else is downloaded
+		disposition := "attachment"
+		if strings.HasPrefix(attachment.ContentType, "image/") {
+			disposition = "inline"
+		}
+		c.Set(fiber.HeaderContentType, attachment.ContentType)
+		c.Set(fiber.HeaderContentDisposition, mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename}))
+		c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+
+		// The body is closed once it has been sent
+		return c.SendStream(body, int(attachment.Size))
+	}
+}
+
+// sniffContentType detects the content type of a file from its first bytes,
+// leaving the file positioned at its start
+func sniffContentType(file io.ReadSeeker) (string, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	contentType, _, err := mime.ParseMediaType(http.DetectContentType(head[:n]))
+	if err != nil {
+		return "application/octet-stream", nil
+	}
+	return contentType, nil
+}
+
+// allowedType reports whether a content type is in the allowed list
+func allowedType(allowed []string, contentType string) bool {
+	for _, t := range allowed {
+		if strings.EqualFold(t, contentType) {
+			return true
+		}
+	}
+	return false
+}
+
+// newStorageKey generates a unique, unguessable storage key for a lobby's upload
+func newStorageKey(lobbyID int) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("lobbies/%d/%s", lobbyID, hex.EncodeToString(b)), nil
+}
+
+// cleanFilename keeps the base name of an uploaded file without control
+// characters, shortened to maxFilenameLength characters
+func cleanFilename(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, strings.ToValidUTF8(name, "")))
+
+	if runes := []rune(name); len(runes) > maxFilenameLength {
+		name = string(runes[:maxFilenameLength])
+	}
+	if name == "" || name == "." || name == "/" {
+		return "file"
+	}
+	return name
+}
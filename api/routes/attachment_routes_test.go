@@ -0,0 +1,52 @@
+package routes
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+func TestAllowLargeUploads(t *testing.T) {
+	app := fiber.New(fiber.Config{BodyLimit: 1024})
+	allowLargeUploads(app, 4096)
+	ok := func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusNoContent) }
+	app.Post("/api/lobbies/:id/attachments", ok)
+	app.Post("/api/lobbies/:id/messages", ok)
+
+	tests := []struct {
+		name     string
+		target   string
+		size     int
+		tooLarge bool
+	}{
+		{"small body", "/api/lobbies/1/messages", 512, false},
+		{"large body elsewhere", "/api/lobbies/1/messages", 2048, true},
+		{"large upload", "/api/lobbies/1/attachments?userID=2", 2048, false},
+		{"upload path in other case", "/API/Lobbies/1/Attachments", 2048, false},
+		{"upload over the upload limit", "/api/lobbies/1/attachments", 8192, true},
+		{"upload path in the query only", "/api/lobbies/1/messages?next=/api/lobbies/1/attachments", 2048, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(fiber.MethodPost, tt.target, bytes.NewReader(make([]byte, tt.size)))
+			resp, err := app.Test(req)
+			if tt.tooLarge {
+				if !errors.Is(err, fasthttp.ErrBodyTooLarge) {
+					t.Errorf("Test() error = %v, want %v", err, fasthttp.ErrBodyTooLarge)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Test() error = %v", err)
+			}
+			if resp.StatusCode != fiber.StatusNoContent {
+				t.Errorf("status = %d, want %d", resp.StatusCode, fiber.StatusNoContent)
+			}
+		})
+	}
+}
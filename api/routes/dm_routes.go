@@ -0,0 +1,106 @@
+package routes
+
+import (
+	"database/sql"
+
+	"github.com/galexander77/chat-app/api/db"
+	"github.com/galexander77/chat-app/api/models"
+	"github.com/galexander77/chat-app/api/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxDMMembers is the largest group allowed in a direct message conversation
+const maxDMMembers = 10
+
+// RegisterDMRoutes registers direct message routes
+func RegisterDMRoutes(app *fiber.App, database *sql.DB) {
+	userRepo := db.NewUserRepository(database)
+	lobbyRepo := db.NewLobbyRepository(database)
+	blockRepo := db.NewBlockRepository(database)
+
+	// DM group
+	dm := app.Group("/api/dms")
+
+	// Routes
+	dm.Get("/", getDMsHandler(lobbyRepo))
+	dm.Post("/", openDMHandler(lobbyRepo, userRepo, blockRepo))
+}
+
+// getDMsHandler handles getting the acting user's direct message conversations
+func getDMsHandler(lobbyRepo *db.LobbyRepository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := getUserID(c)
+		if err != nil {
+			return err
+		}
+
+		dms, err := lobbyRepo.GetUserDMs(userID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error fetching direct messages: "+err.Error())
+		}
+
+		return c.JSON(dms)
+	}
+}
+
+// openDMHandler handles opening a direct message conversation between the
+// acting user and the requested users, returning the existing one if any.
+// Users cannot open conversations with anyone who has blocked them.
+func openDMHandler(lobbyRepo *db.LobbyRepository, userRepo *db.UserRepository, blockRepo *db.BlockRepository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := getUserID(c)
+		if err != nil {
+			return err
+		}
+
+		var req models.DMRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+
+		members := []int{userID}
+		seen := map[int]bool{userID: true}
+		for _, id := range req.UserIDs {
+			if !seen[id] {
+				seen[id] = true
+				members = append(members, id)
+			}
+		}
+		if len(members) < 2 {
+			return fiber.NewError(fiber.StatusBadRequest, "At least one other user is required")
+		}
+		if len(members) > maxDMMembers {
+			return fiber.NewError(fiber.StatusBadRequest, "Too many users in conversation")
+		}
+
+		exist, err := userRepo.UsersExist(members)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error checking users: "+err.Error())
+		}
+		if !exist {
+			return fiber.NewError(fiber.StatusBadRequest, "Unknown user")
+		}
+
+		blocked, err := blockRepo.IsBlockedByAny(members[1:], userID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error checking blocks: "+err.Error())
+		}
+		if blocked {
+			return fiber.NewError(fiber.StatusForbidden, "Cannot message a user who has blocked you")
+		}
+
+		dm, created, err := lobbyRepo.GetOrCreateDM(members)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error opening direct message: "+err.Error())
+		}
+
+		if !created {
+			return c.JSON(dm)
+		}
+
+		// Initialize connections map for this conversation
+		websocket.InitLobby(dm.ID)
+
+		return c.Status(fiber.StatusCreated).JSON(dm)
+	}
+}
@@ -0,0 +1,99 @@
+package routes
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/galexander77/chat-app/api/db"
+	"github.com/galexander77/chat-app/api/export"
+	"github.com/galexander77/chat-app/api/models"
+	"github.com/galexander77/chat-app/api/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RegisterExportRoutes registers lobby transcript export routes
+func RegisterExportRoutes(app *fiber.App, database *sql.DB) {
+	repos := &websocket.Repositories{
+		Users:    db.NewUserRepository(database),
+		Lobbies:  db.NewLobbyRepository(database),
+		Messages: db.NewMessageRepository(database),
+	}
+
+	// Routes
+	app.Get("/api/lobbies/:id/export", exportLobbyHandler(repos))
+}
+
+// exportLobbyHandler handles downloading a lobby's transcript as json, csv,
+// html or txt, optionally limited to messages sent from and before to. The
+// transcript is streamed as it is read from the database. Moderators and
+// the lobby owner get the content of deleted messages; other users get
+// tombstones.
+func exportLobbyHandler(repos *websocket.Repositories) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := getUserID(c)
+		if err != nil {
+			return err
+		}
+		lobbyID, err := getIDParam(c, "id")
+		if err != nil {
+			return err
+		}
+
+		format := c.Query("format", export.FormatJSON)
+		if _, err := export.NewWriter(format, nil); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Format must be json, csv, html or txt")
+		}
+		from, err := getTimeQuery(c, "from")
+		if err != nil {
+			return err
+		}
+		to, err := getTimeQuery(c, "to")
+		if err != nil {
+			return err
+		}
+
+		if err := requireLobbyAccess(repos.Lobbies, lobbyID, userID); err != nil {
+			return err
+		}
+		lobby, err := repos.Lobbies.GetLobbyByID(lobbyID)
+		if errors.Is(err, db.ErrLobbyNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "Lobby not found")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error fetching lobby: "+err.Error())
+		}
+		includeDeleted, err := websocket.CanModerate(repos, lobbyID, userID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error checking permissions: "+err.Error())
+		}
+
+		c.Set(fiber.HeaderContentType, export.ContentType(format))
+		c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="lobby-%d.%s"`, lobbyID, format))
+
+		// The status and headers are sent before streaming starts, so errors
+		// from here on can only be logged and end the transcript early
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			writer, _ := export.NewWriter(format, w)
+			err := writer.Begin(lobby)
+			if err == nil {
+				err = repos.Messages.StreamMessages(lobbyID, userID, from, to, includeDeleted, func(message models.Message) error {
+					return writer.Write(message)
+				})
+			}
+			if err == nil {
+				err = writer.End()
+			}
+			if err == nil {
+				err = w.Flush()
+			}
+			if err != nil {
+				log.Printf("Error exporting lobby %d: %v", lobbyID, err)
+			}
+		})
+
+		return nil
+	}
+}
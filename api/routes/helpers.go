@@ -0,0 +1,96 @@
+package routes
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/galexander77/chat-app/api/db"
+	"github.com/galexander77/chat-app/api/models"
+	"github.com/galexander77/chat-app/api/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+// getUserID gets the acting user's ID from the userID query parameter,
+// matching how the WebSocket route identifies users
+func getUserID(c *fiber.Ctx) (int, error) {
+	userID, err := strconv.Atoi(c.Query("userID"))
+	if err != nil || userID <= 0 {
+		return 0, fiber.NewError(fiber.StatusUnauthorized, "User ID is required")
+	}
+
+	return userID, nil
+}
+
+// getIDParam gets a positive integer route parameter
+func getIDParam(c *fiber.Ctx, name string) (int, error) {
+	id, err := strconv.Atoi(c.Params(name))
+	if err != nil || id <= 0 {
+		return 0, fiber.NewError(fiber.StatusBadRequest, "Invalid "+name)
+	}
+
+	return id, nil
+}
+
+// requireLobbyAccess returns a fiber error unless the user may access the
+// lobby and is not banned from it
+func requireLobbyAccess(lobbyRepo *db.LobbyRepository, lobbyID, userID int) error {
+	allowed, err := lobbyRepo.CanAccess(lobbyID, userID)
+	if errors.Is(err, db.ErrLobbyNotFound) {
+		return fiber.NewError(fiber.StatusNotFound, "Lobby not found")
+	}
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Error checking lobby access: "+err.Error())
+	}
+	if !allowed {
+		return fiber.NewError(fiber.StatusForbidden, "Not a member of this lobby")
+	}
+
+	banned, err := lobbyRepo.IsBanned(lobbyID, userID)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Error checking ban: "+err.Error())
+	}
+	if banned {
+		return fiber.NewError(fiber.StatusForbidden, "Banned from this lobby")
+	}
+
+	return nil
+}
+
+// requirePosting returns a fiber error if the user is muted in the lobby or
+// the lobby is archived, the checks WebSocket clients get before posting
+func requirePosting(repos *websocket.Repositories, lobbyID, userID int) error {
+	mute, err := repos.Moderation.GetActiveRestriction(lobbyID, userID, models.RestrictionMute)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Error checking mute: "+err.Error())
+	}
+	if mute != nil {
+		return fiber.NewError(fiber.StatusForbidden, "You are muted in this lobby")
+	}
+
+	lobby, err := repos.Lobbies.GetLobbyByID(lobbyID)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Error fetching lobby: "+err.Error())
+	}
+	if lobby.ArchivedAt != nil {
+		return fiber.NewError(fiber.StatusForbidden, "This lobby is archived")
+	}
+
+	return nil
+}
+
+// getTimeQuery gets an optional RFC 3339 timestamp query parameter in UTC,
+// the time zone timestamps are stored in
+func getTimeQuery(c *fiber.Ctx, name string) (*time.Time, error) {
+	value := c.Query(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Invalid "+name+", expected an RFC 3339 timestamp")
+	}
+	t = t.UTC()
+	return &t, nil
+}
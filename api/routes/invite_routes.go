@@ -0,0 +1,123 @@
+package routes
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/galexander77/chat-app/api/db"
+	"github.com/galexander77/chat-app/api/invite"
+	"github.com/galexander77/chat-app/api/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Invite expiry limits
+const (
+	defaultInviteExpiry = 7 * 24 * time.Hour
+	maxInviteExpiry     = 30 * 24 * time.Hour
+)
+
+// RegisterInviteRoutes registers lobby invite routes
+func RegisterInviteRoutes(app *fiber.App, database *sql.DB, signer *invite.Signer, baseURL string) {
+	lobbyRepo := db.NewLobbyRepository(database)
+
+	// Routes
+	app.Post("/api/lobbies/:id/invites", createInviteHandler(lobbyRepo, signer, baseURL))
+	app.Post("/api/invites/:token/accept", acceptInviteHandler(lobbyRepo, signer))
+}
+
+// createInviteHandler handles creating an invite link to a lobby. Only
+// members may invite others.
+func createInviteHandler(lobbyRepo *db.LobbyRepository, signer *invite.Signer, baseURL string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := getUserID(c)
+		if err != nil {
+			return err
+		}
+		lobbyID, err := getIDParam(c, "id")
+		if err != nil {
+			return err
+		}
+
+		var req models.InviteRequest
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&req); err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+			}
+		}
+		if req.ExpiresIn < 0 || req.MaxUses < 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "Expiry and max uses must not be negative")
+		}
+
+		expiresIn := time.Duration(req.ExpiresIn) * time.Second
+		if expiresIn == 0 {
+			expiresIn = defaultInviteExpiry
+		}
+		if expiresIn > maxInviteExpiry {
+			return fiber.NewError(fiber.StatusBadRequest, "Invites may not last longer than 30 days")
+		}
+
+		lobby, err := lobbyRepo.GetLobbyByID(lobbyID)
+		if errors.Is(err, db.ErrLobbyNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "Lobby not found")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error fetching lobby: "+err.Error())
+		}
+		if lobby.Kind == models.LobbyKindDM {
+			return fiber.NewError(fiber.StatusBadRequest, "Direct messages do not support invites")
+		}
+
+		isMember, err := lobbyRepo.IsMember(lobbyID, userID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error checking membership: "+err.Error())
+		}
+		if !isMember {
+			return fiber.NewError(fiber.StatusForbidden, "Not a member of this lobby")
+		}
+
+		inv, err := lobbyRepo.CreateInvite(lobbyID, userID, expiresIn, req.MaxUses)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error creating invite: "+err.Error())
+		}
+		inv.Token = signer.Sign(inv.ID, inv.LobbyID)
+
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"invite": inv,
+			"url":    baseURL + inv.Token,
+		})
+	}
+}
+
+// acceptInviteHandler handles joining a lobby through an invite link
+func acceptInviteHandler(lobbyRepo *db.LobbyRepository, signer *invite.Signer) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := getUserID(c)
+		if err != nil {
+			return err
+		}
+
+		inviteID, lobbyID, err := signer.Verify(c.Params("token"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusNotFound, "Invalid invite")
+		}
+
+		err = lobbyRepo.RedeemInvite(inviteID, lobbyID, userID)
+		if errors.Is(err, db.ErrInviteUnavailable) {
+			return fiber.NewError(fiber.StatusGone, "Invite has expired or reached its maximum uses")
+		}
+		if errors.Is(err, db.ErrLobbyFull) {
+			return fiber.NewError(fiber.StatusConflict, "Lobby is full")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error accepting invite: "+err.Error())
+		}
+
+		lobby, err := lobbyRepo.GetLobbyByID(lobbyID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error fetching lobby: "+err.Error())
+		}
+
+		return c.JSON(lobby)
+	}
+}
@@ -2,16 +2,33 @@ package routes
 
 import (
 	"database/sql"
+	"errors"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/galexander77/chat-app/api/db"
+	"github.com/galexander77/chat-app/api/emoji"
 	"github.com/galexander77/chat-app/api/models"
 	"github.com/galexander77/chat-app/api/websocket"
 	"github.com/gofiber/fiber/v2"
 )
 
+// maxSlowMode is the longest slow-mode interval in seconds
+const maxSlowMode = 6 * 60 * 60
+
+// maxLobbyName is the longest lobby name in characters, the length of the
+// name column
+const maxLobbyName = 50
+
 // RegisterLobbyRoutes registers lobby routes
 func RegisterLobbyRoutes(app *fiber.App, database *sql.DB) {
 	lobbyRepo := db.NewLobbyRepository(database)
+	messageRepo := db.NewMessageRepository(database)
+	reactionRepo := db.NewReactionRepository(database)
+	repos := &websocket.Repositories{
+		Users:   db.NewUserRepository(database),
+		Lobbies: lobbyRepo,
+	}
 
 	// Lobby group
 	lobby := app.Group("/api/lobbies")
@@ -19,29 +36,124 @@ func RegisterLobbyRoutes(app *fiber.App, database *sql.DB) {
 	// Routes
 	lobby.Get("/", getLobbiesHandler(lobbyRepo))
 	lobby.Post("/", createLobbyHandler(lobbyRepo))
+	lobby.Get("/:id", getLobbyHandler(lobbyRepo))
+	lobby.Patch("/:id", updateLobbyHandler(repos))
+	lobby.Delete("/:id", deleteLobbyHandler(repos))
+	lobby.Post("/:id/archive", archiveLobbyHandler(repos, true))
+	lobby.Post("/:id/unarchive", archiveLobbyHandler(repos, false))
+	lobby.Post("/:id/transfer", transferLobbyHandler(repos))
+	lobby.Post("/:id/join", joinLobbyHandler(lobbyRepo))
+	lobby.Post("/:id/leave", leaveLobbyHandler(lobbyRepo))
+	lobby.Get("/:id/messages", getLobbyMessagesHandler(lobbyRepo, messageRepo))
+	lobby.Get("/:id/pins", getPinnedMessagesHandler(lobbyRepo, messageRepo))
+	lobby.Get("/:id/emojis", getLobbyEmojisHandler(lobbyRepo, reactionRepo))
+	lobby.Post("/:id/emojis", createLobbyEmojiHandler(repos, reactionRepo))
 }
 
-// getLobbiesHandler handles getting all lobbies
+// getLobbiesHandler handles listing the lobbies visible to the optional
+// userID. Lobbies can be searched by name and topic with q and sorted by
+// activity, name or members. Archived lobbies are only included when
+// archived=true. The cursor of the next page is returned in the
+// X-Next-Cursor header.
 func getLobbiesHandler(lobbyRepo *db.LobbyRepository) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		lobbies, err := lobbyRepo.GetAllLobbies()
+		opts := models.LobbyListOptions{
+			UserID:          c.QueryInt("userID"),
+			Query:           c.Query("q"),
+			Sort:            c.Query("sort", models.LobbySortActivity),
+			Cursor:          c.Query("cursor"),
+			Limit:           c.QueryInt("limit", defaultPageSize),
+			IncludeArchived: c.QueryBool("archived"),
+		}
+		switch opts.Sort {
+		case models.LobbySortActivity, models.LobbySortName, models.LobbySortMembers:
+		default:
+			return fiber.NewError(fiber.StatusBadRequest, "Sort must be activity, name or members")
+		}
+		if opts.Limit <= 0 || opts.Limit > maxPageSize {
+			opts.Limit = defaultPageSize
+		}
+
+		lobbies, next, err := lobbyRepo.GetAllLobbies(opts)
+		if errors.Is(err, db.ErrInvalidCursor) {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid cursor")
+		}
 		if err != nil {
 			return fiber.NewError(fiber.StatusInternalServerError, "Error fetching lobbies: "+err.Error())
 		}
 
+		for i := range lobbies {
+			lobbies[i].OnlineCount = websocket.OnlineCount(lobbies[i].ID)
+		}
+		if next != "" {
+			c.Set("X-Next-Cursor", next)
+		}
+
 		return c.JSON(lobbies)
 	}
 }
 
-// createLobbyHandler handles creating a new lobby
+// getLobbyHandler handles getting a single lobby the optional userID can
+// access, including archived lobbies
+func getLobbyHandler(lobbyRepo *db.LobbyRepository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		lobbyID, err := getIDParam(c, "id")
+		if err != nil {
+			return err
+		}
+		if err := requireLobbyAccess(lobbyRepo, lobbyID, c.QueryInt("userID")); err != nil {
+			return err
+		}
+
+		lobby, err := lobbyRepo.GetLobbyByID(lobbyID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error fetching lobby: "+err.Error())
+		}
+
+		return c.JSON(lobby)
+	}
+}
+
+// createLobbyHandler handles creating a new lobby. Private lobbies require
+// a userID, and the creator becomes the first member.
 func createLobbyHandler(lobbyRepo *db.LobbyRepository) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		var lobby models.Lobby
-		if err := c.BodyParser(&lobby); err != nil {
+		var req models.LobbyRequest
+		if err := c.BodyParser(&req); err != nil {
 			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
 		}
 
-		lobbyID, err := lobbyRepo.CreateLobby(lobby.Name)
+		name, err := validateLobbyName(req.Name)
+		if err != nil {
+			return err
+		}
+
+		switch req.Visibility {
+		case "":
+			req.Visibility = models.VisibilityPublic
+		case models.VisibilityPublic, models.VisibilityPrivate:
+		default:
+			return fiber.NewError(fiber.StatusBadRequest, "Visibility must be public or private")
+		}
+
+		settings := models.LobbySettings{PostPolicy: models.PostPolicyEveryone}
+		if req.Settings != nil {
+			settings = *req.Settings
+		}
+		if err := validateSettings(&settings); err != nil {
+			return err
+		}
+
+		// The creator owns the lobby, so every lobby needs one
+		creatorID, err := getUserID(c)
+		if err != nil {
+			return err
+		}
+
+		lobbyID, err := lobbyRepo.CreateLobby(name, req.Visibility, creatorID, settings)
+		if errors.Is(err, db.ErrLobbyNameTaken) {
+			return fiber.NewError(fiber.StatusConflict, "Lobby name is already taken")
+		}
 		if err != nil {
 			return fiber.NewError(fiber.StatusInternalServerError, "Error creating lobby: "+err.Error())
 		}
@@ -49,8 +161,408 @@ func createLobbyHandler(lobbyRepo *db.LobbyRepository) fiber.Handler {
 		// Initialize connections map for this lobby
 		websocket.InitLobby(lobbyID)
 
+		lobby, err := lobbyRepo.GetLobbyByID(lobbyID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error fetching lobby: "+err.Error())
+		}
+
 		// Return success
-		lobby.ID = lobbyID
 		return c.Status(fiber.StatusCreated).JSON(lobby)
 	}
 }
+
+// validateLobbyName trims a lobby name and checks that it is not empty or
+// too long, returning the trimmed name
+func validateLobbyName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fiber.NewError(fiber.StatusBadRequest, "Lobby name must not be empty")
+	}
+	if utf8.RuneCountInString(name) > maxLobbyName {
+		return "", fiber.NewError(fiber.StatusBadRequest, "Lobby name must be at most 50 characters")
+	}
+	return name, nil
+}
+
+// validateSettings checks lobby settings, defaulting the post policy to everyone
+func validateSettings(settings *models.LobbySettings) error {
+	switch settings.PostPolicy {
+	case "":
+		settings.PostPolicy = models.PostPolicyEveryone
+	case models.PostPolicyEveryone, models.PostPolicyMembers, models.PostPolicyModerators:
+	default:
+		return fiber.NewError(fiber.StatusBadRequest, "Post policy must be everyone, members or moderators")
+	}
+	if settings.MaxMembers < 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "Max members must not be negative")
+	}
+	if settings.SlowMode < 0 || settings.SlowMode > maxSlowMode {
+		return fiber.NewError(fiber.StatusBadRequest, "Slow mode must be between 0 and 21600 seconds")
+	}
+	if settings.MessageTTL < 0 || settings.MessageTTL > models.MaxMessageTTL {
+		return fiber.NewError(fiber.StatusBadRequest, "Message TTL must be between 0 and 2592000 seconds")
+	}
+
+	return nil
+}
+
+// updateLobbyHandler handles renaming a lobby and changing its topic,
+// description and settings
+func updateLobbyHandler(repos *websocket.Repositories) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		lobbyID, err := requireManageableLobby(c, repos)
+		if err != nil {
+			return err
+		}
+
+		var req models.LobbyUpdateRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+		if req.Name != nil {
+			name, err := validateLobbyName(*req.Name)
+			if err != nil {
+				return err
+			}
+			req.Name = &name
+		}
+		if req.Settings != nil {
+			if err := validateSettings(req.Settings); err != nil {
+				return err
+			}
+		}
+
+		lobby, err := repos.Lobbies.UpdateLobby(lobbyID, req)
+		if errors.Is(err, db.ErrLobbyNameTaken) {
+			return fiber.NewError(fiber.StatusConflict, "Lobby name is already taken")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error updating lobby: "+err.Error())
+		}
+		websocket.SetSettings(lobbyID, lobby.Settings)
+
+		websocket.BroadcastEvent(lobbyID, models.Event{
+			Type: models.EventLobbyUpdated,
+			Data: lobby,
+		})
+
+		return c.JSON(lobby)
+	}
+}
+
+// transferLobbyHandler handles making another user the owner of a lobby
+func transferLobbyHandler(repos *websocket.Repositories) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		lobbyID, err := requireManageableLobby(c, repos)
+		if err != nil {
+			return err
+		}
+
+		var req models.OwnerTransferRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+		exists, err := repos.Users.UsersExist([]int{req.UserID})
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error checking user: "+err.Error())
+		}
+		if !exists {
+			return fiber.NewError(fiber.StatusBadRequest, "User not found")
+		}
+
+		lobby, err := repos.Lobbies.TransferOwnership(lobbyID, req.UserID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error transferring lobby: "+err.Error())
+		}
+
+		websocket.BroadcastEvent(lobbyID, models.Event{
+			Type: models.EventLobbyUpdated,
+			Data: lobby,
+		})
+
+		return c.JSON(lobby)
+	}
+}
+
+// archiveLobbyHandler handles archiving a lobby, making it read-only and
+// hiding it from the default listing, or unarchiving it
+func archiveLobbyHandler(repos *websocket.Repositories, archive bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		lobbyID, err := requireManageableLobby(c, repos)
+		if err != nil {
+			return err
+		}
+
+		if err := repos.Lobbies.SetArchived(lobbyID, archive); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error archiving lobby: "+err.Error())
+		}
+		websocket.SetArchived(lobbyID, archive)
+
+		eventType := models.EventLobbyArchived
+		if !archive {
+			eventType = models.EventLobbyUnarchived
+		}
+		websocket.BroadcastEvent(lobbyID, models.Event{Type: eventType})
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// deleteLobbyHandler handles deleting a lobby. Its messages are deleted
+// unless reassignTo names another lobby to move them to. Connected clients
+// are notified and disconnected.
+func deleteLobbyHandler(repos *websocket.Repositories) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		lobbyID, err := requireManageableLobby(c, repos)
+		if err != nil {
+			return err
+		}
+
+		reassignTo := c.QueryInt("reassignTo")
+		if reassignTo == lobbyID {
+			return fiber.NewError(fiber.StatusBadRequest, "Cannot move messages to the deleted lobby")
+		}
+		if reassignTo > 0 {
+			target, err := repos.Lobbies.GetLobbyByID(reassignTo)
+			if errors.Is(err, db.ErrLobbyNotFound) {
+				return fiber.NewError(fiber.StatusBadRequest, "Lobby to move messages to not found")
+			}
+			if err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "Error fetching lobby: "+err.Error())
+			}
+			if target.Kind == models.LobbyKindDM {
+				return fiber.NewError(fiber.StatusBadRequest, "Cannot move messages to a direct message")
+			}
+
+			// Only lobbies the user may access and moderate can receive the messages
+			userID, err := getUserID(c)
+			if err != nil {
+				return err
+			}
+			allowed, err := repos.Lobbies.CanAccess(reassignTo, userID)
+			if err == nil && allowed {
+				allowed, err = websocket.CanModerate(repos, reassignTo, userID)
+			}
+			if err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "Error checking permissions: "+err.Error())
+			}
+			if !allowed {
+				return fiber.NewError(fiber.StatusForbidden, "Not allowed to move messages to this lobby")
+			}
+		}
+
+		if err := repos.Lobbies.DeleteLobby(lobbyID, reassignTo); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error deleting lobby: "+err.Error())
+		}
+
+		websocket.CloseLobby(lobbyID, models.LobbyDeletedEvent{
+			LobbyID:       lobbyID,
+			MessagesMoved: reassignTo,
+		})
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// requireManageableLobby returns the lobby ID in the route if it is a lobby,
+// not a direct message, and the acting user may manage it
+func requireManageableLobby(c *fiber.Ctx, repos *websocket.Repositories) (int, error) {
+	lobbyID, err := requireModerator(c, repos)
+	if err != nil {
+		return 0, err
+	}
+
+	lobby, err := repos.Lobbies.GetLobbyByID(lobbyID)
+	if errors.Is(err, db.ErrLobbyNotFound) {
+		return 0, fiber.NewError(fiber.StatusNotFound, "Lobby not found")
+	}
+	if err != nil {
+		return 0, fiber.NewError(fiber.StatusInternalServerError, "Error fetching lobby: "+err.Error())
+	}
+	if lobby.Kind == models.LobbyKindDM {
+		return 0, fiber.NewError(fiber.StatusBadRequest, "Direct messages cannot be managed")
+	}
+
+	return lobbyID, nil
+}
+
+// joinLobbyHandler handles joining a public lobby. Private lobbies are
+// joined through invite links.
+func joinLobbyHandler(lobbyRepo *db.LobbyRepository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := getUserID(c)
+		if err != nil {
+			return err
+		}
+		lobbyID, err := getIDParam(c, "id")
+		if err != nil {
+			return err
+		}
+
+		lobby, err := lobbyRepo.GetLobbyByID(lobbyID)
+		if errors.Is(err, db.ErrLobbyNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "Lobby not found")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error fetching lobby: "+err.Error())
+		}
+		if lobby.Kind != models.LobbyKindLobby || lobby.Visibility != models.VisibilityPublic {
+			return fiber.NewError(fiber.StatusForbidden, "An invite is required to join this lobby")
+		}
+
+		err = lobbyRepo.AddMember(lobbyID, userID)
+		if errors.Is(err, db.ErrLobbyFull) {
+			return fiber.NewError(fiber.StatusConflict, "Lobby is full")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error joining lobby: "+err.Error())
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// leaveLobbyHandler handles leaving a lobby, closing the user's connections
+// to it. Owners must transfer the lobby first, and direct messages cannot be
+// left.
+func leaveLobbyHandler(lobbyRepo *db.LobbyRepository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := getUserID(c)
+		if err != nil {
+			return err
+		}
+		lobbyID, err := getIDParam(c, "id")
+		if err != nil {
+			return err
+		}
+
+		lobby, err := lobbyRepo.GetLobbyByID(lobbyID)
+		if errors.Is(err, db.ErrLobbyNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "Lobby not found")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error fetching lobby: "+err.Error())
+		}
+		if lobby.Kind == models.LobbyKindDM {
+			return fiber.NewError(fiber.StatusBadRequest, "Direct messages cannot be left")
+		}
+
+		isOwner, err := lobbyRepo.IsOwner(lobbyID, userID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error checking lobby owner: "+err.Error())
+		}
+		if isOwner {
+			return fiber.NewError(fiber.StatusConflict, "Transfer the lobby to another user before leaving")
+		}
+
+		if err := lobbyRepo.RemoveMember(lobbyID, userID); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error leaving lobby: "+err.Error())
+		}
+		websocket.LeaveLobby(lobbyID, userID)
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// getLobbyMessagesHandler handles getting the message history of a lobby.
+// Deleted messages are included as tombstones.
+func getLobbyMessagesHandler(lobbyRepo *db.LobbyRepository, messageRepo *db.MessageRepository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		lobbyID, err := getIDParam(c, "id")
+		if err != nil {
+			return err
+		}
+		userID := c.QueryInt("userID")
+		if err := requireLobbyAccess(lobbyRepo, lobbyID, userID); err != nil {
+			return err
+		}
+
+		messages, err := messageRepo.GetMessagesByLobbyID(lobbyID, userID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error fetching messages: "+err.Error())
+		}
+
+		return c.JSON(messages)
+	}
+}
+
+// getPinnedMessagesHandler handles getting a lobby's pinned messages, most
+// recently pinned first
+func getPinnedMessagesHandler(lobbyRepo *db.LobbyRepository, messageRepo *db.MessageRepository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		lobbyID, err := getIDParam(c, "id")
+		if err != nil {
+			return err
+		}
+		userID := c.QueryInt("userID")
+		if err := requireLobbyAccess(lobbyRepo, lobbyID, userID); err != nil {
+			return err
+		}
+
+		messages, err := messageRepo.GetPinnedMessages(lobbyID, userID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error fetching pinned messages: "+err.Error())
+		}
+
+		return c.JSON(messages)
+	}
+}
+
+// getLobbyEmojisHandler handles getting the custom emoji of a lobby
+func getLobbyEmojisHandler(lobbyRepo *db.LobbyRepository, reactionRepo *db.ReactionRepository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		lobbyID, err := getIDParam(c, "id")
+		if err != nil {
+			return err
+		}
+		if err := requireLobbyAccess(lobbyRepo, lobbyID, c.QueryInt("userID")); err != nil {
+			return err
+		}
+
+		emojis, err := reactionRepo.GetLobbyEmojis(lobbyID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error fetching emojis: "+err.Error())
+		}
+
+		return c.JSON(emojis)
+	}
+}
+
+// createLobbyEmojiHandler handles a moderator or the lobby owner adding a
+// custom emoji to a lobby
+func createLobbyEmojiHandler(repos *websocket.Repositories, reactionRepo *db.ReactionRepository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		lobbyID, err := requireModerator(c, repos)
+		if err != nil {
+			return err
+		}
+		userID, err := getUserID(c)
+		if err != nil {
+			return err
+		}
+
+		var req models.LobbyEmojiRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+		if !emoji.ValidName(req.Name) {
+			return fiber.NewError(fiber.StatusBadRequest, "Emoji names must be 2-32 lowercase letters, digits or underscores")
+		}
+		if !emoji.ValidURL(req.URL) {
+			return fiber.NewError(fiber.StatusBadRequest, "Emoji URL must be an https URL")
+		}
+
+		emojiID, err := reactionRepo.CreateLobbyEmoji(lobbyID, req.Name, req.URL, userID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error creating emoji: "+err.Error())
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(models.LobbyEmoji{
+			ID:        emojiID,
+			LobbyID:   lobbyID,
+			Name:      req.Name,
+			URL:       req.URL,
+			CreatedBy: userID,
+		})
+	}
+}
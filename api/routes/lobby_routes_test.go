@@ -0,0 +1,207 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/galexander77/chat-app/api/db"
+	"github.com/galexander77/chat-app/api/db/dbtest"
+	"github.com/galexander77/chat-app/api/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// lobbyFixture holds lobbies created for a test, all named with a shared tag
+type lobbyFixture struct {
+	app      *fiber.App
+	tag      string
+	ownerID  int
+	public   []int
+	private  int
+	archived int
+}
+
+// newLobbyFixture creates three public lobbies, a private and an archived
+// one owned by a new user, and an app serving the lobby routes
+func newLobbyFixture(t *testing.T) *lobbyFixture {
+	database := dbtest.Open(t)
+	lobbyRepo := db.NewLobbyRepository(database)
+	f := &lobbyFixture{app: fiber.New(), tag: dbtest.Name("lobby"), ownerID: dbtest.CreateUser(t, database)}
+	RegisterLobbyRoutes(f.app, database)
+
+	create := func(name, visibility string) int {
+		lobbyID, err := lobbyRepo.CreateLobby(f.tag+name, visibility, f.ownerID, models.LobbySettings{PostPolicy: models.PostPolicyEveryone})
+		if err != nil {
+			t.Fatalf("error creating lobby: %v", err)
+		}
+		return lobbyID
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		f.public = append(f.public, create(name, models.VisibilityPublic))
+	}
+	f.private = create("d", models.VisibilityPrivate)
+	f.archived = create("e", models.VisibilityPublic)
+	if err := lobbyRepo.SetArchived(f.archived, true); err != nil {
+		t.Fatalf("error archiving lobby: %v", err)
+	}
+	return f
+}
+
+// get requests a path, returning the response status, the decoded body and
+// the next page cursor
+func (f *lobbyFixture) get(t *testing.T, path string, body interface{}) (int, string) {
+	t.Helper()
+
+	resp, err := f.app.Test(httptest.NewRequest("GET", path, nil))
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == fiber.StatusOK && body != nil {
+		if err := json.NewDecoder(resp.Body).Decode(body); err != nil {
+			t.Fatalf("GET %s: error decoding body: %v", path, err)
+		}
+	}
+	return resp.StatusCode, resp.Header.Get("X-Next-Cursor")
+}
+
+// send sends a JSON body to a path, returning the response status
+func (f *lobbyFixture) send(t *testing.T, method, path string, body interface{}) int {
+	t.Helper()
+
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("error encoding body: %v", err)
+	}
+	req := httptest.NewRequest(method, path, strings.NewReader(string(encoded)))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := f.app.Test(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// names lists the lobbies matching the fixture's tag with extra query
+// parameters, following cursors through every page
+func (f *lobbyFixture) names(t *testing.T, query url.Values) ([]string, int) {
+	t.Helper()
+
+	query.Set("q", f.tag)
+	query.Set("sort", models.LobbySortName)
+	var names []string
+	pages := 0
+	for {
+		var lobbies []models.LobbySummary
+		status, next := f.get(t, "/api/lobbies?"+query.Encode(), &lobbies)
+		if status != fiber.StatusOK {
+			t.Fatalf("listing lobbies returned status %d", status)
+		}
+		pages++
+		for _, lobby := range lobbies {
+			names = append(names, lobby.Name[len(f.tag):])
+		}
+		if next == "" {
+			return names, pages
+		}
+		query.Set("cursor", next)
+	}
+}
+
+func TestGetLobbies(t *testing.T) {
+	f := newLobbyFixture(t)
+
+	tests := []struct {
+		name      string
+		query     url.Values
+		want      []string
+		wantPages int
+	}{
+		{"public only without a user", url.Values{}, []string{"a", "b", "c"}, 1},
+		{"private lobbies for members", url.Values{"userID": {fmt.Sprint(f.ownerID)}}, []string{"a", "b", "c", "d"}, 1},
+		{"archived on request", url.Values{"archived": {"true"}}, []string{"a", "b", "c", "e"}, 1},
+		{"pages", url.Values{"limit": {"2"}}, []string{"a", "b", "c"}, 2},
+		{"pages with private and archived", url.Values{"limit": {"2"}, "userID": {fmt.Sprint(f.ownerID)}, "archived": {"true"}},
+			[]string{"a", "b", "c", "d", "e"}, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, pages := f.names(t, tt.query)
+			if !reflect.DeepEqual(got, tt.want) || pages != tt.wantPages {
+				t.Errorf("lobbies = %q in %d pages, want %q in %d pages", got, pages, tt.want, tt.wantPages)
+			}
+		})
+	}
+}
+
+func TestGetLobby(t *testing.T) {
+	f := newLobbyFixture(t)
+	outsiderID := dbtest.CreateUser(t, dbtest.Open(t))
+
+	tests := []struct {
+		name       string
+		lobbyID    int
+		userID     int
+		wantStatus int
+	}{
+		{"public", f.public[2], 0, fiber.StatusOK},
+		{"archived", f.archived, 0, fiber.StatusOK},
+		{"private to a member", f.private, f.ownerID, fiber.StatusOK},
+		{"private to an outsider", f.private, outsiderID, fiber.StatusForbidden},
+		{"private without a user", f.private, 0, fiber.StatusForbidden},
+		{"missing", f.archived + 1000000, 0, fiber.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lobby models.Lobby
+			status, _ := f.get(t, fmt.Sprintf("/api/lobbies/%d?userID=%d", tt.lobbyID, tt.userID), &lobby)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+			}
+			if status == fiber.StatusOK && lobby.ID != tt.lobbyID {
+				t.Errorf("lobby ID = %d, want %d", lobby.ID, tt.lobbyID)
+			}
+		})
+	}
+}
+
+func TestLobbyNames(t *testing.T) {
+	f := newLobbyFixture(t)
+
+	tests := []struct {
+		name       string
+		lobbyName  string
+		wantStatus int
+	}{
+		{"empty", "", fiber.StatusBadRequest},
+		{"blank", "   ", fiber.StatusBadRequest},
+		{"too long", f.tag + strings.Repeat("x", 51), fiber.StatusBadRequest},
+		{"taken", f.tag + "a", fiber.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := map[string]string{"name": tt.lobbyName}
+			if status := f.send(t, "POST", fmt.Sprintf("/api/lobbies?userID=%d", f.ownerID), body); status != tt.wantStatus {
+				t.Errorf("create status = %d, want %d", status, tt.wantStatus)
+			}
+			if status := f.send(t, "PATCH", fmt.Sprintf("/api/lobbies/%d?userID=%d", f.public[1], f.ownerID), body); status != tt.wantStatus {
+				t.Errorf("update status = %d, want %d", status, tt.wantStatus)
+			}
+		})
+	}
+
+	if status := f.send(t, "PATCH", fmt.Sprintf("/api/lobbies/%d?userID=%d", f.public[1], f.ownerID), map[string]string{"name": " " + f.tag + "f "}); status != fiber.StatusOK {
+		t.Fatalf("renaming status = %d, want %d", status, fiber.StatusOK)
+	}
+	if got, _ := f.names(t, url.Values{}); !reflect.DeepEqual(got, []string{"a", "c", "f"}) {
+		t.Errorf("lobbies after renaming = %q, want trimmed name f", got)
+	}
+}
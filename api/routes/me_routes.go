@@ -0,0 +1,263 @@
+package routes
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/galexander77/chat-app/api/content"
+	"github.com/galexander77/chat-app/api/db"
+	"github.com/galexander77/chat-app/api/models"
+	"github.com/galexander77/chat-app/api/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Pagination limits for list endpoints
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+)
+
+// maxSavedNote is the most characters a saved message's note may have
+const maxSavedNote = 500
+
+// RegisterMeRoutes registers routes for the acting user's own data
+func RegisterMeRoutes(app *fiber.App, database *sql.DB) {
+	mentionRepo := db.NewMentionRepository(database)
+	blockRepo := db.NewBlockRepository(database)
+	userRepo := db.NewUserRepository(database)
+	lobbyRepo := db.NewLobbyRepository(database)
+	messageRepo := db.NewMessageRepository(database)
+	savedRepo := db.NewSavedRepository(database)
+
+	// Me group
+	me := app.Group("/api/me")
+
+	// Routes
+	me.Get("/mentions", getMentionsHandler(mentionRepo))
+	me.Post("/mentions/:id/read", markMentionReadHandler(mentionRepo))
+	me.Get("/blocks", getBlocksHandler(blockRepo))
+	me.Post("/blocks/:id", blockUserHandler(blockRepo, userRepo))
+	me.Delete("/blocks/:id", unblockUserHandler(blockRepo))
+	me.Get("/saved", getSavedHandler(savedRepo))
+	me.Post("/saved/:messageID", saveMessageHandler(lobbyRepo, messageRepo, savedRepo))
+	me.Delete("/saved/:messageID", unsaveMessageHandler(savedRepo))
+}
+
+// getMentionsHandler handles getting the acting user's mention inbox
+func getMentionsHandler(mentionRepo *db.MentionRepository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := getUserID(c)
+		if err != nil {
+			return err
+		}
+
+		limit := c.QueryInt("limit", defaultPageSize)
+		if limit <= 0 || limit > maxPageSize {
+			limit = defaultPageSize
+		}
+
+		mentions, err := mentionRepo.GetMentions(userID, c.QueryBool("unread"), c.QueryInt("before"), limit)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error fetching mentions: "+err.Error())
+		}
+
+		return c.JSON(mentions)
+	}
+}
+
+// markMentionReadHandler handles marking a mention as read
+func markMentionReadHandler(mentionRepo *db.MentionRepository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := getUserID(c)
+		if err != nil {
+			return err
+		}
+		mentionID, err := getIDParam(c, "id")
+		if err != nil {
+			return err
+		}
+
+		err = mentionRepo.MarkMentionRead(mentionID, userID)
+		if errors.Is(err, db.ErrMentionNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "Mention not found")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error updating mention: "+err.Error())
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// getBlocksHandler handles getting the users the acting user has blocked
+func getBlocksHandler(blockRepo *db.BlockRepository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := getUserID(c)
+		if err != nil {
+			return err
+		}
+
+		blocks, err := blockRepo.GetBlocks(userID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error fetching blocks: "+err.Error())
+		}
+
+		return c.JSON(blocks)
+	}
+}
+
+// blockUserHandler handles blocking a user. Their messages stop being
+// delivered to the acting user, and they can no longer message them directly.
+func blockUserHandler(blockRepo *db.BlockRepository, userRepo *db.UserRepository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := getUserID(c)
+		if err != nil {
+			return err
+		}
+		blockedID, err := getIDParam(c, "id")
+		if err != nil {
+			return err
+		}
+		if blockedID == userID {
+			return fiber.NewError(fiber.StatusBadRequest, "Cannot block yourself")
+		}
+
+		exist, err := userRepo.UsersExist([]int{blockedID})
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error checking users: "+err.Error())
+		}
+		if !exist {
+			return fiber.NewError(fiber.StatusNotFound, "User not found")
+		}
+
+		if err := blockRepo.BlockUser(userID, blockedID); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error blocking user: "+err.Error())
+		}
+		websocket.SetBlocked(userID, blockedID, true)
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// unblockUserHandler handles unblocking a user
+func unblockUserHandler(blockRepo *db.BlockRepository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := getUserID(c)
+		if err != nil {
+			return err
+		}
+		blockedID, err := getIDParam(c, "id")
+		if err != nil {
+			return err
+		}
+
+		err = blockRepo.UnblockUser(userID, blockedID)
+		if errors.Is(err, db.ErrBlockNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "User is not blocked")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error unblocking user: "+err.Error())
+		}
+		websocket.SetBlocked(userID, blockedID, false)
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// getSavedHandler handles getting the acting user's saved messages
+func getSavedHandler(savedRepo *db.SavedRepository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := getUserID(c)
+		if err != nil {
+			return err
+		}
+
+		limit := c.QueryInt("limit", defaultPageSize)
+		if limit <= 0 || limit > maxPageSize {
+			limit = defaultPageSize
+		}
+
+		saved, err := savedRepo.GetSavedMessages(userID, c.QueryInt("before"), limit)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error fetching saved messages: "+err.Error())
+		}
+
+		return c.JSON(saved)
+	}
+}
+
+// saveMessageHandler handles saving a message from a lobby the acting user
+// can access, or changing the note of a message they saved
+func saveMessageHandler(lobbyRepo *db.LobbyRepository, messageRepo *db.MessageRepository, savedRepo *db.SavedRepository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := getUserID(c)
+		if err != nil {
+			return err
+		}
+		messageID, err := getIDParam(c, "messageID")
+		if err != nil {
+			return err
+		}
+
+		// The note is optional, so the body may be empty
+		var req models.SaveMessageRequest
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&req); err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+			}
+		}
+		note, err := content.Clean(req.Note, maxSavedNote)
+		if errors.Is(err, content.ErrEmpty) {
+			note, err = "", nil
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid note: "+err.Error())
+		}
+
+		message, err := messageRepo.GetMessageByID(messageID)
+		if errors.Is(err, db.ErrMessageNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "Message not found")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error fetching message: "+err.Error())
+		}
+		if err := requireLobbyAccess(lobbyRepo, message.LobbyID, userID); err != nil {
+			return err
+		}
+		if message.DeletedAt != nil {
+			return fiber.NewError(fiber.StatusNotFound, "Message not found")
+		}
+
+		saved, err := savedRepo.SaveMessage(userID, message, note)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error saving message: "+err.Error())
+		}
+
+		return c.JSON(saved)
+	}
+}
+
+// unsaveMessageHandler handles removing a message from the acting user's
+// saved messages
+func unsaveMessageHandler(savedRepo *db.SavedRepository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := getUserID(c)
+		if err != nil {
+			return err
+		}
+		messageID, err := getIDParam(c, "messageID")
+		if err != nil {
+			return err
+		}
+
+		err = savedRepo.UnsaveMessage(userID, messageID)
+		if errors.Is(err, db.ErrSavedNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "Message is not saved")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error removing saved message: "+err.Error())
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
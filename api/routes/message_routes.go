@@ -0,0 +1,264 @@
+package routes
+
+import (
+	"database/sql"
+	"errors"
+	"net/url"
+
+	"github.com/galexander77/chat-app/api/db"
+	"github.com/galexander77/chat-app/api/models"
+	"github.com/galexander77/chat-app/api/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RegisterMessageRoutes registers message routes
+func RegisterMessageRoutes(app *fiber.App, database *sql.DB) {
+	lobbyRepo := db.NewLobbyRepository(database)
+	messageRepo := db.NewMessageRepository(database)
+	reactionRepo := db.NewReactionRepository(database)
+	repos := &websocket.Repositories{
+		Users:      db.NewUserRepository(database),
+		Lobbies:    lobbyRepo,
+		Messages:   messageRepo,
+		Moderation: db.NewModerationRepository(database),
+		Reports:    db.NewReportRepository(database),
+		Blocks:     db.NewBlockRepository(database),
+	}
+
+	// Message group
+	message := app.Group("/api/messages")
+
+	// Routes
+	message.Delete("/:id", deleteMessageHandler(repos))
+	message.Post("/:id/report", reportMessageHandler(repos))
+	message.Post("/:id/pin", pinMessageHandler(repos, true))
+	message.Delete("/:id/pin", pinMessageHandler(repos, false))
+	message.Get("/:id/thread", getThreadHandler(lobbyRepo, messageRepo))
+	message.Post("/:id/reactions", addReactionHandler(repos, reactionRepo))
+	message.Delete("/:id/reactions/:emoji", removeReactionHandler(repos, reactionRepo))
+}
+
+// deleteMessageHandler handles soft deleting a message. Authors may delete
+// their own messages and moderators and the lobby owner may delete any
+// message, which is recorded in the moderation log.
+func deleteMessageHandler(repos *websocket.Repositories) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := getUserID(c)
+		if err != nil {
+			return err
+		}
+		messageID, err := getIDParam(c, "id")
+		if err != nil {
+			return err
+		}
+
+		message, err := repos.Messages.GetMessageByID(messageID)
+		if errors.Is(err, db.ErrMessageNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "Message not found")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error fetching message: "+err.Error())
+		}
+
+		// Only the author or a moderator may delete a message
+		if message.UserID != userID {
+			isModerator, err := websocket.CanModerate(repos, message.LobbyID, userID)
+			if err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "Error checking permissions: "+err.Error())
+			}
+			if !isModerator {
+				return fiber.NewError(fiber.StatusForbidden, "Not allowed to delete this message")
+			}
+		}
+
+		err = websocket.DeleteMessage(repos, message, userID)
+		if errors.Is(err, db.ErrMessageNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "Message already deleted")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error deleting message: "+err.Error())
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// pinMessageHandler handles a moderator pinning or unpinning a message in its lobby
+func pinMessageHandler(repos *websocket.Repositories, pinned bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := getUserID(c)
+		if err != nil {
+			return err
+		}
+		messageID, err := getIDParam(c, "id")
+		if err != nil {
+			return err
+		}
+
+		message, err := repos.Messages.GetMessageByID(messageID)
+		if errors.Is(err, db.ErrMessageNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "Message not found")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error fetching message: "+err.Error())
+		}
+
+		err = websocket.SetPinned(repos, message, userID, pinned)
+		switch {
+		case errors.Is(err, websocket.ErrNotModerator):
+			return fiber.NewError(fiber.StatusForbidden, "Only moderators may pin messages")
+		case errors.Is(err, websocket.ErrMessageDeleted):
+			return fiber.NewError(fiber.StatusGone, "Message has been deleted")
+		case err != nil:
+			return fiber.NewError(fiber.StatusInternalServerError, "Error updating pin: "+err.Error())
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// reportMessageHandler handles a user reporting a message to the lobby's moderators
+func reportMessageHandler(repos *websocket.Repositories) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := getUserID(c)
+		if err != nil {
+			return err
+		}
+		messageID, err := getIDParam(c, "id")
+		if err != nil {
+			return err
+		}
+
+		var req models.ReportRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+		switch req.Reason {
+		case models.ReportSpam, models.ReportHarassment, models.ReportHate, models.ReportNSFW, models.ReportOther:
+		default:
+			return fiber.NewError(fiber.StatusBadRequest, "Reason must be spam, harassment, hate, nsfw or other")
+		}
+
+		message, err := repos.Messages.GetMessageByID(messageID)
+		if errors.Is(err, db.ErrMessageNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "Message not found")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error fetching message: "+err.Error())
+		}
+		if err := requireLobbyAccess(repos.Lobbies, message.LobbyID, userID); err != nil {
+			return err
+		}
+		if message.UserID == userID {
+			return fiber.NewError(fiber.StatusBadRequest, "Cannot report your own message")
+		}
+		if message.DeletedAt != nil {
+			return fiber.NewError(fiber.StatusNotFound, "Message has been deleted")
+		}
+
+		reportID, err := repos.Reports.CreateReport(message, userID, req.Reason, req.Details)
+		if errors.Is(err, db.ErrAlreadyReported) {
+			return fiber.NewError(fiber.StatusConflict, "You have already reported this message")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error reporting message: "+err.Error())
+		}
+
+		report, err := repos.Reports.GetReport(reportID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error fetching report: "+err.Error())
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(report)
+	}
+}
+
+// getThreadHandler handles getting a message and its replies
+func getThreadHandler(lobbyRepo *db.LobbyRepository, messageRepo *db.MessageRepository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		messageID, err := getIDParam(c, "id")
+		if err != nil {
+			return err
+		}
+
+		userID := c.QueryInt("userID")
+		thread, err := messageRepo.GetThread(messageID, userID)
+		if errors.Is(err, db.ErrMessageNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "Message not found")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error fetching thread: "+err.Error())
+		}
+		if err := requireLobbyAccess(lobbyRepo, thread.Parent.LobbyID, userID); err != nil {
+			return err
+		}
+
+		return c.JSON(thread)
+	}
+}
+
+// addReactionHandler handles adding a reaction to a message
+func addReactionHandler(repos *websocket.Repositories, reactionRepo *db.ReactionRepository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req models.ReactionRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+
+		return updateReaction(c, repos, reactionRepo, req.Emoji, true)
+	}
+}
+
+// removeReactionHandler handles removing a reaction from a message
+func removeReactionHandler(repos *websocket.Repositories, reactionRepo *db.ReactionRepository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		emoji, err := url.PathUnescape(c.Params("emoji"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid emoji")
+		}
+
+		return updateReaction(c, repos, reactionRepo, emoji, false)
+	}
+}
+
+// updateReaction adds or removes the acting user's reaction on the message in the route
+func updateReaction(c *fiber.Ctx, repos *websocket.Repositories, reactionRepo *db.ReactionRepository, emoji string, add bool) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+	messageID, err := getIDParam(c, "id")
+	if err != nil {
+		return err
+	}
+
+	message, err := repos.Messages.GetMessageByID(messageID)
+	if errors.Is(err, db.ErrMessageNotFound) {
+		return fiber.NewError(fiber.StatusNotFound, "Message not found")
+	}
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Error fetching message: "+err.Error())
+	}
+	if err := requireLobbyAccess(repos.Lobbies, message.LobbyID, userID); err != nil {
+		return err
+	}
+
+	// Adding a reaction posts to the lobby, so it is refused like a WebSocket reaction
+	if add {
+		if err := requirePosting(repos, message.LobbyID, userID); err != nil {
+			return err
+		}
+	}
+
+	err = websocket.UpdateReaction(reactionRepo, message, userID, emoji, add)
+	switch {
+	case errors.Is(err, db.ErrInvalidEmoji):
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid emoji")
+	case errors.Is(err, websocket.ErrMessageDeleted):
+		return fiber.NewError(fiber.StatusGone, "Message has been deleted")
+	case err != nil:
+		return fiber.NewError(fiber.StatusInternalServerError, "Error updating reaction: "+err.Error())
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
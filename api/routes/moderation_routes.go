@@ -0,0 +1,122 @@
+package routes
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/galexander77/chat-app/api/db"
+	"github.com/galexander77/chat-app/api/models"
+	"github.com/galexander77/chat-app/api/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RegisterModerationRoutes registers lobby moderation routes
+func RegisterModerationRoutes(app *fiber.App, database *sql.DB) {
+	repos := &websocket.Repositories{
+		Users:      db.NewUserRepository(database),
+		Lobbies:    db.NewLobbyRepository(database),
+		Moderation: db.NewModerationRepository(database),
+	}
+
+	// Moderation group
+	moderation := app.Group("/api/lobbies/:id")
+
+	// Routes
+	moderation.Post("/moderation", moderateHandler(repos))
+	moderation.Get("/moderation/log", getModerationLogHandler(repos))
+	moderation.Get("/restrictions", getRestrictionsHandler(repos))
+}
+
+// moderateHandler handles kicking, banning and muting users in a lobby
+func moderateHandler(repos *websocket.Repositories) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := getUserID(c)
+		if err != nil {
+			return err
+		}
+		lobbyID, err := getIDParam(c, "id")
+		if err != nil {
+			return err
+		}
+
+		var req models.ModerationRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+
+		entry, err := websocket.Moderate(repos, lobbyID, userID, req)
+		switch {
+		case errors.Is(err, websocket.ErrNotModerator):
+			return fiber.NewError(fiber.StatusForbidden, "Not allowed to moderate this lobby")
+		case errors.Is(err, websocket.ErrInvalidAction):
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid moderation action")
+		case errors.Is(err, websocket.ErrInvalidTarget):
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid user")
+		case err != nil:
+			return fiber.NewError(fiber.StatusInternalServerError, "Error applying moderation action: "+err.Error())
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(entry)
+	}
+}
+
+// getModerationLogHandler handles getting a lobby's moderation audit log
+func getModerationLogHandler(repos *websocket.Repositories) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		lobbyID, err := requireModerator(c, repos)
+		if err != nil {
+			return err
+		}
+
+		limit := c.QueryInt("limit", defaultPageSize)
+		if limit <= 0 || limit > maxPageSize {
+			limit = defaultPageSize
+		}
+
+		entries, err := repos.Moderation.GetLog(lobbyID, c.QueryInt("before"), limit)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error fetching moderation log: "+err.Error())
+		}
+
+		return c.JSON(entries)
+	}
+}
+
+// getRestrictionsHandler handles getting the active bans and mutes in a lobby
+func getRestrictionsHandler(repos *websocket.Repositories) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		lobbyID, err := requireModerator(c, repos)
+		if err != nil {
+			return err
+		}
+
+		restrictions, err := repos.Moderation.GetRestrictions(lobbyID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error fetching restrictions: "+err.Error())
+		}
+
+		return c.JSON(restrictions)
+	}
+}
+
+// requireModerator returns the lobby ID in the route if the acting user may moderate it
+func requireModerator(c *fiber.Ctx, repos *websocket.Repositories) (int, error) {
+	userID, err := getUserID(c)
+	if err != nil {
+		return 0, err
+	}
+	lobbyID, err := getIDParam(c, "id")
+	if err != nil {
+		return 0, err
+	}
+
+	allowed, err := websocket.CanModerate(repos, lobbyID, userID)
+	if err != nil {
+		return 0, fiber.NewError(fiber.StatusInternalServerError, "Error checking permissions: "+err.Error())
+	}
+	if !allowed {
+		return 0, fiber.NewError(fiber.StatusForbidden, "Not allowed to moderate this lobby")
+	}
+
+	return lobbyID, nil
+}
@@ -0,0 +1,194 @@
+package routes
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/galexander77/chat-app/api/db"
+	"github.com/galexander77/chat-app/api/models"
+	"github.com/galexander77/chat-app/api/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RegisterReportRoutes registers the moderation review queue routes
+func RegisterReportRoutes(app *fiber.App, database *sql.DB) {
+	repos := &websocket.Repositories{
+		Users:      db.NewUserRepository(database),
+		Lobbies:    db.NewLobbyRepository(database),
+		Messages:   db.NewMessageRepository(database),
+		Moderation: db.NewModerationRepository(database),
+		Reports:    db.NewReportRepository(database),
+		Blocks:     db.NewBlockRepository(database),
+	}
+
+	// Routes
+	app.Get("/api/lobbies/:id/reports", getReportsHandler(repos))
+
+	report := app.Group("/api/reports")
+	report.Post("/:id/claim", claimReportHandler(repos))
+	report.Post("/:id/resolve", resolveReportHandler(repos))
+}
+
+// getReportsHandler handles getting a lobby's review queue, oldest first
+func getReportsHandler(repos *websocket.Repositories) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		lobbyID, err := requireModerator(c, repos)
+		if err != nil {
+			return err
+		}
+
+		status := c.Query("status")
+		switch status {
+		case "", models.ReportOpen, models.ReportClaimed, models.ReportResolved:
+		default:
+			return fiber.NewError(fiber.StatusBadRequest, "Status must be open, claimed or resolved")
+		}
+
+		limit := c.QueryInt("limit", defaultPageSize)
+		if limit <= 0 || limit > maxPageSize {
+			limit = defaultPageSize
+		}
+
+		reports, err := repos.Reports.GetReports(lobbyID, status, c.QueryInt("after"), limit)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error fetching reports: "+err.Error())
+		}
+
+		return c.JSON(reports)
+	}
+}
+
+// claimReportHandler handles a moderator claiming a report so others know it is being handled
+func claimReportHandler(repos *websocket.Repositories) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		report, userID, err := requireReportModerator(c, repos)
+		if err != nil {
+			return err
+		}
+
+		err = repos.Reports.ClaimReport(report.ID, userID)
+		if errors.Is(err, db.ErrReportUnavailable) {
+			return fiber.NewError(fiber.StatusConflict, "Report is resolved or claimed by another moderator")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error claiming report: "+err.Error())
+		}
+
+		report, err = repos.Reports.GetReport(report.ID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error fetching report: "+err.Error())
+		}
+
+		return c.JSON(report)
+	}
+}
+
+// resolveReportHandler handles resolving a report by dismissing it or by
+// deleting the message, muting or banning its author. The report is claimed
+// first so two moderators cannot act on it at once, and the message's other
+// unresolved reports are resolved with it.
+func resolveReportHandler(repos *websocket.Repositories) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		report, userID, err := requireReportModerator(c, repos)
+		if err != nil {
+			return err
+		}
+		if report.Status == models.ReportResolved {
+			return fiber.NewError(fiber.StatusConflict, "Report is already resolved")
+		}
+		if report.ClaimedBy != nil && *report.ClaimedBy != userID {
+			return fiber.NewError(fiber.StatusConflict, "Report is claimed by another moderator")
+		}
+
+		var req models.ReportResolveRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+		if req.Reason == "" {
+			req.Reason = report.Reason
+		}
+		// Checked before claiming so a bad request does not leave the
+		// report claimed
+		switch req.Action {
+		case models.ReportDismiss, models.ModerationDeleteMessage, models.ModerationMute, models.ModerationBan:
+		default:
+			return fiber.NewError(fiber.StatusBadRequest, "Action must be dismiss, delete_message, mute or ban")
+		}
+
+		err = repos.Reports.ClaimReport(report.ID, userID)
+		if errors.Is(err, db.ErrReportUnavailable) {
+			return fiber.NewError(fiber.StatusConflict, "Report is resolved or claimed by another moderator")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error claiming report: "+err.Error())
+		}
+
+		switch req.Action {
+		case models.ReportDismiss:
+		case models.ModerationDeleteMessage:
+			err := websocket.DeleteMessage(repos, &report.Message, userID)
+			if err != nil && !errors.Is(err, db.ErrMessageNotFound) {
+				return fiber.NewError(fiber.StatusInternalServerError, "Error deleting message: "+err.Error())
+			}
+		case models.ModerationMute, models.ModerationBan:
+			_, err := websocket.Moderate(repos, report.LobbyID, userID, models.ModerationRequest{
+				Action:   req.Action,
+				UserID:   report.Message.UserID,
+				Reason:   req.Reason,
+				Duration: req.Duration,
+			})
+			if errors.Is(err, websocket.ErrInvalidTarget) || errors.Is(err, websocket.ErrInvalidAction) {
+				return fiber.NewError(fiber.StatusBadRequest, "Cannot apply this action: "+err.Error())
+			}
+			if err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "Error applying moderation action: "+err.Error())
+			}
+		}
+
+		err = repos.Reports.ResolveReports(report.ID, userID, req.Action)
+		if errors.Is(err, db.ErrReportUnavailable) {
+			return fiber.NewError(fiber.StatusConflict, "Report is resolved or claimed by another moderator")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error resolving report: "+err.Error())
+		}
+
+		report, err = repos.Reports.GetReport(report.ID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error fetching report: "+err.Error())
+		}
+
+		return c.JSON(report)
+	}
+}
+
+// requireReportModerator gets the report named by the id route parameter and
+// checks that the acting user may moderate its lobby
+func requireReportModerator(c *fiber.Ctx, repos *websocket.Repositories) (*models.Report, int, error) {
+	userID, err := getUserID(c)
+	if err != nil {
+		return nil, 0, err
+	}
+	reportID, err := getIDParam(c, "id")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	report, err := repos.Reports.GetReport(reportID)
+	if errors.Is(err, db.ErrReportNotFound) {
+		return nil, 0, fiber.NewError(fiber.StatusNotFound, "Report not found")
+	}
+	if err != nil {
+		return nil, 0, fiber.NewError(fiber.StatusInternalServerError, "Error fetching report: "+err.Error())
+	}
+
+	allowed, err := websocket.CanModerate(repos, report.LobbyID, userID)
+	if err != nil {
+		return nil, 0, fiber.NewError(fiber.StatusInternalServerError, "Error checking permissions: "+err.Error())
+	}
+	if !allowed {
+		return nil, 0, fiber.NewError(fiber.StatusForbidden, "Not allowed to moderate this lobby")
+	}
+
+	return report, userID, nil
+}
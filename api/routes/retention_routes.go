@@ -0,0 +1,149 @@
+package routes
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/galexander77/chat-app/api/db"
+	"github.com/galexander77/chat-app/api/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxRetentionDays is the longest a days retention policy may keep messages
+const maxRetentionDays = 100 * 365
+
+// RegisterRetentionRoutes registers message retention policy routes
+func RegisterRetentionRoutes(app *fiber.App, database *sql.DB) {
+	userRepo := db.NewUserRepository(database)
+	lobbyRepo := db.NewLobbyRepository(database)
+	retentionRepo := db.NewRetentionRepository(database)
+
+	// Routes
+	app.Get("/api/retention", getRetentionPoliciesHandler(userRepo, retentionRepo))
+	app.Put("/api/retention/default", setRetentionPolicyHandler(userRepo, lobbyRepo, retentionRepo, false))
+	app.Put("/api/lobbies/:id/retention", setRetentionPolicyHandler(userRepo, lobbyRepo, retentionRepo, true))
+	app.Delete("/api/lobbies/:id/retention", deleteRetentionPolicyHandler(userRepo, retentionRepo))
+}
+
+// requireAdmin returns the acting user's ID, or a fiber error unless the
+// user has the admin role
+func requireAdmin(c *fiber.Ctx, userRepo *db.UserRepository) (int, error) {
+	userID, err := getUserID(c)
+	if err != nil {
+		return 0, err
+	}
+
+	isAdmin, err := userRepo.IsAdmin(userID)
+	if err != nil {
+		return 0, fiber.NewError(fiber.StatusInternalServerError, "Error checking permissions: "+err.Error())
+	}
+	if !isAdmin {
+		return 0, fiber.NewError(fiber.StatusForbidden, "Only admins may manage retention policies")
+	}
+
+	return userID, nil
+}
+
+// getRetentionPoliciesHandler handles an admin listing the default retention
+// policy and the lobbies with their own
+func getRetentionPoliciesHandler(userRepo *db.UserRepository, retentionRepo *db.RetentionRepository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if _, err := requireAdmin(c, userRepo); err != nil {
+			return err
+		}
+
+		policies, err := retentionRepo.GetPolicies()
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error fetching retention policies: "+err.Error())
+		}
+
+		return c.JSON(policies)
+	}
+}
+
+// setRetentionPolicyHandler handles an admin setting the default retention
+// policy or, if forLobby is set, the policy of the lobby in the route
+func setRetentionPolicyHandler(userRepo *db.UserRepository, lobbyRepo *db.LobbyRepository, retentionRepo *db.RetentionRepository, forLobby bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := requireAdmin(c, userRepo)
+		if err != nil {
+			return err
+		}
+
+		var lobbyID int
+		if forLobby {
+			if lobbyID, err = getIDParam(c, "id"); err != nil {
+				return err
+			}
+			_, err := lobbyRepo.GetLobbyByID(lobbyID)
+			if errors.Is(err, db.ErrLobbyNotFound) {
+				return fiber.NewError(fiber.StatusNotFound, "Lobby not found")
+			}
+			if err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "Error fetching lobby: "+err.Error())
+			}
+		}
+
+		var req models.RetentionRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+		if err := validateRetention(&req); err != nil {
+			return err
+		}
+
+		policy, err := retentionRepo.SetPolicy(lobbyID, req, userID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error setting retention policy: "+err.Error())
+		}
+
+		return c.JSON(policy)
+	}
+}
+
+// validateRetention checks a retention policy, clearing the amounts the
+// policy's kind does not use
+func validateRetention(req *models.RetentionRequest) error {
+	switch req.Keep {
+	case models.RetentionForever:
+		req.Days, req.Messages = 0, 0
+	case models.RetentionDays:
+		if req.Days <= 0 || req.Days > maxRetentionDays {
+			return fiber.NewError(fiber.StatusBadRequest, "Days must be between 1 and 36500")
+		}
+		req.Messages = 0
+	case models.RetentionMessages:
+		if req.Messages <= 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "Messages must be positive")
+		}
+		req.Days = 0
+	default:
+		return fiber.NewError(fiber.StatusBadRequest, "Keep must be forever, days or messages")
+	}
+
+	return nil
+}
+
+// deleteRetentionPolicyHandler handles an admin removing a lobby's own
+// retention policy so the default policy applies to it again
+func deleteRetentionPolicyHandler(userRepo *db.UserRepository, retentionRepo *db.RetentionRepository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if _, err := requireAdmin(c, userRepo); err != nil {
+			return err
+		}
+		lobbyID, err := getIDParam(c, "id")
+		if err != nil {
+			return err
+		}
+
+		err = retentionRepo.DeleteLobbyPolicy(lobbyID)
+		if errors.Is(err, db.ErrRetentionNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "Lobby has no retention policy of its own")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error deleting retention policy: "+err.Error())
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
@@ -0,0 +1,231 @@
+package routes
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/galexander77/chat-app/api/content"
+	"github.com/galexander77/chat-app/api/db"
+	"github.com/galexander77/chat-app/api/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Limits on scheduled messages and reminders
+const (
+	maxScheduleAhead = 365 * 24 * time.Hour
+	maxReminderNote  = 500
+	// maxPendingScheduled is how many messages a user may have waiting to be
+	// sent to one lobby, so scheduling cannot be used to flood it
+	maxPendingScheduled = 25
+	// maxPendingReminders is how many reminders a user may have waiting
+	// across every lobby
+	maxPendingReminders = 100
+)
+
+// RegisterScheduledRoutes registers scheduled message and reminder routes
+func RegisterScheduledRoutes(app *fiber.App, database *sql.DB, maxMessageLength int) {
+	lobbyRepo := db.NewLobbyRepository(database)
+	messageRepo := db.NewMessageRepository(database)
+	scheduledRepo := db.NewScheduledRepository(database)
+
+	// Routes
+	app.Post("/api/lobbies/:id/scheduled", scheduleMessageHandler(lobbyRepo, messageRepo, scheduledRepo, maxMessageLength))
+	app.Post("/api/messages/:id/reminders", createReminderHandler(lobbyRepo, messageRepo, scheduledRepo))
+	app.Get("/api/me/scheduled", getScheduledHandler(scheduledRepo))
+	app.Delete("/api/me/scheduled/:id", cancelScheduledHandler(scheduledRepo))
+}
+
+// validateSendAt returns a fiber error unless a scheduled time is in the
+// future and not too far ahead
+func validateSendAt(sendAt time.Time) error {
+	if sendAt.IsZero() {
+		return fiber.NewError(fiber.StatusBadRequest, "Send at is required")
+	}
+	now := time.Now()
+	if !sendAt.After(now) {
+		return fiber.NewError(fiber.StatusBadRequest, "Send at must be in the future")
+	}
+	if sendAt.After(now.Add(maxScheduleAhead)) {
+		return fiber.NewError(fiber.StatusBadRequest, "Send at must be within a year")
+	}
+
+	return nil
+}
+
+// scheduleMessageHandler handles scheduling a message, or a thread reply,
+// to be sent to a lobby later. Filters, mutes and the lobby's post policy
+// are applied when it is sent.
+func scheduleMessageHandler(lobbyRepo *db.LobbyRepository, messageRepo *db.MessageRepository, scheduledRepo *db.ScheduledRepository, maxMessageLength int) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := getUserID(c)
+		if err != nil {
+			return err
+		}
+		lobbyID, err := getIDParam(c, "id")
+		if err != nil {
+			return err
+		}
+		if err := requireLobbyAccess(lobbyRepo, lobbyID, userID); err != nil {
+			return err
+		}
+
+		var req models.ScheduleMessageRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+		cleaned, err := content.Clean(req.Content, maxMessageLength)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid content: "+err.Error())
+		}
+		if err := validateSendAt(req.SendAt); err != nil {
+			return err
+		}
+
+		item := models.ScheduledMessage{
+			Kind:    models.ScheduledKindMessage,
+			UserID:  userID,
+			LobbyID: lobbyID,
+			Content: cleaned,
+			SendAt:  req.SendAt,
+		}
+
+		// Replies must be to a top-level message in the same lobby
+		if req.ParentID != 0 {
+			parent, err := messageRepo.GetMessageByID(req.ParentID)
+			if errors.Is(err, db.ErrMessageNotFound) {
+				return fiber.NewError(fiber.StatusBadRequest, "Parent message not found")
+			}
+			if err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "Error fetching parent message: "+err.Error())
+			}
+			if parent.LobbyID != lobbyID || parent.ParentID != nil || parent.DeletedAt != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "Invalid parent message")
+			}
+			item.ParentID = &req.ParentID
+		}
+
+		scheduled, err := scheduledRepo.CreateScheduled(item, maxPendingScheduled)
+		if errors.Is(err, db.ErrTooManyScheduled) {
+			return fiber.NewError(fiber.StatusTooManyRequests, "Too many scheduled messages in this lobby")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error scheduling message: "+err.Error())
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(scheduled)
+	}
+}
+
+// createReminderHandler handles setting a personal reminder about a message
+func createReminderHandler(lobbyRepo *db.LobbyRepository, messageRepo *db.MessageRepository, scheduledRepo *db.ScheduledRepository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := getUserID(c)
+		if err != nil {
+			return err
+		}
+		messageID, err := getIDParam(c, "id")
+		if err != nil {
+			return err
+		}
+
+		message, err := messageRepo.GetMessageByID(messageID)
+		if errors.Is(err, db.ErrMessageNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "Message not found")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error fetching message: "+err.Error())
+		}
+		if err := requireLobbyAccess(lobbyRepo, message.LobbyID, userID); err != nil {
+			return err
+		}
+
+		var req models.ReminderRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+		note, err := content.Clean(req.Note, maxReminderNote)
+		if errors.Is(err, content.ErrEmpty) {
+			note, err = "", nil
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid note: "+err.Error())
+		}
+		if err := validateSendAt(req.SendAt); err != nil {
+			return err
+		}
+
+		reminder, err := scheduledRepo.CreateScheduled(models.ScheduledMessage{
+			Kind:      models.ScheduledKindReminder,
+			UserID:    userID,
+			LobbyID:   message.LobbyID,
+			Content:   note,
+			MessageID: &messageID,
+			SendAt:    req.SendAt,
+		}, maxPendingReminders)
+		if errors.Is(err, db.ErrTooManyScheduled) {
+			return fiber.NewError(fiber.StatusTooManyRequests, "Too many pending reminders")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error setting reminder: "+err.Error())
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(reminder)
+	}
+}
+
+// getScheduledHandler handles getting the acting user's scheduled messages
+// and reminders, pending ones unless another status is asked for
+func getScheduledHandler(scheduledRepo *db.ScheduledRepository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := getUserID(c)
+		if err != nil {
+			return err
+		}
+
+		status := c.Query("status", models.ScheduledPending)
+		switch status {
+		case models.ScheduledPending, models.ScheduledSent, models.ScheduledFailed:
+		default:
+			return fiber.NewError(fiber.StatusBadRequest, "Status must be pending, sent or failed")
+		}
+		kind := c.Query("kind")
+		switch kind {
+		case "", models.ScheduledKindMessage, models.ScheduledKindReminder:
+		default:
+			return fiber.NewError(fiber.StatusBadRequest, "Kind must be message or reminder")
+		}
+
+		items, err := scheduledRepo.GetScheduled(userID, status, kind)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error fetching scheduled items: "+err.Error())
+		}
+
+		return c.JSON(items)
+	}
+}
+
+// cancelScheduledHandler handles canceling one of the acting user's pending
+// scheduled messages or reminders
+func cancelScheduledHandler(scheduledRepo *db.ScheduledRepository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := getUserID(c)
+		if err != nil {
+			return err
+		}
+		id, err := getIDParam(c, "id")
+		if err != nil {
+			return err
+		}
+
+		err = scheduledRepo.CancelScheduled(id, userID)
+		if errors.Is(err, db.ErrScheduledNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "No pending scheduled item with this ID")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error canceling scheduled item: "+err.Error())
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
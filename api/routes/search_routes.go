@@ -0,0 +1,73 @@
+package routes
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/galexander77/chat-app/api/db"
+	"github.com/galexander77/chat-app/api/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RegisterSearchRoutes registers search routes
+func RegisterSearchRoutes(app *fiber.App, database *sql.DB) {
+	lobbyRepo := db.NewLobbyRepository(database)
+	searchRepo := db.NewSearchRepository(database)
+
+	// Routes
+	app.Get("/api/search/messages", searchMessagesHandler(lobbyRepo, searchRepo))
+}
+
+// searchMessagesHandler handles full-text search of the messages the acting
+// user can read. Results can be narrowed to a lobby, an author and a time
+// range. The cursor of the next page is returned in the X-Next-Cursor header.
+func searchMessagesHandler(lobbyRepo *db.LobbyRepository, searchRepo *db.SearchRepository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := getUserID(c)
+		if err != nil {
+			return err
+		}
+
+		opts := models.MessageSearchOptions{
+			UserID:  userID,
+			Query:   strings.TrimSpace(c.Query("q")),
+			LobbyID: c.QueryInt("lobby"),
+			FromID:  c.QueryInt("from"),
+			Cursor:  c.Query("cursor"),
+			Limit:   c.QueryInt("limit", defaultPageSize),
+		}
+		if opts.Query == "" {
+			return fiber.NewError(fiber.StatusBadRequest, "Search query is required")
+		}
+		if opts.Limit <= 0 || opts.Limit > maxPageSize {
+			opts.Limit = defaultPageSize
+		}
+		if opts.Before, err = getTimeQuery(c, "before"); err != nil {
+			return err
+		}
+		if opts.After, err = getTimeQuery(c, "after"); err != nil {
+			return err
+		}
+
+		if opts.LobbyID != 0 {
+			if err := requireLobbyAccess(lobbyRepo, opts.LobbyID, userID); err != nil {
+				return err
+			}
+		}
+
+		results, next, err := searchRepo.SearchMessages(opts)
+		if errors.Is(err, db.ErrInvalidCursor) {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid cursor")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error searching messages: "+err.Error())
+		}
+
+		if next != "" {
+			c.Set("X-Next-Cursor", next)
+		}
+
+		return c.JSON(results)
+	}
+}
@@ -5,16 +5,33 @@ import (
 	"log"
 	"strconv"
 
+	"github.com/galexander77/chat-app/api/config"
 	"github.com/galexander77/chat-app/api/db"
+	"github.com/galexander77/chat-app/api/filter"
 	"github.com/galexander77/chat-app/api/websocket"
 	"github.com/gofiber/fiber/v2"
 	fiberwebsocket "github.com/gofiber/websocket/v2"
 )
 
 // RegisterWebSocketRoutes registers WebSocket routes
-func RegisterWebSocketRoutes(app *fiber.App, database *sql.DB) {
+func RegisterWebSocketRoutes(app *fiber.App, database *sql.DB, cfg config.WebSocketConfig, filters filter.Chain) {
+	websocket.Configure(cfg)
+	websocket.SetFilters(filters)
+
 	userRepo := db.NewUserRepository(database)
-	messageRepo := db.NewMessageRepository(database)
+	lobbyRepo := db.NewLobbyRepository(database)
+	moderationRepo := db.NewModerationRepository(database)
+	repos := &websocket.Repositories{
+		Users:       userRepo,
+		Lobbies:     lobbyRepo,
+		Messages:    db.NewMessageRepository(database),
+		Reactions:   db.NewReactionRepository(database),
+		Mentions:    db.NewMentionRepository(database),
+		Moderation:  moderationRepo,
+		Reports:     db.NewReportRepository(database),
+		Blocks:      db.NewBlockRepository(database),
+		Attachments: db.NewAttachmentRepository(database),
+	}
 
 	// WebSocket middleware
 	app.Use("/api/ws", func(c *fiber.Ctx) error {
@@ -27,7 +44,7 @@ func RegisterWebSocketRoutes(app *fiber.App, database *sql.DB) {
 	})
 
 	// WebSocket route
-	app.Get("/api/ws/:lobbyID", fiberwebsocket.New(func(c *fiberwebsocket.Conn) {
+	app.Get("/api/ws/:lobbyID", authorizeLobbyConnection(lobbyRepo), fiberwebsocket.New(func(c *fiberwebsocket.Conn) {
 		// Get lobby ID from params
 		lobbyID := c.Params("lobbyID")
 
@@ -58,6 +75,27 @@ func RegisterWebSocketRoutes(app *fiber.App, database *sql.DB) {
 		}
 
 		// Handle WebSocket connection
-		websocket.HandleFiberConnection(c, lobbyIDInt, userIDInt, username, messageRepo)
+		websocket.HandleFiberConnection(c, lobbyIDInt, userIDInt, username, repos)
 	}))
 }
+
+// authorizeLobbyConnection rejects connections from users who may not access
+// the lobby or are banned from it before the WebSocket upgrade
+func authorizeLobbyConnection(lobbyRepo *db.LobbyRepository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		lobbyID, err := getIDParam(c, "lobbyID")
+		if err != nil {
+			return err
+		}
+		userID, err := getUserID(c)
+		if err != nil {
+			return err
+		}
+
+		if err := requireLobbyAccess(lobbyRepo, lobbyID, userID); err != nil {
+			return err
+		}
+
+		return c.Next()
+	}
+}
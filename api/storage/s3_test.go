@@ -0,0 +1,218 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/galexander77/chat-app/api/config"
+)
+
+const (
+	testAccessKey = "AKIDEXAMPLE"
+	testSecretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	testRegion    = "eu-west-1"
+)
+
+// fakeS3 is an in-memory S3 service that verifies each request's SigV4
+// signature independently of the signer under test, recording why it
+// rejected any request
+type fakeS3 struct {
+	mutex    sync.Mutex
+	objects  map[string][]byte
+	rejected []string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if err := f.verify(r); err != nil {
+		f.rejected = append(f.rejected, r.Method+" "+r.RequestURI+": "+err.Error())
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	path, _, _ := strings.Cut(r.RequestURI, "?")
+	switch r.Method {
+	case http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.objects[path] = body
+	case http.MethodGet:
+		body, ok := f.objects[path]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Write(body)
+	case http.MethodDelete:
+		delete(f.objects, path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// verify recomputes a request's signature from what was received, following
+// the SigV4 specification step by step
+func (f *fakeS3) verify(r *http.Request) error {
+	auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ")
+	if !ok {
+		return errors.New("missing SigV4 authorization")
+	}
+	fields := make(map[string]string)
+	for _, field := range strings.Split(auth, ", ") {
+		name, value, _ := strings.Cut(field, "=")
+		fields[name] = value
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	requestTime, err := time.Parse("20060102T150405Z", amzDate)
+	if err != nil {
+		return errors.New("invalid X-Amz-Date")
+	}
+	if d := time.Since(requestTime); d > 15*time.Minute || d < -15*time.Minute {
+		return errors.New("request time too skewed")
+	}
+	scope := amzDate[:8] + "/" + testRegion + "/s3/aws4_request"
+	if fields["Credential"] != testAccessKey+"/"+scope {
+		return errors.New("unexpected credential " + fields["Credential"])
+	}
+
+	signed := strings.Split(fields["SignedHeaders"], ";")
+	if !sort.StringsAreSorted(signed) || !contains(signed, "host") || !contains(signed, "x-amz-date") ||
+		!contains(signed, "x-amz-content-sha256") {
+		return errors.New("unexpected signed headers " + fields["SignedHeaders"])
+	}
+	var headers strings.Builder
+	for _, name := range signed {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		headers.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	path, query, _ := strings.Cut(r.RequestURI, "?")
+	canonicalRequest := r.Method + "\n" + path + "\n" + query + "\n" + headers.String() + "\n" +
+		fields["SignedHeaders"] + "\n" + r.Header.Get("X-Amz-Content-Sha256")
+	digest := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(digest[:])
+
+	key := []byte("AWS4" + testSecretKey)
+	for _, part := range []string{amzDate[:8], testRegion, "s3", "aws4_request"} {
+		key = sum(key, part)
+	}
+	if want := hex.EncodeToString(sum(key, stringToSign)); !hmac.Equal([]byte(fields["Signature"]), []byte(want)) {
+		return errors.New("signature does not match")
+	}
+	return nil
+}
+
+// sum computes an HMAC-SHA256
+func sum(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// contains reports whether a list holds a value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// newTestS3 starts a fake S3 service and an S3 storage using it, signing
+// with secretKey
+func newTestS3(t *testing.T, secretKey string) (*S3, *fakeS3) {
+	fake := &fakeS3{objects: make(map[string][]byte)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	s3, err := NewS3(config.StorageConfig{
+		S3Endpoint:        server.URL + "/base/",
+		S3Region:          testRegion,
+		S3Bucket:          "chatapp",
+		S3AccessKeyID:     testAccessKey,
+		S3SecretAccessKey: secretKey,
+	})
+	if err != nil {
+		t.Fatalf("NewS3() error = %v", err)
+	}
+	return s3, fake
+}
+
+func TestS3SignedRequests(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+		path string
+	}{
+		{"plain key", "lobbies/1/abc123", "/base/chatapp/lobbies/1/abc123"},
+		{"key with reserved characters", "lobbies/1/a b+c=d&e", "/base/chatapp/lobbies/1/a%20b%2Bc%3Dd%26e"},
+		{"key with unreserved characters", "lobbies/1/a-b_c.d~e", "/base/chatapp/lobbies/1/a-b_c.d~e"},
+		{"non-ASCII key", "lobbies/1/café", "/base/chatapp/lobbies/1/caf%C3%A9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s3, fake := newTestS3(t, testSecretKey)
+			content := "hello " + tt.key
+
+			if err := s3.Put(tt.key, strings.NewReader(content), int64(len(content)), "text/plain"); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+			if _, ok := fake.objects[tt.path]; !ok {
+				t.Fatalf("object not stored at %s", tt.path)
+			}
+
+			body, err := s3.Open(tt.key)
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			got, err := io.ReadAll(body)
+			body.Close()
+			if err != nil || string(got) != content {
+				t.Errorf("Open() read %q, %v, want %q", got, err, content)
+			}
+
+			if err := s3.Delete(tt.key); err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+			if _, err := s3.Open(tt.key); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Open() after Delete() error = %v, want %v", err, ErrNotFound)
+			}
+			if len(fake.rejected) > 0 {
+				t.Errorf("rejected requests: %q", fake.rejected)
+			}
+		})
+	}
+}
+
+func TestS3WrongSecret(t *testing.T) {
+	s3, fake := newTestS3(t, "wrong")
+
+	if err := s3.Put("lobbies/1/abc", strings.NewReader("x"), 1, "text/plain"); err == nil {
+		t.Error("Put() with a wrong secret succeeded, want an error")
+	}
+	if len(fake.rejected) != 1 || !strings.HasSuffix(fake.rejected[0], "signature does not match") {
+		t.Errorf("rejected requests: %q, want one mismatched signature", fake.rejected)
+	}
+}
@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/galexander77/chat-app/api/config"
+)
+
+// ErrNotFound is returned when an object does not exist
+var ErrNotFound = errors.New("object not found")
+
+// Storage stores uploaded files as objects addressed by slash-separated keys
+type Storage interface {
+	// Put stores size bytes read from r under key, replacing any existing object
+	Put(key string, r io.Reader, size int64, contentType string) error
+	// Open opens the object stored under key for reading
+	Open(key string) (io.ReadCloser, error)
+	// Delete removes the object stored under key. Deleting a missing object is not an error.
+	Delete(key string) error
+}
+
+// Storage backends
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+// New creates the storage backend selected by the configuration
+func New(cfg config.StorageConfig) (Storage, error) {
+	switch cfg.Backend {
+	case BackendLocal:
+		return NewLocal(cfg.Dir)
+	case BackendS3:
+		return NewS3(cfg)
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", cfg.Backend)
+	}
+}
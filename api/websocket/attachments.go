@@ -0,0 +1,25 @@
+package websocket
+
+import (
+	"log"
+
+	"github.com/galexander77/chat-app/api/models"
+)
+
+// maxAttachments is the most attachments a chat message may have
+const maxAttachments = 10
+
+// loadAttachments fills in the attachments of a message saved with some,
+// so they are included when it is broadcast
+func loadAttachments(repos *Repositories, message *models.Message, attachmentIDs []int) {
+	if len(attachmentIDs) == 0 {
+		return
+	}
+
+	attachments, err := repos.Attachments.GetMessageAttachments(message.ID)
+	if err != nil {
+		log.Println("Error fetching attachments:", err)
+		return
+	}
+	message.Attachments = attachments
+}
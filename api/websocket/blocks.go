@@ -0,0 +1,81 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/galexander77/chat-app/api/models"
+)
+
+// loadBlocks sets the users a newly connected client has blocked from the database
+func loadBlocks(repos *Repositories, c *client) {
+	blockedIDs, err := repos.Blocks.GetBlockedIDs(c.userID)
+	if err != nil {
+		log.Println("Error fetching blocks:", err)
+		return
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for _, id := range blockedIDs {
+		c.blocked[id] = true
+	}
+}
+
+// SetBlocked blocks or unblocks a user on every connection of the blocker
+func SetBlocked(blockerID, blockedID int, isBlocked bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for _, clients := range lobbies {
+		for _, c := range clients {
+			if c.userID != blockerID {
+				continue
+			}
+			if isBlocked {
+				c.blocked[blockedID] = true
+			} else {
+				delete(c.blocked, blockedID)
+			}
+		}
+	}
+}
+
+// blockedInDM reports whether a client is connected to a direct message
+// conversation in which another member has blocked them
+func blockedInDM(repos *Repositories, c *client) bool {
+	mutex.Lock()
+	isDM := direct[c.lobbyID]
+	mutex.Unlock()
+
+	if !isDM {
+		return false
+	}
+
+	blocked, err := repos.Blocks.IsBlockedByMember(c.lobbyID, c.userID)
+	if err != nil {
+		log.Println("Error checking blocks:", err)
+		return true
+	}
+	return blocked
+}
+
+// broadcastMessage sends a chat message to all clients in a lobby except
+// those who blocked its author
+func broadcastMessage(lobbyID int, msg models.Message) {
+	msgJSON, err := json.Marshal(msg)
+	if err != nil {
+		log.Println("Error marshaling message:", err)
+		return
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for conn, c := range lobbies[lobbyID] {
+		if !c.blocked[msg.UserID] {
+			writeLocked(lobbyID, conn, msgJSON)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package websocket
+
+import (
+	"time"
+
+	"github.com/galexander77/chat-app/api/models"
+)
+
+// expiresAt gets when a message sent to a lobby at sentAt expires, or nil
+// if it is kept. A message's own TTL can only shorten the lobby's.
+func expiresAt(lobbyID, ttl int, sentAt time.Time) *time.Time {
+	mutex.Lock()
+	lobbyTTL := settings[lobbyID].MessageTTL
+	mutex.Unlock()
+
+	if lobbyTTL > 0 && (ttl <= 0 || ttl > lobbyTTL) {
+		ttl = lobbyTTL
+	}
+	if ttl <= 0 {
+		return nil
+	}
+
+	expires := sentAt.Add(time.Duration(ttl) * time.Second)
+	return &expires
+}
+
+// NotifyExpired tells the lobbies of expired messages that they are gone
+func NotifyExpired(messages []models.Message) {
+	for _, message := range messages {
+		BroadcastEvent(message.LobbyID, models.Event{
+			Type: models.EventMessageExpired,
+			Data: models.MessageExpiredEvent{MessageID: message.ID, ParentID: message.ParentID},
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package websocket
+
+import (
+	"log"
+	"time"
+
+	"github.com/galexander77/chat-app/api/models"
+)
+
+// loadLobby sets a lobby's read-only state and settings from the database
+func loadLobby(repos *Repositories, lobbyID int) {
+	lobby, err := repos.Lobbies.GetLobbyByID(lobbyID)
+	if err != nil {
+		log.Println("Error fetching lobby:", err)
+		return
+	}
+	SetArchived(lobbyID, lobby.ArchivedAt != nil)
+	SetSettings(lobbyID, lobby.Settings)
+
+	mutex.Lock()
+	direct[lobbyID] = lobby.Kind == models.LobbyKindDM
+	mutex.Unlock()
+}
+
+// SetArchived marks a lobby as read-only, or writable again, for connected clients
+func SetArchived(lobbyID int, isArchived bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if isArchived {
+		archived[lobbyID] = true
+	} else {
+		delete(archived, lobbyID)
+	}
+}
+
+// isArchived reports whether a lobby is read-only
+func isArchived(lobbyID int) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	return archived[lobbyID]
+}
+
+// SetSettings updates the settings enforced on a lobby's connections
+func SetSettings(lobbyID int, lobbySettings models.LobbySettings) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	settings[lobbyID] = lobbySettings
+}
+
+// mayPost reports whether a client may send a chat message now under its
+// lobby's post policy and slow mode, sending it an error event if not.
+// Moderators and the owner are exempt.
+func mayPost(repos *Repositories, c *client) bool {
+	mutex.Lock()
+	lobbySettings := settings[c.lobbyID]
+	mutex.Unlock()
+
+	restricted := lobbySettings.PostPolicy == models.PostPolicyMembers ||
+		lobbySettings.PostPolicy == models.PostPolicyModerators
+	if !restricted && lobbySettings.SlowMode <= 0 {
+		return true
+	}
+
+	allowed, err := CanModerate(repos, c.lobbyID, c.userID)
+	if err != nil {
+		log.Println("Error checking permissions:", err)
+		return false
+	}
+	if allowed {
+		return true
+	}
+
+	switch lobbySettings.PostPolicy {
+	case models.PostPolicyModerators:
+		sendError(c, models.ErrorEvent{Code: models.ErrorNotAllowed, Message: "Only moderators may post in this lobby"})
+		return false
+	case models.PostPolicyMembers:
+		isMember, err := repos.Lobbies.IsMember(c.lobbyID, c.userID)
+		if err != nil {
+			log.Println("Error checking lobby membership:", err)
+			return false
+		}
+		if !isMember {
+			sendError(c, models.ErrorEvent{Code: models.ErrorNotAllowed, Message: "Only members may post in this lobby"})
+			return false
+		}
+	}
+
+	if wait := slowModeWait(c, time.Duration(lobbySettings.SlowMode)*time.Second); wait > 0 {
+		sendRetryError(c, models.ErrorSlowMode, "Slow mode is enabled", wait)
+		return false
+	}
+	return true
+}
+
+// LeaveLobby closes a user's connections to a lobby they left, so they
+// stop receiving its messages
+func LeaveLobby(lobbyID, userID int) {
+	disconnectUser(lobbyID, userID, "You left this lobby")
+}
+
+// CloseLobby notifies the clients of a deleted lobby and disconnects them
+func CloseLobby(lobbyID int, event models.LobbyDeletedEvent) {
+	BroadcastEvent(lobbyID, models.Event{
+		Type: models.EventLobbyDeleted,
+		Data: event,
+	})
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for conn := range lobbies[lobbyID] {
+		closeLocked(lobbyID, conn, "Lobby deleted")
+	}
+	delete(lobbies, lobbyID)
+	delete(archived, lobbyID)
+	delete(direct, lobbyID)
+	delete(settings, lobbyID)
+	for key := range buckets {
+		if key.lobbyID == lobbyID {
+			delete(buckets, key)
+		}
+	}
+	for key := range lastPosted {
+		if key.lobbyID == lobbyID {
+			delete(lastPosted, key)
+		}
+	}
+}
+
+// OnlineCount counts the distinct users connected to a lobby
+func OnlineCount(lobbyID int) int {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	users := make(map[int]bool)
+	for _, c := range lobbies[lobbyID] {
+		users[c.userID] = true
+	}
+	return len(users)
+}
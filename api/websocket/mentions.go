@@ -0,0 +1,142 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/galexander77/chat-app/api/models"
+)
+
+// mentionPattern matches @username tokens that are not part of a word or email address
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@])@([\w.-]+)`)
+
+// parseMentions extracts mentioned usernames and the @here/@everyone flags from message content
+func parseMentions(content string) (usernames []string, here, everyone bool) {
+	seen := make(map[string]bool)
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		name := strings.TrimRight(match[1], ".-")
+		switch {
+		case name == "here":
+			here = true
+		case name == "everyone":
+			everyone = true
+		case name != "" && !seen[name]:
+			seen[name] = true
+			usernames = append(usernames, name)
+		}
+	}
+	return usernames, here, everyone
+}
+
+// handleMentions records the mentions in a saved message and notifies the
+// mentioned users wherever they are connected
+func handleMentions(repos *Repositories, message models.Message) {
+	usernames, here, everyone := parseMentions(message.Content)
+	if len(usernames) == 0 && !here && !everyone {
+		return
+	}
+
+	// Direct mentions take precedence over @here and @everyone for the same user
+	resolved, err := repos.Users.GetUserIDsByUsernames(usernames)
+	if err != nil {
+		log.Println("Error resolving mentions:", err)
+		return
+	}
+	var direct []int
+	for _, id := range resolved {
+		direct = append(direct, id)
+	}
+	notifyMentions(repos, message, models.MentionUser, direct)
+
+	if here || everyone {
+		notifyMentions(repos, message, models.MentionHere, onlineUserIDs(message.LobbyID))
+	}
+	if everyone {
+		participants, err := repos.Mentions.GetLobbyParticipantIDs(message.LobbyID)
+		if err != nil {
+			log.Println("Error fetching lobby participants:", err)
+			return
+		}
+		notifyMentions(repos, message, models.MentionEveryone, participants)
+	}
+}
+
+// notifyMentions stores mentions of a kind and sends a notification to each newly mentioned user
+func notifyMentions(repos *Repositories, message models.Message, kind string, userIDs []int) {
+	// Users are not notified of their own messages
+	var recipients []int
+	for _, id := range userIDs {
+		if id != message.UserID {
+			recipients = append(recipients, id)
+		}
+	}
+
+	// Only users who can read the lobby are notified
+	recipients, err := repos.Lobbies.FilterAccessible(message.LobbyID, recipients)
+	if err != nil {
+		log.Println("Error checking mention access:", err)
+		return
+	}
+
+	// Users who blocked the author are not notified
+	recipients, err = repos.Blocks.ExcludeBlockers(message.UserID, recipients)
+	if err != nil {
+		log.Println("Error checking mention blocks:", err)
+		return
+	}
+
+	created, err := repos.Mentions.CreateMentions(message.ID, kind, recipients)
+	if err != nil {
+		log.Println("Error saving mentions:", err)
+		return
+	}
+
+	for _, userID := range created {
+		sendToUser(userID, models.Event{
+			Type:    models.EventMention,
+			LobbyID: message.LobbyID,
+			Data: models.MentionEvent{
+				Kind:    kind,
+				Message: message,
+			},
+		})
+	}
+}
+
+// onlineUserIDs gets the IDs of users connected to a lobby
+func onlineUserIDs(lobbyID int) []int {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	seen := make(map[int]bool)
+	var userIDs []int
+	for _, c := range lobbies[lobbyID] {
+		if !seen[c.userID] {
+			seen[c.userID] = true
+			userIDs = append(userIDs, c.userID)
+		}
+	}
+	return userIDs
+}
+
+// sendToUser sends a payload to every connection of a user across all lobbies
+func sendToUser(userID int, msg interface{}) {
+	msgJSON, err := json.Marshal(msg)
+	if err != nil {
+		log.Println("Error marshaling message:", err)
+		return
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for lobbyID, conns := range lobbies {
+		for conn, c := range conns {
+			if c.userID == userID {
+				writeLocked(lobbyID, conn, msgJSON)
+			}
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package websocket
+
+import (
+	"log"
+
+	"github.com/galexander77/chat-app/api/models"
+)
+
+// DeleteMessage soft deletes and unpins a message and notifies the lobby.
+// Deletions of other users' messages are recorded in the moderation log; the
+// caller must check that deletedBy may delete the message.
+func DeleteMessage(repos *Repositories, message *models.Message, deletedBy int) error {
+	deletedAt, wasPinned, err := repos.Messages.DeleteMessage(message.ID, deletedBy)
+	if err != nil {
+		return err
+	}
+
+	if message.UserID != deletedBy {
+		err := repos.Moderation.LogAction(&models.ModerationLogEntry{
+			LobbyID:     message.LobbyID,
+			ModeratorID: deletedBy,
+			UserID:      message.UserID,
+			Action:      models.ModerationDeleteMessage,
+		})
+		if err != nil {
+			log.Println("Error logging message deletion:", err)
+		}
+	}
+
+	// Notify connected clients so they can replace the message with a tombstone
+	BroadcastEvent(message.LobbyID, models.Event{
+		Type: models.EventMessageDeleted,
+		Data: models.MessageDeletedEvent{
+			MessageID: message.ID,
+			DeletedBy: deletedBy,
+			DeletedAt: deletedAt,
+		},
+	})
+	if wasPinned {
+		BroadcastEvent(message.LobbyID, models.Event{
+			Type: models.EventMessageUnpinned,
+			Data: models.MessageUnpinnedEvent{
+				MessageID:  message.ID,
+				UnpinnedBy: deletedBy,
+			},
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,168 @@
+package websocket
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/galexander77/chat-app/api/models"
+	"github.com/gofiber/websocket/v2"
+)
+
+// Moderation errors
+var (
+	ErrNotModerator  = errors.New("not allowed to moderate this lobby")
+	ErrInvalidAction = errors.New("invalid moderation action")
+	ErrInvalidTarget = errors.New("invalid moderation target")
+)
+
+// CanModerate reports whether a user may moderate a lobby: users with the
+// moderator or admin role may moderate every lobby, owners their own
+func CanModerate(repos *Repositories, lobbyID, userID int) (bool, error) {
+	isModerator, err := repos.Users.IsModerator(userID)
+	if err != nil || isModerator {
+		return isModerator, err
+	}
+
+	return repos.Lobbies.IsOwner(lobbyID, userID)
+}
+
+// Moderate applies a moderation action in a lobby, records it in the audit
+// log, broadcasts it as a system event and enforces it on live connections
+func Moderate(repos *Repositories, lobbyID, moderatorID int, req models.ModerationRequest) (*models.ModerationLogEntry, error) {
+	if req.UserID <= 0 || req.UserID == moderatorID {
+		return nil, ErrInvalidTarget
+	}
+	if req.Duration < 0 {
+		return nil, ErrInvalidAction
+	}
+
+	allowed, err := CanModerate(repos, lobbyID, moderatorID)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, ErrNotModerator
+	}
+
+	entry := &models.ModerationLogEntry{
+		LobbyID:     lobbyID,
+		ModeratorID: moderatorID,
+		UserID:      req.UserID,
+		Action:      req.Action,
+		Reason:      req.Reason,
+	}
+	duration := time.Duration(req.Duration) * time.Second
+
+	switch req.Action {
+	case models.ModerationKick:
+	case models.ModerationBan:
+		entry.ExpiresAt, err = repos.Moderation.Restrict(lobbyID, req.UserID, models.RestrictionBan, moderatorID, req.Reason, duration)
+	case models.ModerationUnban:
+		_, err = repos.Moderation.Lift(lobbyID, req.UserID, models.RestrictionBan)
+	case models.ModerationMute:
+		entry.ExpiresAt, err = repos.Moderation.Restrict(lobbyID, req.UserID, models.RestrictionMute, moderatorID, req.Reason, duration)
+	case models.ModerationUnmute:
+		_, err = repos.Moderation.Lift(lobbyID, req.UserID, models.RestrictionMute)
+	default:
+		return nil, ErrInvalidAction
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := repos.Moderation.LogAction(entry); err != nil {
+		return nil, err
+	}
+
+	// Broadcast before disconnecting so kicked and banned users see why
+	BroadcastEvent(lobbyID, models.Event{
+		Type: models.EventModeration,
+		Data: entry,
+	})
+
+	switch req.Action {
+	case models.ModerationKick, models.ModerationBan:
+		disconnectUser(lobbyID, req.UserID, "Removed by a moderator")
+	case models.ModerationMute:
+		setMuted(lobbyID, req.UserID, true, entry.ExpiresAt)
+	case models.ModerationUnmute:
+		setMuted(lobbyID, req.UserID, false, nil)
+	}
+
+	return entry, nil
+}
+
+// handleModerationRequest applies a moderation action sent over the socket
+func handleModerationRequest(repos *Repositories, c *client, req models.MessageRequest) {
+	if _, err := Moderate(repos, c.lobbyID, c.userID, req.ModerationRequest); err != nil {
+		log.Println("Error applying moderation action:", err)
+	}
+}
+
+// loadMute sets a new client's mute state from the database
+func loadMute(repos *Repositories, c *client) {
+	mute, err := repos.Moderation.GetActiveRestriction(c.lobbyID, c.userID, models.RestrictionMute)
+	if err != nil {
+		log.Println("Error fetching mute:", err)
+		return
+	}
+	if mute != nil {
+		mutex.Lock()
+		c.muted = true
+		c.mutedUntil = mute.ExpiresAt
+		mutex.Unlock()
+	}
+}
+
+// isMuted reports whether a client may currently not post
+func isMuted(c *client) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if !c.muted {
+		return false
+	}
+	if c.mutedUntil != nil && time.Now().After(*c.mutedUntil) {
+		c.muted = false
+		c.mutedUntil = nil
+		return false
+	}
+	return true
+}
+
+// setMuted updates the mute state of a user's connections to a lobby
+func setMuted(lobbyID, userID int, muted bool, until *time.Time) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for _, c := range lobbies[lobbyID] {
+		if c.userID == userID {
+			c.muted = muted
+			c.mutedUntil = until
+		}
+	}
+}
+
+// disconnectUser closes a user's connections to a lobby
+func disconnectUser(lobbyID, userID int, reason string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for conn, c := range lobbies[lobbyID] {
+		if c.userID == userID {
+			closeLocked(lobbyID, conn, reason)
+		}
+	}
+}
+
+// closeLocked sends a close frame with a reason and closes a connection.
+// The caller must hold mutex.
+func closeLocked(lobbyID int, conn *websocket.Conn, reason string) {
+	closeMsg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+	if err := conn.WriteMessage(websocket.CloseMessage, closeMsg); err != nil {
+		log.Println("Error sending close message:", err)
+	}
+	conn.Close()
+	delete(lobbies[lobbyID], conn)
+}
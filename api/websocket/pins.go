@@ -0,0 +1,67 @@
+package websocket
+
+import (
+	"errors"
+
+	"github.com/galexander77/chat-app/api/models"
+)
+
+// SetPinned pins or unpins a message in its lobby and notifies the lobby.
+// Only moderators and the lobby owner may pin messages.
+func SetPinned(repos *Repositories, message *models.Message, userID int, pinned bool) error {
+	if message.DeletedAt != nil {
+		return ErrMessageDeleted
+	}
+
+	allowed, err := CanModerate(repos, message.LobbyID, userID)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return ErrNotModerator
+	}
+
+	changed, err := repos.Messages.SetPinned(message.ID, userID, pinned)
+	if err != nil || !changed {
+		return err
+	}
+
+	if !pinned {
+		BroadcastEvent(message.LobbyID, models.Event{
+			Type: models.EventMessageUnpinned,
+			Data: models.MessageUnpinnedEvent{
+				MessageID:  message.ID,
+				UnpinnedBy: userID,
+			},
+		})
+		return nil
+	}
+
+	message, err = repos.Messages.GetMessageByID(message.ID)
+	if err != nil {
+		return err
+	}
+	BroadcastEvent(message.LobbyID, models.Event{
+		Type: models.EventMessagePinned,
+		Data: message,
+	})
+	return nil
+}
+
+// handlePinRequest pins or unpins a message in the connected lobby
+func handlePinRequest(repos *Repositories, c *client, req models.MessageRequest) {
+	message, ok := getLobbyMessage(repos, c, req.MessageID)
+	if !ok {
+		return
+	}
+
+	err := SetPinned(repos, message, c.userID, req.Type == models.RequestPin)
+	switch {
+	case errors.Is(err, ErrNotModerator):
+		sendError(c, models.ErrorEvent{Code: models.ErrorNotAllowed, Message: "Only moderators may pin messages"})
+	case errors.Is(err, ErrMessageDeleted):
+		sendError(c, models.ErrorEvent{Code: models.ErrorInvalidRequest, Message: "Message has been deleted"})
+	case err != nil:
+		sendInternalError(c, "Error updating pin", err)
+	}
+}
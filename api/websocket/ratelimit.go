@@ -0,0 +1,126 @@
+package websocket
+
+import (
+	"time"
+
+	"github.com/galexander77/chat-app/api/models"
+)
+
+// rateLimit is the token bucket applied to each user's requests in a lobby
+var rateLimit = struct {
+	burst    float64
+	interval time.Duration
+}{burst: 5, interval: time.Second}
+
+// tokenBucket holds the requests a user may still send to a lobby
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens earned since the bucket was last used
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += float64(now.Sub(b.last)) / float64(rateLimit.interval)
+	if b.tokens > rateLimit.burst {
+		b.tokens = rateLimit.burst
+	}
+	b.last = now
+}
+
+// takeToken takes a token from the client's bucket for its lobby. If the
+// bucket is empty it returns how long until the next token is available.
+func takeToken(c *client) time.Duration {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	now := time.Now()
+	key := lobbyUser{lobbyID: c.lobbyID, userID: c.userID}
+	b, ok := buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: rateLimit.burst, last: now}
+		buckets[key] = b
+	}
+
+	b.refill(now)
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) * float64(rateLimit.interval))
+	}
+	b.tokens--
+	return 0
+}
+
+// slowModeWait returns how long until a user may send another message to
+// the lobby under slow mode
+func slowModeWait(c *client, interval time.Duration) time.Duration {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	now := time.Now()
+	key := lobbyUser{lobbyID: c.lobbyID, userID: c.userID}
+	if last, ok := lastPosted[key]; ok && now.Sub(last) < interval {
+		return interval - now.Sub(last)
+	}
+	return 0
+}
+
+// recordPost starts a user's slow mode wait once their message has been
+// saved, so messages refused by the filters or not saved do not count
+func recordPost(c *client) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if settings[c.lobbyID].SlowMode > 0 {
+		lastPosted[lobbyUser{lobbyID: c.lobbyID, userID: c.userID}] = time.Now()
+	}
+}
+
+// forgetUserLocked drops a user's rate limit and slow mode state for a lobby
+// once they have no connections left to it and the state no longer limits
+// them. The caller must hold mutex.
+func forgetUserLocked(lobbyID, userID int) {
+	for _, c := range lobbies[lobbyID] {
+		if c.userID == userID {
+			return
+		}
+	}
+
+	now := time.Now()
+	key := lobbyUser{lobbyID: lobbyID, userID: userID}
+	if b, ok := buckets[key]; ok {
+		b.refill(now)
+		if b.tokens >= rateLimit.burst {
+			delete(buckets, key)
+		}
+	}
+	slowMode := time.Duration(settings[lobbyID].SlowMode) * time.Second
+	if last, ok := lastPosted[key]; ok && now.Sub(last) >= slowMode {
+		delete(lastPosted, key)
+	}
+}
+
+// ForgetIdleLimits drops the rate limit and slow mode state of users with no
+// connections once it no longer limits them. State is otherwise dropped
+// when a user's last connection closes, which never happens for scheduled
+// messages sent while their author is offline.
+func ForgetIdleLimits() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for key := range buckets {
+		forgetUserLocked(key.lobbyID, key.userID)
+	}
+	for key := range lastPosted {
+		forgetUserLocked(key.lobbyID, key.userID)
+	}
+}
+
+// sendRetryError tells a client that its request was refused and when it
+// may try again
+func sendRetryError(c *client, code, message string, wait time.Duration) {
+	retryAt := time.Now().Add(wait)
+	sendError(c, models.ErrorEvent{
+		Code:    code,
+		Message: message,
+		RetryAt: &retryAt,
+	})
+}
@@ -0,0 +1,141 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gofiber/websocket/v2"
+)
+
+func TestTokenBucketRefill(t *testing.T) {
+	start := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		tokens  float64
+		elapsed time.Duration
+		want    float64
+	}{
+		{"no time passed", 2, 0, 2},
+		{"one interval", 2, rateLimit.interval, 3},
+		{"half an interval", 0, rateLimit.interval / 2, 0.5},
+		{"capped at burst", 4, 10 * rateLimit.interval, rateLimit.burst},
+		{"full bucket stays full", rateLimit.burst, rateLimit.interval, rateLimit.burst},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &tokenBucket{tokens: tt.tokens, last: start}
+			b.refill(start.Add(tt.elapsed))
+
+			if b.tokens != tt.want {
+				t.Errorf("tokens = %v, want %v", b.tokens, tt.want)
+			}
+			if !b.last.Equal(start.Add(tt.elapsed)) {
+				t.Errorf("last = %v, want %v", b.last, start.Add(tt.elapsed))
+			}
+		})
+	}
+}
+
+func TestTakeToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		requests int
+		// limited is whether the last request must wait
+		limited bool
+	}{
+		{"single request", 1, false},
+		{"whole burst", int(rateLimit.burst), false},
+		{"one past the burst", int(rateLimit.burst) + 1, true},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &client{lobbyID: 1, userID: i + 1}
+			t.Cleanup(func() {
+				mutex.Lock()
+				delete(buckets, lobbyUser{lobbyID: c.lobbyID, userID: c.userID})
+				mutex.Unlock()
+			})
+
+			for n := 1; n < tt.requests; n++ {
+				if wait := takeToken(c); wait != 0 {
+					t.Fatalf("request %d waits %v, want 0", n, wait)
+				}
+			}
+
+			wait := takeToken(c)
+			if !tt.limited && wait != 0 {
+				t.Errorf("last request waits %v, want 0", wait)
+			}
+			if tt.limited && (wait <= 0 || wait > rateLimit.interval) {
+				t.Errorf("last request waits %v, want between 0 and %v", wait, rateLimit.interval)
+			}
+		})
+	}
+}
+
+func TestTakeTokenSeparatesLobbies(t *testing.T) {
+	first := &client{lobbyID: 1, userID: 100}
+	second := &client{lobbyID: 2, userID: 100}
+	t.Cleanup(func() {
+		mutex.Lock()
+		delete(buckets, lobbyUser{lobbyID: first.lobbyID, userID: first.userID})
+		delete(buckets, lobbyUser{lobbyID: second.lobbyID, userID: second.userID})
+		mutex.Unlock()
+	})
+
+	for n := 0; n < int(rateLimit.burst); n++ {
+		takeToken(first)
+	}
+
+	if wait := takeToken(second); wait != 0 {
+		t.Errorf("request to another lobby waits %v, want 0", wait)
+	}
+}
+
+func TestForgetIdleLimits(t *testing.T) {
+	long := time.Now().Add(-time.Duration(rateLimit.burst) * rateLimit.interval)
+	connected := &client{lobbyID: 3, userID: 201}
+
+	tests := []struct {
+		name   string
+		userID int
+		bucket tokenBucket
+		kept   bool
+	}{
+		{"refilled while offline", 200, tokenBucket{tokens: 0, last: long}, false},
+		{"still limited while offline", 202, tokenBucket{tokens: 0, last: time.Now()}, true},
+		{"refilled while connected", connected.userID, tokenBucket{tokens: 0, last: long}, true},
+	}
+
+	mutex.Lock()
+	lobbies[connected.lobbyID] = map[*websocket.Conn]*client{nil: connected}
+	for _, tt := range tests {
+		bucket := tt.bucket
+		buckets[lobbyUser{lobbyID: connected.lobbyID, userID: tt.userID}] = &bucket
+	}
+	mutex.Unlock()
+	t.Cleanup(func() {
+		mutex.Lock()
+		delete(lobbies, connected.lobbyID)
+		for _, tt := range tests {
+			delete(buckets, lobbyUser{lobbyID: connected.lobbyID, userID: tt.userID})
+		}
+		mutex.Unlock()
+	})
+
+	ForgetIdleLimits()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mutex.Lock()
+			_, kept := buckets[lobbyUser{lobbyID: connected.lobbyID, userID: tt.userID}]
+			mutex.Unlock()
+			if kept != tt.kept {
+				t.Errorf("bucket kept = %v, want %v", kept, tt.kept)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package websocket
+
+import (
+	"errors"
+
+	"github.com/galexander77/chat-app/api/db"
+	"github.com/galexander77/chat-app/api/emoji"
+	"github.com/galexander77/chat-app/api/models"
+)
+
+// ErrMessageDeleted is returned when reacting to a deleted message
+var ErrMessageDeleted = errors.New("message has been deleted")
+
+// UpdateReaction adds or removes a user's reaction on a message and
+// broadcasts the change to the message's lobby
+func UpdateReaction(reactionRepo *db.ReactionRepository, message *models.Message, userID int, e string, add bool) error {
+	if message.DeletedAt != nil {
+		return ErrMessageDeleted
+	}
+
+	var changed bool
+	var err error
+	if add {
+		e, err = reactionRepo.ValidateEmoji(message.LobbyID, e)
+		if err != nil {
+			return err
+		}
+		changed, err = reactionRepo.AddReaction(message.ID, userID, e)
+	} else {
+		// Removal skips validation so reactions with since-removed custom emoji can be cleared
+		if c, ok := emoji.Canonical(e); ok {
+			e = c
+		}
+		changed, err = reactionRepo.RemoveReaction(message.ID, userID, e)
+	}
+	if err != nil || !changed {
+		return err
+	}
+
+	count, err := reactionRepo.CountReactions(message.ID, e)
+	if err != nil {
+		return err
+	}
+
+	eventType := models.EventReactionAdded
+	if !add {
+		eventType = models.EventReactionRemoved
+	}
+	BroadcastEvent(message.LobbyID, models.Event{
+		Type: eventType,
+		Data: models.ReactionEvent{
+			MessageID: message.ID,
+			UserID:    userID,
+			Emoji:     e,
+			Count:     count,
+		},
+	})
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package websocket
+
+import (
+	"errors"
+	"time"
+
+	"github.com/galexander77/chat-app/api/models"
+)
+
+// Errors delivering scheduled messages and reminders
+var (
+	ErrNoAccess = errors.New("no longer allowed to access this lobby")
+	ErrBanned   = errors.New("banned from this lobby")
+	ErrNotSent  = errors.New("message could not be sent")
+)
+
+// RetryError is returned for a scheduled message that the author's rate
+// limit or the lobby's slow mode does not allow to be sent yet
+type RetryError struct {
+	Reason  string
+	RetryAt time.Time
+}
+
+func (e *RetryError) Error() string {
+	return e.Reason
+}
+
+// SendScheduled sends a scheduled message as its author through the same
+// checks, rate limit, filters and broadcast as a message sent over a
+// connection. The message is saved with the item marked sent. It returns
+// the sent message, a *RetryError if it may be sent later, or why it was
+// not sent.
+func SendScheduled(repos *Repositories, item models.ScheduledMessage) (*models.Message, error) {
+	// Connections are authorized when they open, so check what they would
+	allowed, err := repos.Lobbies.CanAccess(item.LobbyID, item.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, ErrNoAccess
+	}
+	ban, err := repos.Moderation.GetActiveRestriction(item.LobbyID, item.UserID, models.RestrictionBan)
+	if err != nil {
+		return nil, err
+	}
+	if ban != nil {
+		return nil, ErrBanned
+	}
+	username, err := repos.Users.GetUsernameByID(item.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	// A client without a connection reports errors here instead of sending
+	// them. Errors with a retry time, such as slow mode, postpone the item.
+	var failure error
+	c := &client{
+		lobbyID:     item.LobbyID,
+		userID:      item.UserID,
+		username:    username,
+		threads:     make(map[int]bool),
+		blocked:     make(map[int]bool),
+		scheduledID: item.ID,
+		onError: func(event models.ErrorEvent) {
+			if event.RetryAt != nil {
+				failure = &RetryError{Reason: event.Message, RetryAt: *event.RetryAt}
+				return
+			}
+			failure = errors.New(event.Message)
+		},
+	}
+	loadMute(repos, c)
+	loadLobby(repos, item.LobbyID)
+
+	// Scheduled messages share the author's rate limit in the lobby
+	if wait := takeToken(c); wait > 0 {
+		return nil, &RetryError{Reason: "Too many requests", RetryAt: time.Now().Add(wait)}
+	}
+
+	req := models.MessageRequest{Content: item.Content}
+	if item.ParentID != nil {
+		req.ParentID = *item.ParentID
+	}
+	message := handleRequest(repos, c, req)
+	if message == nil {
+		if failure == nil {
+			failure = ErrNotSent
+		}
+		return nil, failure
+	}
+	return message, nil
+}
+
+// SendReminder notifies a user of a due reminder wherever they are
+// connected. Reminders about messages in lobbies the user can no longer
+// read fail.
+func SendReminder(repos *Repositories, item models.ScheduledMessage) error {
+	if item.MessageID == nil {
+		return ErrNotSent
+	}
+	message, err := repos.Messages.GetMessageByID(*item.MessageID)
+	if err != nil {
+		return err
+	}
+	allowed, err := repos.Lobbies.CanAccess(message.LobbyID, item.UserID)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return ErrNoAccess
+	}
+
+	item.Status = models.ScheduledSent
+	sendToUser(item.UserID, models.Event{
+		Type:    models.EventReminder,
+		LobbyID: message.LobbyID,
+		Data:    models.ReminderEvent{Reminder: item, Message: *message},
+	})
+	return nil
+}
+
+// NotifyScheduledFailed tells the author of a scheduled message that could
+// not be sent why, wherever they are connected
+func NotifyScheduledFailed(item models.ScheduledMessage, reason string) {
+	item.Status = models.ScheduledFailed
+	item.Error = reason
+	sendToUser(item.UserID, models.Event{
+		Type:    models.EventScheduledFailed,
+		LobbyID: item.LobbyID,
+		Data:    item,
+	})
+}
@@ -0,0 +1,116 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/galexander77/chat-app/api/db"
+	"github.com/galexander77/chat-app/api/markdown"
+	"github.com/galexander77/chat-app/api/models"
+)
+
+// handleThreadSubscription subscribes or unsubscribes a client from a thread's replies
+func handleThreadSubscription(repos *Repositories, c *client, req models.MessageRequest) {
+	if req.Type == models.RequestThreadUnsubscribe {
+		mutex.Lock()
+		delete(c.threads, req.MessageID)
+		mutex.Unlock()
+		return
+	}
+
+	parent, ok := getLobbyMessage(repos, c, req.MessageID)
+	if !ok {
+		return
+	}
+	if parent.ParentID != nil {
+		sendError(c, models.ErrorEvent{Code: models.ErrorInvalidRequest, Message: "Replies have no thread"})
+		return
+	}
+
+	mutex.Lock()
+	c.threads[parent.ID] = true
+	mutex.Unlock()
+}
+
+// handleReply saves a reply to a thread, sends it to the thread's subscribers
+// and notifies the lobby of the updated reply count. It returns nil if the
+// reply could not be saved.
+func handleReply(repos *Repositories, c *client, req models.MessageRequest) *models.Message {
+	parent, err := repos.Messages.GetMessageByID(req.ParentID)
+	if err != nil && !errors.Is(err, db.ErrMessageNotFound) {
+		sendInternalError(c, "Error fetching reply parent", err)
+		return nil
+	}
+	if err != nil || parent.LobbyID != c.lobbyID || parent.ParentID != nil || parent.DeletedAt != nil {
+		sendError(c, models.ErrorEvent{Code: models.ErrorInvalidRequest, Message: "Invalid reply parent"})
+		return nil
+	}
+
+	message := models.Message{
+		Content:   req.Content,
+		HTML:      markdown.Render(req.Content),
+		UserID:    c.userID,
+		Username:  c.username,
+		LobbyID:   c.lobbyID,
+		Timestamp: time.Now(),
+		ParentID:  &parent.ID,
+	}
+	message.ExpiresAt = expiresAt(c.lobbyID, req.TTL, message.Timestamp)
+
+	messageID, err := repos.Messages.SaveReply(message.Content, message.UserID, message.LobbyID, parent.ID, req.AttachmentIDs, message.ExpiresAt, c.scheduledID)
+	if errors.Is(err, db.ErrAttachmentUnavailable) {
+		sendError(c, models.ErrorEvent{Code: models.ErrorInvalidRequest, Message: err.Error()})
+		return nil
+	}
+	if err != nil {
+		sendInternalError(c, "Error saving reply", err)
+		return nil
+	}
+	message.ID = messageID
+	loadAttachments(repos, &message, req.AttachmentIDs)
+
+	// Replying subscribes the author to the thread
+	mutex.Lock()
+	c.threads[parent.ID] = true
+	mutex.Unlock()
+
+	broadcastToThread(c.lobbyID, parent.ID, message)
+	handleMentions(repos, message)
+
+	parent, err = repos.Messages.GetMessageByID(parent.ID)
+	if err != nil {
+		log.Println("Error fetching thread summary:", err)
+		return &message
+	}
+	BroadcastEvent(c.lobbyID, models.Event{
+		Type: models.EventThreadUpdated,
+		Data: models.ThreadUpdatedEvent{
+			ParentID:    parent.ID,
+			ReplyCount:  parent.ReplyCount,
+			LastReplyAt: parent.LastReplyAt,
+		},
+	})
+
+	return &message
+}
+
+// broadcastToThread sends a reply to clients in a lobby subscribed to its
+// thread, skipping clients who blocked its author
+func broadcastToThread(lobbyID, parentID int, msg models.Message) {
+	msgJSON, err := json.Marshal(msg)
+	if err != nil {
+		log.Println("Error marshaling message:", err)
+		return
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for conn, c := range lobbies[lobbyID] {
+		if c.threads[parentID] && !c.blocked[msg.UserID] {
+			writeLocked(lobbyID, conn, msgJSON)
+		}
+	}
+}
@@ -2,51 +2,149 @@ package websocket
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"sync"
 	"time"
 
+	"github.com/galexander77/chat-app/api/config"
+	"github.com/galexander77/chat-app/api/content"
 	"github.com/galexander77/chat-app/api/db"
+	"github.com/galexander77/chat-app/api/filter"
+	"github.com/galexander77/chat-app/api/markdown"
 	"github.com/galexander77/chat-app/api/models"
 	"github.com/gofiber/websocket/v2"
 )
 
 // Map to store active connections per lobby
 var (
-	lobbies = make(map[int]map[*websocket.Conn]bool)
-	mutex   = &sync.Mutex{}
+	lobbies    = make(map[int]map[*websocket.Conn]*client)
+	archived   = make(map[int]bool) // read-only lobbies
+	direct     = make(map[int]bool) // direct message lobbies
+	settings   = make(map[int]models.LobbySettings)
+	buckets    = make(map[lobbyUser]*tokenBucket) // for rate limiting
+	lastPosted = make(map[lobbyUser]time.Time)    // for slow mode
+	mutex      = &sync.Mutex{}
 )
 
+// filters is the chain chat messages pass through before being saved, set by SetFilters
+var filters filter.Chain
+
+// SetFilters sets the message filter chain. It must be called before any
+// connection is accepted.
+func SetFilters(chain filter.Chain) {
+	filters = chain
+}
+
+// limits holds the connection limits, set by Configure
+var limits = struct {
+	maxFrameSize     int64
+	maxMessageLength int
+}{maxFrameSize: 64 * 1024, maxMessageLength: 4000}
+
+// Configure applies connection settings. It must be called before any
+// connection is accepted.
+func Configure(cfg config.WebSocketConfig) {
+	if cfg.RateLimitBurst > 0 && cfg.RateLimitInterval > 0 {
+		rateLimit.burst = float64(cfg.RateLimitBurst)
+		rateLimit.interval = cfg.RateLimitInterval
+	}
+	if cfg.MaxFrameSize > 0 {
+		limits.maxFrameSize = cfg.MaxFrameSize
+	}
+	if cfg.MaxMessageLength > 0 {
+		limits.maxMessageLength = cfg.MaxMessageLength
+	}
+}
+
+// lobbyUser identifies a user within a lobby
+type lobbyUser struct {
+	lobbyID int
+	userID  int
+}
+
+// client holds the state of a connection to a lobby. Fields other than
+// conn and the IDs are guarded by mutex.
+type client struct {
+	conn     *websocket.Conn
+	lobbyID  int
+	userID   int
+	username string
+	threads  map[int]bool // parent message IDs of subscribed threads
+	blocked  map[int]bool // IDs of users whose messages are not delivered
+
+	muted      bool
+	mutedUntil *time.Time // nil while muted means indefinitely
+
+	// onError receives the errors of clients without a connection, which
+	// send scheduled messages
+	onError func(models.ErrorEvent)
+	// scheduledID is the scheduled message a client without a connection sends
+	scheduledID int
+}
+
+// Repositories holds the repositories used by WebSocket connections
+type Repositories struct {
+	Users       *db.UserRepository
+	Lobbies     *db.LobbyRepository
+	Messages    *db.MessageRepository
+	Reactions   *db.ReactionRepository
+	Mentions    *db.MentionRepository
+	Moderation  *db.ModerationRepository
+	Reports     *db.ReportRepository
+	Blocks      *db.BlockRepository
+	Attachments *db.AttachmentRepository
+}
+
 // InitLobby initializes a lobby's connection map
 func InitLobby(lobbyID int) {
 	mutex.Lock()
 	defer mutex.Unlock()
 
 	if _, ok := lobbies[lobbyID]; !ok {
-		lobbies[lobbyID] = make(map[*websocket.Conn]bool)
+		lobbies[lobbyID] = make(map[*websocket.Conn]*client)
 	}
 }
 
 // HandleFiberConnection handles a WebSocket connection with Fiber
-func HandleFiberConnection(conn *websocket.Conn, lobbyID, userID int, username string, messageRepo *db.MessageRepository) {
+func HandleFiberConnection(conn *websocket.Conn, lobbyID, userID int, username string, repos *Repositories) {
+	c := &client{
+		conn:     conn,
+		lobbyID:  lobbyID,
+		userID:   userID,
+		username: username,
+		threads:  make(map[int]bool),
+		blocked:  make(map[int]bool),
+	}
+
+	// Frames over the limit fail the read below and end the connection
+	conn.SetReadLimit(limits.maxFrameSize)
+
 	// Add connection to lobby
 	mutex.Lock()
 	if _, ok := lobbies[lobbyID]; !ok {
-		lobbies[lobbyID] = make(map[*websocket.Conn]bool)
+		lobbies[lobbyID] = make(map[*websocket.Conn]*client)
 	}
-	lobbies[lobbyID][conn] = true
+	lobbies[lobbyID][conn] = c
 	mutex.Unlock()
 
+	loadMute(repos, c)
+	loadBlocks(repos, c)
+	loadLobby(repos, lobbyID)
+
 	// Remove connection when done
 	defer func() {
 		mutex.Lock()
 		delete(lobbies[lobbyID], conn)
+		forgetUserLocked(lobbyID, userID)
 		mutex.Unlock()
 	}()
 
 	// Send join message to all clients in lobby
+	joinContent := username + " has joined the lobby"
 	joinMsg := models.Message{
-		Content:   username + " has joined the lobby",
+		Content:   joinContent,
+		HTML:      markdown.Render(joinContent),
 		UserID:    0, // System message
 		Username:  "System",
 		LobbyID:   lobbyID,
@@ -68,37 +166,203 @@ func HandleFiberConnection(conn *websocket.Conn, lobbyID, userID int, username s
 			continue
 		}
 
+		// Refuse requests beyond the user's rate limit
+		if wait := takeToken(c); wait > 0 {
+			sendRetryError(c, models.ErrorRateLimited, "Too many requests", wait)
+			continue
+		}
+
 		// Parse message
 		var msgContent models.MessageRequest
 		if err := json.Unmarshal(msg, &msgContent); err != nil {
-			log.Println("Error parsing message:", err)
+			sendError(c, models.ErrorEvent{Code: models.ErrorInvalidRequest, Message: "Invalid JSON"})
 			continue
 		}
 
-		// Create message
-		message := models.Message{
-			Content:   msgContent.Content,
-			UserID:    userID,
-			Username:  username,
-			LobbyID:   lobbyID,
-			Timestamp: time.Now(),
-		}
+		handleRequest(repos, c, msgContent)
+	}
+}
 
-		// Save message to database
-		messageID, err := messageRepo.SaveMessage(message.Content, message.UserID, message.LobbyID)
+// handleRequest validates and handles a request from a client, returning
+// the chat message it sent, if any. Requests are refused while the client
+// is muted, the lobby is archived or the lobby's post policy does not allow
+// the client to post.
+func handleRequest(repos *Repositories, c *client, req models.MessageRequest) *models.Message {
+	// Validate and clean chat message content. Messages with attachments
+	// may have no text.
+	if req.Type == "" {
+		if len(req.AttachmentIDs) > maxAttachments {
+			sendError(c, models.ErrorEvent{Code: models.ErrorInvalidRequest, Message: "Too many attachments"})
+			return nil
+		}
+		if req.TTL < 0 || req.TTL > models.MaxMessageTTL {
+			sendError(c, models.ErrorEvent{Code: models.ErrorInvalidRequest, Message: "TTL must be between 0 and 2592000 seconds"})
+			return nil
+		}
+		cleaned, err := content.Clean(req.Content, limits.maxMessageLength)
+		if errors.Is(err, content.ErrEmpty) && len(req.AttachmentIDs) > 0 {
+			err = nil
+		}
 		if err != nil {
-			log.Println("Error saving message:", err)
-			continue
+			sendError(c, models.ErrorEvent{Code: models.ErrorInvalidContent, Message: err.Error()})
+			return nil
 		}
-		message.ID = messageID
+		req.Content = cleaned
+	}
 
-		// Broadcast message to all clients in lobby
-		broadcastToLobby(lobbyID, message)
+	// Muted users and archived lobbies can be read but not posted to
+	if isPosting(req.Type) && isMuted(c) {
+		sendError(c, models.ErrorEvent{Code: models.ErrorNotAllowed, Message: "You are muted in this lobby"})
+		return nil
+	}
+	if isPosting(req.Type) && isArchived(c.lobbyID) {
+		sendError(c, models.ErrorEvent{Code: models.ErrorNotAllowed, Message: "This lobby is archived"})
+		return nil
 	}
+	if req.Type == "" && blockedInDM(repos, c) {
+		sendError(c, models.ErrorEvent{Code: models.ErrorNotAllowed, Message: "You cannot message this conversation"})
+		return nil
+	}
+	if req.Type == "" && !mayPost(repos, c) {
+		return nil
+	}
+
+	switch req.Type {
+	case "":
+		message := handleChatMessage(repos, c, req)
+		if message != nil {
+			recordPost(c)
+		}
+		return message
+	case models.RequestReactionAdd, models.RequestReactionRemove:
+		handleReactionRequest(repos, c, req)
+	case models.RequestThreadSubscribe, models.RequestThreadUnsubscribe:
+		handleThreadSubscription(repos, c, req)
+	case models.RequestModeration:
+		handleModerationRequest(repos, c, req)
+	case models.RequestPin, models.RequestUnpin:
+		handlePinRequest(repos, c, req)
+	default:
+		sendError(c, models.ErrorEvent{Code: models.ErrorInvalidRequest, Message: "Unknown request type: " + req.Type})
+	}
+	return nil
+}
+
+// isPosting reports whether a request type adds content to the lobby
+func isPosting(requestType string) bool {
+	return requestType == "" || requestType == models.RequestReactionAdd
 }
 
-// broadcastToLobby broadcasts a message to all clients in a lobby
-func broadcastToLobby(lobbyID int, msg models.Message) {
+// handleChatMessage passes a chat message through the filters, then saves
+// it and broadcasts it to the lobby. Replies are sent to thread subscribers
+// instead. It returns the saved message, or nil if it was not saved.
+func handleChatMessage(repos *Repositories, c *client, req models.MessageRequest) *models.Message {
+	// Messages with only attachments have no text to filter
+	verdict := filter.Verdict{Action: filter.Allow, Content: req.Content}
+	if req.Content != "" {
+		verdict = filters.Run(filter.Message{LobbyID: c.lobbyID, UserID: c.userID, Content: req.Content})
+	}
+	if verdict.Action == filter.Reject {
+		sendError(c, models.ErrorEvent{Code: models.ErrorRejected, Message: verdict.Reason})
+		return nil
+	}
+	req.Content = verdict.Content
+
+	var message *models.Message
+	if req.ParentID != 0 {
+		message = handleReply(repos, c, req)
+	} else {
+		message = handleLobbyMessage(repos, c, req)
+	}
+
+	if message != nil && verdict.Action == filter.Queue {
+		if err := repos.Reports.CreateFilterReport(message, verdict.Filter, verdict.Reason); err != nil {
+			log.Println("Error queueing message for review:", err)
+		}
+	}
+	return message
+}
+
+// handleLobbyMessage saves a top-level chat message and broadcasts it to the
+// lobby, returning nil if it could not be saved
+func handleLobbyMessage(repos *Repositories, c *client, req models.MessageRequest) *models.Message {
+	// Create message
+	message := models.Message{
+		Content:   req.Content,
+		HTML:      markdown.Render(req.Content),
+		UserID:    c.userID,
+		Username:  c.username,
+		LobbyID:   c.lobbyID,
+		Timestamp: time.Now(),
+	}
+	message.ExpiresAt = expiresAt(c.lobbyID, req.TTL, message.Timestamp)
+
+	// Save message to database
+	messageID, err := repos.Messages.SaveMessage(message.Content, message.UserID, message.LobbyID, req.AttachmentIDs, message.ExpiresAt, c.scheduledID)
+	if errors.Is(err, db.ErrAttachmentUnavailable) {
+		sendError(c, models.ErrorEvent{Code: models.ErrorInvalidRequest, Message: err.Error()})
+		return nil
+	}
+	if err != nil {
+		sendInternalError(c, "Error saving message", err)
+		return nil
+	}
+	message.ID = messageID
+	loadAttachments(repos, &message, req.AttachmentIDs)
+
+	// Broadcast message to all clients in lobby
+	broadcastMessage(c.lobbyID, message)
+
+	handleMentions(repos, message)
+	return &message
+}
+
+// handleReactionRequest adds or removes a reaction on a message in the connected lobby
+func handleReactionRequest(repos *Repositories, c *client, req models.MessageRequest) {
+	message, ok := getLobbyMessage(repos, c, req.MessageID)
+	if !ok {
+		return
+	}
+
+	add := req.Type == models.RequestReactionAdd
+	err := UpdateReaction(repos.Reactions, message, c.userID, req.Emoji, add)
+	switch {
+	case errors.Is(err, db.ErrInvalidEmoji):
+		sendError(c, models.ErrorEvent{Code: models.ErrorInvalidRequest, Message: "Invalid emoji"})
+	case errors.Is(err, ErrMessageDeleted):
+		sendError(c, models.ErrorEvent{Code: models.ErrorInvalidRequest, Message: "Message has been deleted"})
+	case err != nil:
+		sendInternalError(c, "Error updating reaction", err)
+	}
+}
+
+// getLobbyMessage gets a message a request refers to, sending the client an
+// error if it is missing or in another lobby
+func getLobbyMessage(repos *Repositories, c *client, messageID int) (*models.Message, bool) {
+	message, err := repos.Messages.GetMessageByID(messageID)
+	if errors.Is(err, db.ErrMessageNotFound) {
+		sendError(c, models.ErrorEvent{Code: models.ErrorInvalidRequest, Message: "Message not found"})
+		return nil, false
+	}
+	if err != nil {
+		sendInternalError(c, "Error fetching message", err)
+		return nil, false
+	}
+	if message.LobbyID != c.lobbyID {
+		sendError(c, models.ErrorEvent{Code: models.ErrorInvalidRequest, Message: "Message is not in this lobby"})
+		return nil, false
+	}
+	return message, true
+}
+
+// BroadcastEvent broadcasts a typed event to all clients in a lobby
+func BroadcastEvent(lobbyID int, event models.Event) {
+	event.LobbyID = lobbyID
+	broadcastToLobby(lobbyID, event)
+}
+
+// broadcastToLobby broadcasts a message or event to all clients in a lobby
+func broadcastToLobby(lobbyID int, msg interface{}) {
 	// Convert message to JSON
 	msgJSON, err := json.Marshal(msg)
 	if err != nil {
@@ -111,10 +375,50 @@ func broadcastToLobby(lobbyID int, msg models.Message) {
 	defer mutex.Unlock()
 
 	for conn := range lobbies[lobbyID] {
-		if err := conn.WriteMessage(websocket.TextMessage, msgJSON); err != nil {
-			log.Println("Error sending message:", err)
-			conn.Close()
-			delete(lobbies[lobbyID], conn)
+		writeLocked(lobbyID, conn, msgJSON)
+	}
+}
+
+// sendInternalError logs a failure on the server's side and tells the client
+// its request failed without the details
+func sendInternalError(c *client, message string, err error) {
+	log.Printf("%s: %v", message, err)
+	sendError(c, models.ErrorEvent{Code: models.ErrorInternal, Message: message})
+}
+
+// sendError sends an error event to a single client
+func sendError(c *client, event models.ErrorEvent) {
+	if c.conn == nil {
+		if c.onError != nil {
+			c.onError(event)
 		}
+		return
+	}
+
+	msgJSON, err := json.Marshal(models.Event{
+		Type:    models.EventError,
+		LobbyID: c.lobbyID,
+		Data:    event,
+	})
+	if err != nil {
+		log.Println("Error marshaling message:", err)
+		return
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if _, ok := lobbies[c.lobbyID][c.conn]; ok {
+		writeLocked(c.lobbyID, c.conn, msgJSON)
+	}
+}
+
+// writeLocked sends a payload to a connection, dropping the connection if
+// the write fails. The caller must hold mutex.
+func writeLocked(lobbyID int, conn *websocket.Conn, payload []byte) {
+	if err := conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+		log.Println("Error sending message:", err)
+		conn.Close()
+		delete(lobbies[lobbyID], conn)
 	}
 }
@@ -40,14 +40,14 @@ export default function LobbiesPage() {
   const handleCreateLobby = async (e: React.FormEvent) => {
     e.preventDefault();
     
-    if (!newLobbyName.trim()) return;
+    if (!newLobbyName.trim() || !user) return;
     
     setIsCreating(true);
     setError(null);
     
     try {
       const lobbyData: CreateLobbyRequest = { name: newLobbyName };
-      const newLobby = await lobbiesApi.createLobby(lobbyData);
+      const newLobby = await lobbiesApi.createLobby(lobbyData, user.id);
       
       // Add the new lobby to the list
       setLobbies((prevLobbies) => [...prevLobbies, newLobby]);
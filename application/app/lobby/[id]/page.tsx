@@ -27,13 +27,13 @@ export default function LobbyPage() {
 
   // Fetch lobby details
   useEffect(() => {
-    const fetchLobbyData = async () => {
+    const fetchLobbyData = async (userId: number) => {
       setIsLoading(true);
       setError(null);
       
       try {
         // Fetch lobby details
-        const lobby = await lobbiesApi.getLobby(lobbyId);
+        const lobby = await lobbiesApi.getLobby(lobbyId, userId);
         setCurrentLobby(lobby);
       } catch (err) {
         setError(err instanceof Error ? err.message : "Failed to load lobby data");
@@ -43,10 +43,10 @@ export default function LobbyPage() {
       }
     };
 
-    if (!isNaN(lobbyId)) {
-      fetchLobbyData();
+    if (!isNaN(lobbyId) && user) {
+      fetchLobbyData(user.id);
     }
-  }, [lobbyId]);
+  }, [lobbyId, user]);
 
   // Set up WebSocket connection
   useEffect(() => {
@@ -76,6 +76,16 @@ export default function LobbyPage() {
       });
     });
 
+    const messageDeletedUnsubscribe = webSocketClient.onMessageDeleted((messageId, deletedAt) => {
+      setMessages((prevMessages) => prevMessages.map(m =>
+        m.id === messageId ? { ...m, content: "", html: "", deleted_at: deletedAt } : m
+      ));
+    });
+
+    const messageRemovedUnsubscribe = webSocketClient.onMessageRemoved((messageId) => {
+      setMessages((prevMessages) => prevMessages.filter(m => m.id !== messageId));
+    });
+
     const userJoinedUnsubscribe = webSocketClient.onUserJoined((username) => {
       console.log("User joined:", username);
       setParticipants((prev) => {
@@ -97,6 +107,8 @@ export default function LobbyPage() {
     // Clean up WebSocket connection and event handlers
     return () => {
       messageUnsubscribe();
+      messageDeletedUnsubscribe();
+      messageRemovedUnsubscribe();
       userJoinedUnsubscribe();
       userLeftUnsubscribe();
       errorUnsubscribe();
@@ -268,7 +280,14 @@ export default function LobbyPage() {
                         {new Date(message.timestamp).toLocaleTimeString([], { hour: '2-digit', minute: '2-digit' })}
                       </span>
                     </div>
-                    <p>{message.content}</p>
+                    {message.deleted_at ? (
+                      <p className="italic opacity-75">This message was deleted</p>
+                    ) : message.html ? (
+                      // The server sanitizes message HTML, so it is safe to insert
+                      <div className="message-body" dangerouslySetInnerHTML={{ __html: message.html }} />
+                    ) : (
+                      <p>{message.content}</p>
+                    )}
                   </div>
                 </div>
               ))
@@ -25,7 +25,11 @@ export interface Message {
   user_id: number;
   username: string;
   content: string;
+  html?: string; // sanitized HTML rendered by the server from the Markdown content
   timestamp: string;
+  deleted_at?: string; // set on tombstones of deleted messages
+  parent_id?: number; // set on thread replies
+  expires_at?: string; // set on ephemeral messages
 }
 
 // Login request interface
@@ -120,20 +124,23 @@ class ApiClient {
     return response.json();
   }
 
-  async getLobby(id: number): Promise<Lobby> {
-    // Fetch all lobbies and find the one with the matching ID
-    const lobbies = await this.getLobbies();
-    const lobby = lobbies.find(lobby => lobby.id === id);
-    
-    if (!lobby) {
-      throw new Error(`Lobby with ID ${id} not found`);
+  async getLobby(id: number, userId: number): Promise<Lobby> {
+    // Private and archived lobbies are only found for users who can access them
+    const response = await fetch(`${API_URL}/lobbies/${id}?userID=${userId}`, {
+      credentials: 'include',
+    });
+
+    if (!response.ok) {
+      const errorData = await response.json();
+      throw new Error(errorData.message || `Lobby with ID ${id} not found`);
     }
-    
-    return lobby;
+
+    return response.json();
   }
 
-  async createLobby(data: CreateLobbyRequest): Promise<Lobby> {
-    const response = await fetch(`${API_URL}/lobbies`, {
+  async createLobby(data: CreateLobbyRequest, userId: number): Promise<Lobby> {
+    // The creator becomes the lobby's owner
+    const response = await fetch(`${API_URL}/lobbies?userID=${userId}`, {
       method: 'POST',
       headers: {
         'Content-Type': 'application/json',
@@ -169,7 +176,7 @@ export const api = new ApiClient();
 // Export a lobbies API for convenience
 export const lobbiesApi = {
   getLobbies: () => api.getLobbies(),
-  getLobby: (id: number) => api.getLobby(id),
-  createLobby: (data: CreateLobbyRequest) => api.createLobby(data),
+  getLobby: (id: number, userId: number) => api.getLobby(id, userId),
+  createLobby: (data: CreateLobbyRequest, userId: number) => api.createLobby(data, userId),
   getLobbyMessages: (lobbyId: number) => api.getLobbyMessages(lobbyId),
 }; 
\ No newline at end of file
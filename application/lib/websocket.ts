@@ -5,6 +5,13 @@ import { Message } from './api';
 // WebSocket URL
 const WS_URL = 'ws://localhost:8080/api/ws';
 
+// Payload fields of the events the client handles
+interface EventData {
+  message_id: number;
+  deleted_at: string;
+  message: string;
+}
+
 // WebSocket client class
 export class WebSocketClient {
   private socket: WebSocket | null = null;
@@ -19,6 +26,8 @@ export class WebSocketClient {
   private userJoinedListeners: ((username: string) => void)[] = [];
   private userLeftListeners: ((username: string) => void)[] = [];
   private errorListeners: ((error: string) => void)[] = [];
+  private messageDeletedListeners: ((messageId: number, deletedAt: string) => void)[] = [];
+  private messageRemovedListeners: ((messageId: number) => void)[] = [];
 
   // Connect to WebSocket server
   async connect(lobbyId: number, userId: number): Promise<void> {
@@ -46,9 +55,11 @@ export class WebSocketClient {
           try {
             console.log("Raw WebSocket message:", event.data);
             const message = JSON.parse(event.data);
-            
-            // The server sends Message objects directly, not wrapped in a type/data structure
-            if (message.user_id === 0 && message.username === "System") {
+
+            // Events are wrapped as {type, lobby_id, data}; chat messages are sent directly
+            if (typeof message.type === "string") {
+              this.handleEvent(message.type, message.data);
+            } else if (message.user_id === 0 && message.username === "System") {
               // This is a system message about a user joining or leaving
               if (message.content.includes("has joined")) {
                 const username = message.content.split(" has joined")[0];
@@ -62,10 +73,13 @@ export class WebSocketClient {
               const formattedMessage: Message = {
                 id: message.id,
                 content: message.content,
+                html: message.html,
                 user_id: message.user_id,
                 username: message.username,
                 lobby_id: message.lobby_id,
-                timestamp: message.timestamp // Keep as string to match the Message interface
+                timestamp: message.timestamp, // Keep as string to match the Message interface
+                parent_id: message.parent_id,
+                expires_at: message.expires_at
               };
               this.notifyMessageListeners(formattedMessage);
             }
@@ -97,6 +111,23 @@ export class WebSocketClient {
     });
   }
 
+  // Handle a typed event from the server. Events the client does not show are ignored.
+  private handleEvent(type: string, data: EventData) {
+    switch (type) {
+      case "message.deleted":
+        this.notifyMessageDeletedListeners(data.message_id, data.deleted_at);
+        break;
+      case "message.expired":
+        this.notifyMessageRemovedListeners(data.message_id);
+        break;
+      case "error":
+        this.notifyErrorListeners(data.message);
+        break;
+      default:
+        console.log("Ignoring WebSocket event:", type);
+    }
+  }
+
   // Attempt to reconnect with exponential backoff
   private attemptReconnect() {
     const delay = Math.min(1000 * Math.pow(2, this.reconnectAttempts), 30000);
@@ -156,6 +187,21 @@ export class WebSocketClient {
     };
   }
 
+  onMessageDeleted(callback: (messageId: number, deletedAt: string) => void): () => void {
+    this.messageDeletedListeners.push(callback);
+    return () => {
+      this.messageDeletedListeners = this.messageDeletedListeners.filter(cb => cb !== callback);
+    };
+  }
+
+  // Called when a message is removed for good, such as an ephemeral message expiring
+  onMessageRemoved(callback: (messageId: number) => void): () => void {
+    this.messageRemovedListeners.push(callback);
+    return () => {
+      this.messageRemovedListeners = this.messageRemovedListeners.filter(cb => cb !== callback);
+    };
+  }
+
   onUserJoined(callback: (username: string) => void): () => void {
     this.userJoinedListeners.push(callback);
     return () => {
@@ -182,6 +228,14 @@ export class WebSocketClient {
     this.messageListeners.forEach(listener => listener(message));
   }
 
+  private notifyMessageDeletedListeners(messageId: number, deletedAt: string): void {
+    this.messageDeletedListeners.forEach(listener => listener(messageId, deletedAt));
+  }
+
+  private notifyMessageRemovedListeners(messageId: number): void {
+    this.messageRemovedListeners.forEach(listener => listener(messageId));
+  }
+
   private notifyUserJoinedListeners(username: string): void {
     this.userJoinedListeners.forEach(listener => listener(username));
   }